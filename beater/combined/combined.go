@@ -0,0 +1,169 @@
+package combined
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ebay/collectbeat/beater/filebeat"
+	"github.com/ebay/collectbeat/beater/metricbeat"
+	"github.com/ebay/collectbeat/discoverer"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/factory/multi"
+	"github.com/joeshaw/multierror"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Collectbeat runs filebeat and metricbeat in one process and feeds both from a single
+// set of discoverers.
+type Collectbeat struct {
+	stopOnce    sync.Once
+	discoverers []*discoverer.DiscovererPlugin
+	lifecycle   *discoverer.Lifecycle
+	filebeat    *filebeat.Collectbeat
+	metricbeat  *metricbeat.Collectbeat
+	config      Config
+
+	// Each beat gets its own view of the shared beat so that the pipeline can be gated
+	gate   *ackGate
+	fbBeat *beat.Beat
+	mbBeat *beat.Beat
+}
+
+// New creates and returns a new combined Collectbeat instance.
+func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
+	config := defaultConfig
+	err := rawConfig.Unpack(&config)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading configuration file")
+	}
+
+	// Filebeat has to install its ACK handler before metricbeat connects to the pipeline
+	gate := newACKGate(b.Publisher)
+	fbBeat, mbBeat := *b, *b
+	fbBeat.Publisher = gate
+	mbBeat.Publisher = gate.gated()
+
+	fb, err := filebeat.New(&fbBeat, subConfig(config.Filebeat))
+	if err != nil {
+		return nil, fmt.Errorf("error initializing filebeat: %v", err)
+	}
+
+	mb, err := metricbeat.New(&mbBeat, subConfig(config.Metricbeat))
+	if err != nil {
+		return nil, fmt.Errorf("error initializing metricbeat: %v", err)
+	}
+
+	RegisterDefaultBuilderConfigs()
+	discoverers, err := discoverer.InitDiscoverers(config.Discoverers)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing discoverer: %v", err)
+	}
+
+	cb := &Collectbeat{
+		discoverers: discoverers,
+		lifecycle:   discoverer.NewLifecycle(discoverers),
+		filebeat:    fb.(*filebeat.Collectbeat),
+		metricbeat:  mb.(*metricbeat.Collectbeat),
+		config:      config,
+		gate:        gate,
+		fbBeat:      &fbBeat,
+		mbBeat:      &mbBeat,
+	}
+
+	if err = cb.lifecycle.ConfigureStatus(config.Status); err != nil {
+		return nil, err
+	}
+	if err = cb.lifecycle.ConfigureReload(config.Reload); err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// Run starts the discoverers and both beats and blocks until both beats have stopped.
+// If one of the beats fails, the other one is stopped as well.
+func (bt *Collectbeat) Run(b *beat.Beat) error {
+	if len(bt.discoverers) != 0 {
+		runner, err := bt.newFactory()
+		if err != nil {
+			return err
+		}
+
+		bt.lifecycle.Start(runner)
+	}
+
+	results := make(chan error, 2)
+	run := func(name string, beater beat.Beater, b *beat.Beat) {
+		err := beater.Run(b)
+		if err != nil {
+			err = fmt.Errorf("%s failed: %v", name, err)
+		}
+		results <- err
+	}
+
+	go func() {
+		run(Filebeat, bt.filebeat, bt.fbBeat)
+		// Do not block metricbeat if filebeat exits early
+		bt.gate.open()
+	}()
+	go run(Metricbeat, bt.metricbeat, bt.mbBeat)
+
+	var errs multierror.Errors
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			logp.Err("%v", err)
+			errs = append(errs, err)
+		}
+		// Either beat returning means the process is shutting down
+		bt.Stop()
+	}
+
+	return errs.Err()
+}
+
+// newFactory dispatches discovered configs to the factory of the beat they are routed to
+func (bt *Collectbeat) newFactory() (factory.Factory, error) {
+	fbFactory, err := bt.filebeat.NewFactory(bt.fbBeat)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create filebeat factory due to error: %v", err)
+	}
+
+	mbFactory, err := bt.metricbeat.NewFactory(bt.mbBeat)
+	if err != nil {
+		// The filebeat factory is not used, so that a retry can create it again
+		factory.Close(fbFactory)
+		return nil, fmt.Errorf("Unable to create metricbeat factory due to error: %v", err)
+	}
+
+	factories := map[string]factory.Factory{
+		Filebeat:   fbFactory,
+		Metricbeat: mbFactory,
+	}
+	return multi.New(factories, bt.config.Routes, Metricbeat), nil
+}
+
+// Stop shuts down discovery and then both beats. It is safe to call Stop more than once.
+func (bt *Collectbeat) Stop() {
+	bt.stopOnce.Do(func() {
+		bt.lifecycle.Stop()
+		bt.filebeat.Stop()
+		bt.metricbeat.Stop()
+	})
+}
+
+// RegisterDefaultBuilderConfigs registers the builders enabled by default for both beats
+func RegisterDefaultBuilderConfigs() {
+	filebeat.RegisterDefaultBuilderConfigs()
+	metricbeat.RegisterDefaultBuilderConfigs()
+}
+
+// subConfig returns the configuration of a beat, which may be left empty
+func subConfig(cfg *common.Config) *common.Config {
+	if cfg == nil {
+		return common.NewConfig()
+	}
+	return cfg
+}
@@ -0,0 +1,46 @@
+package combined
+
+import (
+	"fmt"
+
+	"github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/log_annotations"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Names of the beats that discovered configs are routed to
+const (
+	Filebeat   = "filebeat"
+	Metricbeat = "metricbeat"
+)
+
+type Config struct {
+	// Discoverers is a list of discoverer specific configuration data shared by both beats.
+	Discoverers map[string]*common.Config `config:"discovery"`
+	// Status configures the HTTP endpoint reporting the state of discovery
+	Status *common.Config `config:"discovery.status"`
+	// Reload points to discoverer config files that are watched for changes
+	Reload *common.Config `config:"discovery.config"`
+	// Routes maps builder names to the beat that runs their configs. Builders without a
+	// route are run by metricbeat.
+	Routes map[string]string `config:"discovery.routes"`
+	// Filebeat and Metricbeat hold the configuration of the beats run in this process
+	Filebeat   *common.Config `config:"filebeat"`
+	Metricbeat *common.Config `config:"metricbeat"`
+}
+
+var defaultConfig = Config{
+	Routes: map[string]string{
+		log_annotations.LogAnnotationsBuilder: Filebeat,
+	},
+}
+
+func (c *Config) Validate() error {
+	for builder, beat := range c.Routes {
+		if beat != Filebeat && beat != Metricbeat {
+			return fmt.Errorf("builder %s is routed to %s, routes must be one of %s or %s",
+				builder, beat, Filebeat, Metricbeat)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package combined
+
+import (
+	"sync"
+
+	"github.com/elastic/beats/libbeat/beat"
+)
+
+// ackGate holds back connections of other beats to the shared pipeline until filebeat
+// has installed its ACK handler, which the pipeline only accepts before the first client
+// connects.
+type ackGate struct {
+	beat.Pipeline
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newACKGate(pipeline beat.Pipeline) *ackGate {
+	return &ackGate{
+		Pipeline: pipeline,
+		ready:    make(chan struct{}),
+	}
+}
+
+func (g *ackGate) SetACKHandler(handler beat.PipelineACKHandler) error {
+	defer g.open()
+	return g.Pipeline.SetACKHandler(handler)
+}
+
+// open releases all waiting connections
+func (g *ackGate) open() {
+	g.once.Do(func() { close(g.ready) })
+}
+
+// gated returns a pipeline whose connections wait for the gate to open
+func (g *ackGate) gated() beat.Pipeline {
+	return &gatedPipeline{Pipeline: g.Pipeline, gate: g}
+}
+
+type gatedPipeline struct {
+	beat.Pipeline
+	gate *ackGate
+}
+
+func (p *gatedPipeline) Connect() (beat.Client, error) {
+	<-p.gate.ready
+	return p.Pipeline.Connect()
+}
+
+func (p *gatedPipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
+	<-p.gate.ready
+	return p.Pipeline.ConnectWith(cfg)
+}
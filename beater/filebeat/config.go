@@ -6,8 +6,16 @@ import (
 
 type Config struct {
 	// Discoverers is a list of discoverer specific configurationd data.
-	Discoverers      map[string]*common.Config `config:"discovery"`
-	ConfigProspector *common.Config            `config:"config.prospectors"`
+	Discoverers map[string]*common.Config `config:"discovery"`
+	// Status configures the HTTP endpoint reporting the state of discovery
+	Status *common.Config `config:"discovery.status"`
+	// Reload points to discoverer config files that are watched for changes
+	Reload *common.Config `config:"discovery.config"`
+	// Factory decides how discovered configs are run. Defaults to writing prospectors to
+	// config.prospectors and modules to config.modules
+	Factory          *common.Config `config:"discovery.factory"`
+	ConfigProspector *common.Config `config:"config.prospectors"`
+	ConfigModules    *common.Config `config:"config.modules"`
 }
 
 var defaultConfig = Config{}
@@ -2,26 +2,37 @@ package filebeat
 
 import (
 	"fmt"
-	"sync"
+	"path/filepath"
 
 	"github.com/ebay/collectbeat/discoverer"
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/factory/multi"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
 	"github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/log_annotations"
 
 	fbeater "github.com/elastic/beats/filebeat/beater"
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
 
 	"github.com/pkg/errors"
 
+	_ "github.com/ebay/collectbeat/processors/rate_limit"
+	_ "github.com/ebay/collectbeat/processors/rename"
 	_ "github.com/elastic/beats/filebeat/processor/add_kubernetes_metadata"
 )
 
+// Names of the factories running prospectors and modules
+const (
+	prospectors = "prospectors"
+	modules     = "modules"
+)
+
 // Collectbeat implements the Beater interface.
 type Collectbeat struct {
-	done        chan struct{} // Channel used to initiate shutdown.
 	discoverers []*discoverer.DiscovererPlugin
+	lifecycle   *discoverer.Lifecycle
 	staticbeat  beat.Beater
 	config      Config
 }
@@ -41,7 +52,7 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 	}
 
 	// Register default configs for builders
-	registerDefaultBuilderConfigs()
+	RegisterDefaultBuilderConfigs()
 	discoverers, err := discoverer.InitDiscoverers(config.Discoverers)
 
 	if err != nil {
@@ -49,80 +60,131 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 	}
 
 	cb := &Collectbeat{
-		done:        make(chan struct{}),
 		staticbeat:  filebeat,
 		config:      config,
 		discoverers: discoverers,
+		lifecycle:   discoverer.NewLifecycle(discoverers),
+	}
+
+	if err = cb.lifecycle.ConfigureStatus(config.Status); err != nil {
+		return nil, err
+	}
+	if err = cb.lifecycle.ConfigureReload(config.Reload); err != nil {
+		return nil, err
 	}
 	return cb, nil
 }
 
 func (bt *Collectbeat) Run(b *beat.Beat) error {
-	var wg sync.WaitGroup
-
-	if bt.config.ConfigProspector == nil {
-		rawProspectorConfig := map[string]interface{}{
-			"enabled": true,
-			"path":    "./prospectors.d/*.yml",
-			"reload": map[string]interface{}{
-				"enabled": true,
-				"period":  "5s",
-			},
+	if len(bt.discoverers) != 0 {
+		runner, err := bt.NewFactory(b)
+		if err != nil {
+			return err
 		}
 
-		conf, err := common.NewConfigFrom(rawProspectorConfig)
+		bt.lifecycle.Start(runner)
+	}
+
+	// Start up staticbeat modules
+	err := bt.staticbeat.Run(b)
+
+	// The beat may exit on its own, make sure discovery is shut down as well
+	bt.lifecycle.Stop()
+	return err
+}
+
+// NewFactory creates the factory that runs the prospector and module configs discovered for
+// filebeat
+func (bt *Collectbeat) NewFactory(b *beat.Beat) (factory.Factory, error) {
+	if bt.config.ConfigProspector == nil {
+		conf, err := reloaderConfig("./prospectors.d/*.yml")
 		if err != nil {
-			return fmt.Errorf("Unable to create prospectors config")
+			return nil, fmt.Errorf("Unable to create prospectors config")
 		}
 		bt.config.ConfigProspector = conf
 	}
-	if len(bt.discoverers) != 0 {
-		factoryRawConf := map[string]interface{}{
-			"name":            "cfgfile",
-			"reloader_config": bt.config.ConfigProspector,
-		}
 
-		factoryCfg, err := common.NewConfigFrom(factoryRawConf)
+	if bt.config.Factory != nil {
+		// Prospectors can only be run by filebeat's own reloader
+		runner, err := factory.InitFactory(bt.config.Factory, &factory.BeatMeta{
+			ReloaderConfig: bt.config.ConfigProspector,
+		})
 		if err != nil {
-			return fmt.Errorf("Factory config creation failed with error: %v", err)
+			return nil, err
 		}
+		return runner.Factory, nil
+	}
+
+	// Module configs are only run when filebeat reloads modules
+	if !bt.config.ConfigModules.Enabled() {
+		logp.Info("config.modules is not enabled, filebeat modules selected by pods can not be run")
+		return newCfgfileFactory(bt.config.ConfigProspector)
+	}
 
-		runner, err := factory.InitFactory(factoryCfg, nil)
+	// Modules are reloaded from their own directory. Both factories manage the files they wrote
+	// in their directory, which would remove each other's files in a shared one.
+	prospectorsPath, _ := bt.config.ConfigProspector.String("path", -1)
+	modulesPath, _ := bt.config.ConfigModules.String("path", -1)
+	if filepath.Dir(prospectorsPath) == filepath.Dir(modulesPath) {
+		return nil, fmt.Errorf("config.prospectors and config.modules need to point to different directories")
+	}
+
+	factories := map[string]factory.Factory{}
+	for name, reloader := range map[string]*common.Config{
+		prospectors: bt.config.ConfigProspector,
+		modules:     bt.config.ConfigModules,
+	} {
+		f, err := newCfgfileFactory(reloader)
 		if err != nil {
-			return err
+			for _, created := range factories {
+				factory.Close(created)
+			}
+			return nil, fmt.Errorf("Unable to create %s factory due to error: %v", name, err)
 		}
+		factories[name] = f
+	}
 
-		builder := &discoverer.Builders{}
-		builder.SetFactory(runner.Factory)
-
-		for _, disc := range bt.discoverers {
-			d := disc
-			go d.Discoverer.Start(builder)
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				<-bt.done
-				d.Discoverer.Stop()
-			}()
-		}
+	routes := map[string]string{dcommon.RouteModule: modules}
+	return multi.New(factories, routes, prospectors), nil
+}
+
+// newCfgfileFactory creates the default factory writing configs for a reloader
+func newCfgfileFactory(reloader *common.Config) (factory.Factory, error) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"name": factory.DefaultFactory,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Factory config creation failed with error: %v", err)
 	}
 
-	// Start up staticbeat modules
-	bt.staticbeat.Run(b)
+	runner, err := factory.InitFactory(cfg, &factory.BeatMeta{ReloaderConfig: reloader})
+	if err != nil {
+		return nil, err
+	}
+	return runner.Factory, nil
+}
 
-	return nil
+// reloaderConfig returns the config of a reloader watching path
+func reloaderConfig(path string) (*common.Config, error) {
+	return common.NewConfigFrom(map[string]interface{}{
+		"enabled": true,
+		"path":    path,
+		"reload": map[string]interface{}{
+			"enabled": true,
+			"period":  "5s",
+		},
+	})
 }
 
-// Stop signals to Collectbeat that it should stop. It closes the "done" channel
-// and closes the publisher client associated with each Module.
-//
-// Stop should only be called a single time. Calling it more than once may
-// result in undefined behavior.
+// Stop signals to Collectbeat that it should stop. Discovery is shut down first so that
+// in-flight events are processed and the factory is flushed while the beat is still running.
 func (bt *Collectbeat) Stop() {
+	bt.lifecycle.Stop()
 	bt.staticbeat.Stop()
 }
 
-func registerDefaultBuilderConfigs() {
+// RegisterDefaultBuilderConfigs registers the builders enabled by default for this beat
+func RegisterDefaultBuilderConfigs() {
 	cfg := common.NewConfig()
 	// Register default builders
 	registry.BuilderRegistry.AddDefaultBuilderConfig(log_annotations.LogAnnotationsBuilder, *cfg)
@@ -10,6 +10,12 @@ import (
 type Config struct {
 	// Discoverers is a list of discoverer specific configurationd data.
 	Discoverers map[string]*common.Config `config:"discovery"`
+	// Status configures the HTTP endpoint reporting the state of discovery
+	Status *common.Config `config:"discovery.status"`
+	// Reload points to discoverer config files that are watched for changes
+	Reload *common.Config `config:"discovery.config"`
+	// Factory decides how discovered configs are run. Defaults to writing them to config.modules
+	Factory *common.Config `config:"discovery.factory"`
 	// Upper bound on the random startup delay for metricsets (use 0 to disable startup delay).
 	MaxStartDelay time.Duration  `config:"max_start_delay"`
 	ConfigModules *common.Config `config:"config.modules"`
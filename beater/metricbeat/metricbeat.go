@@ -2,7 +2,6 @@ package metricbeat
 
 import (
 	"fmt"
-	"sync"
 
 	"github.com/ebay/collectbeat/discoverer"
 
@@ -15,6 +14,7 @@ import (
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
 	mbeater "github.com/elastic/beats/metricbeat/beater"
+	"github.com/elastic/beats/metricbeat/mb/module"
 
 	//Add collectbeat specific discoverers
 	_ "github.com/ebay/collectbeat/discoverer/kubernetes"
@@ -24,8 +24,8 @@ import (
 
 // Collectbeat implements the Beater interface.
 type Collectbeat struct {
-	done        chan struct{} // Channel used to initiate shutdown.
 	discoverers []*discoverer.DiscovererPlugin
+	lifecycle   *discoverer.Lifecycle
 	metricbeat  beat.Beater
 	config      Config
 }
@@ -44,7 +44,7 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 		return nil, errors.Wrap(err, "error reading configuration file")
 	}
 
-	registerDefaultBuilderConfigs()
+	RegisterDefaultBuilderConfigs()
 	discoverers, err := discoverer.InitDiscoverers(config.Discoverers)
 
 	if err != nil {
@@ -52,10 +52,17 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 	}
 
 	cb := &Collectbeat{
-		done:        make(chan struct{}),
 		metricbeat:  metricbeat,
 		config:      config,
 		discoverers: discoverers,
+		lifecycle:   discoverer.NewLifecycle(discoverers),
+	}
+
+	if err = cb.lifecycle.ConfigureStatus(config.Status); err != nil {
+		return nil, err
+	}
+	if err = cb.lifecycle.ConfigureReload(config.Reload); err != nil {
+		return nil, err
 	}
 	return cb, nil
 }
@@ -66,8 +73,25 @@ func New(b *beat.Beat, rawConfig *common.Config) (beat.Beater, error) {
 // that a single unresponsive host cannot inadvertently block other hosts
 // within the same Module and MetricSet from collection.
 func (bt *Collectbeat) Run(b *beat.Beat) error {
-	var wg sync.WaitGroup
+	if len(bt.discoverers) != 0 {
+		runner, err := bt.NewFactory(b)
+		if err != nil {
+			return err
+		}
+
+		bt.lifecycle.Start(runner)
+	}
+
+	// Start up metricbeat modules
+	err := bt.metricbeat.Run(b)
+
+	// The beat may exit on its own, make sure discovery is shut down as well
+	bt.lifecycle.Stop()
+	return err
+}
 
+// NewFactory creates the factory that runs the module configs discovered for metricbeat
+func (bt *Collectbeat) NewFactory(b *beat.Beat) (factory.Factory, error) {
 	if bt.config.ConfigModules == nil {
 		rawProspectorConfig := map[string]interface{}{
 			"enabled": true,
@@ -80,60 +104,43 @@ func (bt *Collectbeat) Run(b *beat.Beat) error {
 
 		conf, err := common.NewConfigFrom(rawProspectorConfig)
 		if err != nil {
-			return fmt.Errorf("Unable to create prospectors config")
+			return nil, fmt.Errorf("Unable to create prospectors config")
 		}
 		bt.config.ConfigModules = conf
 	}
 
-	if len(bt.discoverers) != 0 {
-		factoryRawConf := map[string]interface{}{
-			"name":            "cfgfile",
-			"reloader_config": bt.config.ConfigModules,
-		}
-
-		factoryCfg, err := common.NewConfigFrom(factoryRawConf)
+	factoryCfg := bt.config.Factory
+	if factoryCfg == nil {
+		cfg, err := common.NewConfigFrom(map[string]interface{}{
+			"name": factory.DefaultFactory,
+		})
 		if err != nil {
-			return fmt.Errorf("Factory config creation failed with error: %v", err)
-		}
-
-		runner, err := factory.InitFactory(factoryCfg, nil)
-		if err != nil {
-			return err
-		}
-
-		builder := &discoverer.Builders{}
-		builder.SetFactory(runner.Factory)
-
-		for _, disc := range bt.discoverers {
-			d := disc
-			go d.Discoverer.Start(builder)
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				<-bt.done
-				d.Discoverer.Stop()
-			}()
+			return nil, fmt.Errorf("Factory config creation failed with error: %v", err)
 		}
+		factoryCfg = cfg
 	}
 
-	// Start up metricbeat modules
-	bt.metricbeat.Run(b)
+	meta := &factory.BeatMeta{
+		RunnerFactory:  module.NewFactory(bt.config.MaxStartDelay, b.Publisher),
+		ReloaderConfig: bt.config.ConfigModules,
+	}
 
-	wg.Wait()
-	return nil
+	runner, err := factory.InitFactory(factoryCfg, meta)
+	if err != nil {
+		return nil, err
+	}
+	return runner.Factory, nil
 }
 
-// Stop signals to Collectbeat that it should stop. It closes the "done" channel
-// and closes the publisher client associated with each Module.
-//
-// Stop should only be called a single time. Calling it more than once may
-// result in undefined behavior.
+// Stop signals to Collectbeat that it should stop. Discovery is shut down first so that
+// in-flight events are processed and the factory is flushed while the beat is still running.
 func (bt *Collectbeat) Stop() {
+	bt.lifecycle.Stop()
 	bt.metricbeat.Stop()
-	close(bt.done)
 }
 
-func registerDefaultBuilderConfigs() {
+// RegisterDefaultBuilderConfigs registers the builders enabled by default for this beat
+func RegisterDefaultBuilderConfigs() {
 	cfg := common.NewConfig()
 	// Register default builders
 	registry.BuilderRegistry.AddDefaultBuilderConfig(metrics_annotations.AnnotationsBuilder, *cfg)
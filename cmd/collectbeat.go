@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"flag"
+
+	"github.com/ebay/collectbeat/beater/combined"
+
+	cmd "github.com/elastic/beats/libbeat/cmd"
+)
+
+// getCollectbeat returns the command running filebeat and metricbeat in a single process
+func getCollectbeat() *cmd.BeatsRootCmd {
+	// The beat keeps its name for the config file, data and indices, the command is run
+	rootCmd := renameRootCmd(cmd.GenRootCmd(Name, "", combined.New), "run")
+	rootCmd.Short = "Run filebeat and metricbeat with shared discovery"
+
+	// Flags of filebeat
+	rootCmd.PersistentFlags().AddGoFlag(flag.CommandLine.Lookup("M"))
+	rootCmd.TestCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("modules"))
+	rootCmd.SetupCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("modules"))
+	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("once"))
+	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("modules"))
+
+	// Flags of metricbeat
+	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("system.hostfs"))
+
+	rootCmd.AddCommand(genDiscoverCmd(Name, combined.RegisterDefaultBuilderConfigs))
+
+	return rootCmd
+}
+
+// renameRootCmd returns the root command of a beat under another name. Cobra caches the name of
+// a command once subcommands are added to it, so the generated command and its flags and
+// subcommands are moved to a new command instead of changing its Use.
+func renameRootCmd(beatCmd *cmd.BeatsRootCmd, use string) *cmd.BeatsRootCmd {
+	rootCmd := &cmd.BeatsRootCmd{
+		RunCmd:        beatCmd.RunCmd,
+		SetupCmd:      beatCmd.SetupCmd,
+		VersionCmd:    beatCmd.VersionCmd,
+		CompletionCmd: beatCmd.CompletionCmd,
+		ExportCmd:     beatCmd.ExportCmd,
+		TestCmd:       beatCmd.TestCmd,
+	}
+	rootCmd.Use = use
+	rootCmd.Run = beatCmd.Run
+
+	rootCmd.PersistentFlags().AddFlagSet(beatCmd.PersistentFlags())
+	rootCmd.Flags().AddFlagSet(beatCmd.Flags())
+	rootCmd.AddCommand(beatCmd.Commands()...)
+	return rootCmd
+}
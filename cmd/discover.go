@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ebay/collectbeat/discoverer"
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/factory/dryrun"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/libbeat/cmd/instance"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// discovery is considered done when no config changed for this long
+	discoverSettle = time.Second
+)
+
+// genDiscoverCmd initializes a command that runs the configured discoverers of a beat against
+// a dry run factory and prints the generated configs
+func genDiscoverCmd(name string, registerDefaults func()) *cobra.Command {
+	var timeout time.Duration
+	var once bool
+
+	discoverCmd := cobra.Command{
+		Use:   "discover",
+		Short: "Print the configs generated by discovery without running them",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := discover(name, registerDefaults, timeout, once, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running discovery: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	discoverCmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Time to run discovery for")
+	discoverCmd.Flags().BoolVar(&once, "once", false, "Exit as soon as the initial discovery is done")
+
+	return &discoverCmd
+}
+
+func discover(name string, registerDefaults func(), timeout time.Duration, once bool, out io.Writer) error {
+	b, err := instance.NewBeat(name, "", "")
+	if err != nil {
+		return errors.Wrap(err, "initializing beat")
+	}
+
+	if err = b.Init(); err != nil {
+		return errors.Wrap(err, "initializing beat")
+	}
+
+	beatConfig, err := b.BeatConfig()
+	if err != nil {
+		return err
+	}
+
+	config := struct {
+		Discoverers map[string]*common.Config `config:"discovery"`
+	}{}
+	if err = beatConfig.Unpack(&config); err != nil {
+		return errors.Wrap(err, "reading configuration file")
+	}
+
+	registerDefaults()
+	discoverers, err := discoverer.InitDiscoverers(config.Discoverers)
+	if err != nil {
+		return err
+	}
+
+	if len(discoverers) == 0 {
+		return fmt.Errorf("no discoverers are configured under %s.discovery", name)
+	}
+
+	recorder := dryrun.New()
+	lifecycle := discoverer.NewLifecycle(discoverers)
+	lifecycle.Start(recorder)
+
+	deadline := time.After(timeout)
+	if once {
+		select {
+		case <-lifecycle.Started():
+			waitForSettle(recorder, deadline)
+		case <-deadline:
+		}
+	} else {
+		<-deadline
+	}
+
+	err = printHolders(out, recorder.Holders())
+
+	lifecycle.Stop()
+	return err
+}
+
+// waitForSettle waits until the recorded configs stop changing or the deadline is reached
+func waitForSettle(recorder *dryrun.DryRunFactory, deadline <-chan time.Time) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	last, since := recorder.Len(), time.Now()
+	for {
+		select {
+		case <-deadline:
+			return
+		case <-ticker.C:
+			if current := recorder.Len(); current != last {
+				last, since = current, time.Now()
+			} else if time.Since(since) >= discoverSettle {
+				return
+			}
+		}
+	}
+}
+
+// printHolders prints the configs as YAML documents, one per pod and builder
+func printHolders(out io.Writer, holders []*dcommon.ConfigHolder) error {
+	if len(holders) == 0 {
+		fmt.Fprintln(out, "# No configs were generated")
+		return nil
+	}
+
+	for i := 0; i < len(holders); {
+		meta := holders[i].Meta
+		pod, builder := podName(meta), meta.GetString(dcommon.MetaBuilder)
+
+		configs := []common.MapStr{}
+		for ; i < len(holders); i++ {
+			next := holders[i].Meta
+			if podName(next) != pod || next.GetString(dcommon.MetaBuilder) != builder {
+				break
+			}
+			configs = append(configs, holders[i].Config)
+		}
+
+		bytes, err := yaml.Marshal(configs)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "---")
+		if pod != "" {
+			fmt.Fprintf(out, "# pod: %s\n", pod)
+		}
+		if builder != "" {
+			fmt.Fprintf(out, "# builder: %s\n", builder)
+		}
+		out.Write(bytes)
+	}
+	return nil
+}
+
+func podName(meta dcommon.Meta) string {
+	name := meta.GetString(dcommon.MetaPodName)
+	if name == "" {
+		return ""
+	}
+	return meta.GetString(dcommon.MetaNamespace) + "/" + name
+}
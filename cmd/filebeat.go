@@ -19,6 +19,7 @@ func getFilebeat() *cmd.BeatsRootCmd {
 	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("once"))
 	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("modules"))
 	rootCmd.AddCommand(cmd.GenModulesCmd(Filebeat, "", buildModulesManager))
+	rootCmd.AddCommand(genDiscoverCmd(Filebeat, filebeat.RegisterDefaultBuilderConfigs))
 
 	return rootCmd
 }
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ebay/collectbeat/discoverer/kubernetes/lint"
+	"github.com/spf13/cobra"
+)
+
+// genLintCmd initializes a command that checks collectbeat annotations in Kubernetes manifests
+func genLintCmd() *cobra.Command {
+	var builders []string
+
+	lintCmd := cobra.Command{
+		Use:   "lint [FILE...]",
+		Short: "Check collectbeat annotations in Kubernetes manifests",
+		Long: "Check the collectbeat annotations of the pod templates in Kubernetes manifests. " +
+			"Manifests are read from stdin when no file or - is given.",
+		Run: func(cmd *cobra.Command, args []string) {
+			problems, err := lintFiles(builders, args, os.Stdout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error linting manifests: %v\n", err)
+				os.Exit(2)
+			}
+			if problems != 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	lintCmd.Flags().StringSliceVar(&builders, "builder", lint.DefaultBuilders, "Builders to check annotations with")
+
+	return &lintCmd
+}
+
+// lintFiles lints all files and returns the number of problems found
+func lintFiles(builders, files []string, out io.Writer) (int, error) {
+	linter, err := lint.New(builders)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	objects, problems := 0, 0
+	for _, file := range files {
+		results, err := lintFile(linter, file)
+		if err != nil {
+			return problems, err
+		}
+
+		for _, result := range results {
+			objects++
+			for _, problem := range result.Problems {
+				problems++
+				fmt.Fprintf(out, "%s: %s: %s: %s\n", result.Source, result.Object, problem.Builder, problem)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "%d workloads checked, %d problems found\n", objects, problems)
+	return problems, nil
+}
+
+func lintFile(linter *lint.Linter, file string) ([]*lint.Result, error) {
+	if file == "-" {
+		return linter.Lint("stdin", os.Stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return linter.Lint(file, f)
+}
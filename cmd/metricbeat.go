@@ -22,6 +22,7 @@ func getMetricBeat() *cmd.BeatsRootCmd {
 
 	rootCmd.TestCmd.AddCommand(test.GenTestModulesCmd(Name, ""))
 	rootCmd.AddCommand(cmd.GenModulesCmd(Name, "", buildModulesManager))
+	rootCmd.AddCommand(genDiscoverCmd(Metricbeat, metricbeat.RegisterDefaultBuilderConfigs))
 	rootCmd.Flags().AddGoFlag(flag.CommandLine.Lookup("system.hostfs"))
 
 	return rootCmd
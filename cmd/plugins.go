@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ebay/collectbeat/beater/combined"
+	"github.com/ebay/collectbeat/beater/filebeat"
+	"github.com/ebay/collectbeat/beater/metricbeat"
+	"github.com/ebay/collectbeat/discoverer"
+	"github.com/spf13/cobra"
+)
+
+// defaultRegistrars register the builders and appenders each beat enables by default
+var defaultRegistrars = map[string]func(){
+	Filebeat:   filebeat.RegisterDefaultBuilderConfigs,
+	Metricbeat: metricbeat.RegisterDefaultBuilderConfigs,
+	Name:       combined.RegisterDefaultBuilderConfigs,
+}
+
+// genDiscoveryCmd initializes a command grouping discovery tooling
+func genDiscoveryCmd() *cobra.Command {
+	discoveryCmd := cobra.Command{
+		Use:   "discovery",
+		Short: "Inspect the discovery plugins compiled into " + Name,
+	}
+	discoveryCmd.AddCommand(genPluginsCmd())
+
+	return &discoveryCmd
+}
+
+// genPluginsCmd initializes a command that lists all discovery plugins with their settings
+func genPluginsCmd() *cobra.Command {
+	var beat string
+	var asJSON bool
+
+	pluginsCmd := cobra.Command{
+		Use:   "plugins",
+		Short: "List discoverers, builders, appenders and factories with their settings",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := listPlugins(beat, asJSON, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error listing plugins: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	pluginsCmd.Flags().StringVar(&beat, "beat", Name, "Beat whose default builders and appenders are shown (filebeat, metricbeat or collectbeat)")
+	pluginsCmd.Flags().BoolVar(&asJSON, "json", false, "Print the plugins as JSON")
+
+	return &pluginsCmd
+}
+
+func listPlugins(beat string, asJSON bool, out io.Writer) error {
+	registerDefaults, ok := defaultRegistrars[beat]
+	if !ok {
+		return fmt.Errorf("unknown beat '%s'", beat)
+	}
+	registerDefaults()
+
+	catalog := discoverer.Plugins()
+	if asJSON {
+		data, err := json.MarshalIndent(catalog, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+
+	printPlugins(out, "Discoverers", catalog.Discoverers)
+	printPlugins(out, "Builders", catalog.Builders)
+	printPlugins(out, "Appenders", catalog.Appenders)
+	printPlugins(out, "Factories", catalog.Factories)
+	return nil
+}
+
+func printPlugins(out io.Writer, title string, plugins []discoverer.PluginInfo) {
+	fmt.Fprintf(out, "%s:\n", title)
+	for _, plugin := range plugins {
+		name := plugin.Name
+		if plugin.EnabledByDefault {
+			name += " (enabled by default)"
+		}
+		fmt.Fprintf(out, "  %s\n", name)
+
+		if len(plugin.Defaults) != 0 {
+			flat := plugin.Defaults.Flatten()
+			keys := make([]string, 0, len(flat))
+			for key := range flat {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(out, "    default %s: %v\n", key, flat[key])
+			}
+		}
+
+		for _, field := range plugin.Schema {
+			details := []string{field.Type}
+			if field.Required {
+				details = append(details, "required")
+			}
+			if field.Default != nil {
+				details = append(details, fmt.Sprintf("default: %v", field.Default))
+			}
+			line := fmt.Sprintf("    %s (%s)", field.Name, strings.Join(details, ", "))
+			if field.Description != "" {
+				line += " " + field.Description
+			}
+			fmt.Fprintln(out, line)
+		}
+	}
+	fmt.Fprintln(out)
+}
@@ -13,4 +13,14 @@ func init() {
 	// Add filebeat as a collectbeat subcommand
 	filebeatCmd := getFilebeat().Command
 	RootCmd.AddCommand(&filebeatCmd)
+
+	// Add filebeat and metricbeat with shared discovery as a collectbeat subcommand
+	collectbeatCmd := getCollectbeat().Command
+	RootCmd.AddCommand(&collectbeatCmd)
+
+	// Add annotation linter as a collectbeat subcommand
+	RootCmd.AddCommand(genLintCmd())
+
+	// Add discovery plugin introspection as a collectbeat subcommand
+	RootCmd.AddCommand(genDiscoveryCmd())
 }
@@ -1,27 +1,40 @@
 package discoverer
 
 import (
+	"reflect"
 	"sync"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
 
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+var (
+	builderMetrics = metrics.Registry.NewRegistry("builders")
+	factoryErrors  = monitoring.NewInt(metrics.Registry, "factory.errors")
 )
 
 type Builders struct {
 	runnerFactory factory.Factory
+	// active records the holders the factory accepted and that were not stopped since
+	active *dcommon.HolderSet
 	sync.RWMutex
-	builders  []builder.Builder
+	builders []builder.Builder
+	// names holds the registry names builders were created from
+	names map[builder.Builder]string
+	// appenders are sorted by the order they run in
 	appenders []appender.Appender
 }
 
 func NewBuilder(builders []builder.Builder, appenders []appender.Appender) *Builders {
 	return &Builders{
 		builders:  builders,
-		appenders: appenders,
+		appenders: appender.Sort(appenders),
 	}
 }
 
@@ -29,8 +42,20 @@ func (b *Builders) AddBuilder(builder builder.Builder) {
 	b.builders = append(b.builders, builder)
 }
 
+// SetName records the registry name a builder was created from. Configs of the builder that
+// do not set the builder meta are recorded under it.
+func (b *Builders) SetName(build builder.Builder, name string) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.names == nil {
+		b.names = map[builder.Builder]string{}
+	}
+	b.names[build] = name
+}
+
 func (b *Builders) AddAppender(a appender.Appender) {
-	b.appenders = append(b.appenders, a)
+	b.appenders = appender.Sort(append(b.appenders, a))
 }
 
 // AppendConfigs appends additional configs to a metricbeat config
@@ -40,12 +65,76 @@ func (b *Builders) appendConfigs(configs []*dcommon.ConfigHolder) {
 	}
 }
 
+// appendConfig runs the appenders whose scope selects a config in order. It must be called
+// with the lock held.
 func (b *Builders) appendConfig(config *dcommon.ConfigHolder) {
-	b.RLock()
-	defer b.RUnlock()
+	if config == nil {
+		return
+	}
+
+	for _, a := range b.appenders {
+		if appender.Applies(a, config) {
+			a.Append(config)
+		}
+	}
+}
+
+// Default route keys of holders generated by poller and push builders
+const (
+	RoutePoll = "poll"
+	RoutePush = "push"
+)
+
+// setRoute sets the route key of holders unless the builder already did
+func setRoute(route string, holders ...*dcommon.ConfigHolder) {
+	for _, holder := range holders {
+		if holder == nil {
+			continue
+		}
+
+		if holder.Meta == nil {
+			holder.Meta = dcommon.Meta{}
+		}
+		if _, ok := holder.Meta[dcommon.MetaRoute]; !ok {
+			holder.Meta[dcommon.MetaRoute] = route
+		}
+	}
+}
+
+// countConfigs adds n to the configs generated by a builder. Builders without a registry name
+// are not counted.
+func (b *Builders) countConfigs(build builder.Builder, n int) {
+	if name := b.names[build]; name != "" {
+		metrics.Int(builderMetrics, name+".configs").Add(int64(n))
+	}
+}
+
+// setOrigin records the builder, kind and object holders were generated from unless the
+// builder already did. The builder name is taken from the builder meta if it is set and from
+// the registry name of the builder otherwise.
+func (b *Builders) setOrigin(build builder.Builder, obj interface{}, holders ...*dcommon.ConfigHolder) {
+	kind := ""
+	if kinder, ok := build.(builder.Kinder); ok {
+		kind = kinder.Kind()
+	}
+
+	for _, holder := range holders {
+		if holder == nil {
+			continue
+		}
 
-	for _, appender := range b.appenders {
-		appender.Append(config)
+		if holder.Builder == "" {
+			holder.Builder = holder.Meta.GetString(dcommon.MetaBuilder)
+		}
+		if holder.Builder == "" {
+			holder.Builder = b.names[build]
+		}
+		if holder.Kind == "" {
+			holder.Kind = kind
+		}
+		if holder.Source == nil {
+			holder.Source = obj
+		}
 	}
 }
 
@@ -57,23 +146,37 @@ func (b *Builders) StartModuleRunners(obj interface{}) {
 		switch bType := build.(type) {
 		case builder.PollerBuilder:
 			configs := bType.BuildModuleConfigs(obj)
+			setRoute(RoutePoll, configs...)
+			b.setOrigin(build, obj, configs...)
 			b.appendConfigs(configs)
+			b.countConfigs(build, len(configs))
 
 			err := b.runnerFactory.Start(configs)
 			if err != nil {
+				factoryErrors.Inc()
 				logp.Err("Module start up failed due to error %v", err)
 			}
+			b.active.Add(factory.Accepted(configs, err)...)
 		case builder.PushBuilder:
 			// Stop the older push metricset before starting an added configuration
 			oldCfg := bType.ModuleConfig()
+			setRoute(RoutePush, oldCfg)
+			b.setOrigin(build, nil, oldCfg)
 			b.appendConfig(oldCfg)
 
 			config := bType.AddModuleConfig(obj)
+			setRoute(RoutePush, config)
+			b.setOrigin(build, nil, config)
 			b.appendConfig(config)
+			b.countConfigs(build, 1)
 
 			err := b.runnerFactory.Restart(oldCfg, config)
+			b.active.Remove(oldCfg)
 			if err != nil {
+				factoryErrors.Inc()
 				logp.Err("Unable to restart module due to error %s", err)
+			} else {
+				b.active.Add(config)
 			}
 		default:
 			logp.Err("Unsupported builder type %v", bType)
@@ -85,27 +188,40 @@ func (b *Builders) StopModuleRunners(obj interface{}) {
 	b.RLock()
 	defer b.RUnlock()
 
+	// The configs started for obj are stopped rather than generated again, builders and
+	// appenders may generate different configs by now
+	if configs := b.started(obj); len(configs) != 0 {
+		err := b.runnerFactory.Stop(configs)
+		if err != nil {
+			factoryErrors.Inc()
+			logp.Err("Module stop failed due to error %v", err)
+		}
+		b.active.Remove(configs...)
+	}
+
 	for _, build := range b.builders {
 		switch bType := build.(type) {
 		case builder.PollerBuilder:
-			configs := bType.BuildModuleConfigs(obj)
-			b.appendConfigs(configs)
-
-			err := b.runnerFactory.Stop(configs)
-			if err != nil {
-				logp.Err("Module stop failed due to error %v", err)
-			}
+			// Stopped above
 		case builder.PushBuilder:
 			// Stop the older push metricset before starting a metricset with removed configuration
 			oldCfg := bType.ModuleConfig()
+			setRoute(RoutePush, oldCfg)
+			b.setOrigin(build, nil, oldCfg)
 			b.appendConfig(oldCfg)
 
 			config := bType.RemoveModuleConfig(obj)
+			setRoute(RoutePush, config)
+			b.setOrigin(build, nil, config)
 			b.appendConfig(config)
 
 			err := b.runnerFactory.Restart(oldCfg, config)
+			b.active.Remove(oldCfg)
 			if err != nil {
+				factoryErrors.Inc()
 				logp.Err("Unable to restart module due to error %s", err)
+			} else {
+				b.active.Add(config)
 			}
 		default:
 			logp.Err("Unsupported builder type %v", bType)
@@ -113,6 +229,204 @@ func (b *Builders) StopModuleRunners(obj interface{}) {
 	}
 }
 
+// Reload replaces the builders and appenders and moves the configs generated for objs over.
+// Running configs that the new builders generate again keep running, the others are stopped
+// or started. Builders present in both lists are expected to already know about objs, new
+// push builders are fed all objs before their config is started.
+func (b *Builders) Reload(builders []builder.Builder, appenders []appender.Appender, objs []interface{}) {
+	b.Lock()
+	defer b.Unlock()
+
+	old := b.running(objs)
+
+	known := map[builder.Builder]bool{}
+	for _, build := range b.builders {
+		known[build] = true
+	}
+	wanted := map[builder.Builder]bool{}
+	for _, build := range builders {
+		wanted[build] = true
+		if push, ok := build.(builder.PushBuilder); ok && !known[build] {
+			for _, obj := range objs {
+				push.AddModuleConfig(obj)
+			}
+		}
+	}
+
+	// Names of removed builders are dropped, the ones of added builders are set before
+	for build := range b.names {
+		if !wanted[build] {
+			delete(b.names, build)
+		}
+	}
+
+	b.builders, b.appenders = builders, appender.Sort(appenders)
+	stopped, started := b.replace(old, b.generate(objs))
+	logp.Info("Reloaded builders, stopping %d and starting %d configs", stopped, started)
+}
+
+// Refresh restarts the configs that appenders now append differently for objs, such as
+// configs with credentials that were rotated. Nothing is restarted unless an appender
+// reports a change.
+func (b *Builders) Refresh(objs []interface{}) {
+	b.Lock()
+	defer b.Unlock()
+
+	changed := false
+	for _, a := range b.appenders {
+		if refresher, ok := a.(appender.Refresher); ok && refresher.Changed() {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// Only configs of objs are compared, configs of other discoverers are left alone
+	stopped, started := b.replace(b.running(objs), b.generate(objs))
+	logp.Info("Refreshed appenders, stopping %d and starting %d configs", stopped, started)
+}
+
+// replace stops the configs of old that are not in current and starts the configs of current
+// that are not in old. It returns the number of stopped and started configs and must be
+// called with the lock held.
+func (b *Builders) replace(old, current map[string]*dcommon.ConfigHolder) (int, int) {
+	stopped := []*dcommon.ConfigHolder{}
+	for id, holder := range old {
+		if _, ok := current[id]; !ok {
+			stopped = append(stopped, holder)
+		}
+	}
+
+	started := []*dcommon.ConfigHolder{}
+	for id, holder := range current {
+		if _, ok := old[id]; !ok {
+			started = append(started, holder)
+		}
+	}
+
+	if err := b.runnerFactory.Stop(stopped); err != nil {
+		factoryErrors.Inc()
+		logp.Err("Module stop failed due to error %v", err)
+	}
+	b.active.Remove(stopped...)
+
+	err := b.runnerFactory.Start(started)
+	if err != nil {
+		factoryErrors.Inc()
+		logp.Err("Module start up failed due to error %v", err)
+	}
+	b.active.Add(factory.Accepted(started, err)...)
+	return len(stopped), len(started)
+}
+
+// running returns the running configs that were generated for objs or by push builders keyed
+// by holderKey. It must be called with the lock held.
+func (b *Builders) running(objs []interface{}) map[string]*dcommon.ConfigHolder {
+	sources := map[interface{}]bool{}
+	for _, obj := range objs {
+		if obj != nil && reflect.TypeOf(obj).Comparable() {
+			sources[obj] = true
+		}
+	}
+
+	holders := map[string]*dcommon.ConfigHolder{}
+	if b.active == nil {
+		return holders
+	}
+	for _, holder := range b.active.List() {
+		source := holder.Source
+		if source != nil && (!reflect.TypeOf(source).Comparable() || !sources[source]) {
+			continue
+		}
+
+		id, err := holderKey(holder)
+		if err != nil {
+			logp.Err("Unable to hash config due to error: %v", err)
+			continue
+		}
+		holders[id] = holder
+	}
+	return holders
+}
+
+// started returns the running configs that poller builders generated for obj. It must be
+// called with the lock held.
+func (b *Builders) started(obj interface{}) []*dcommon.ConfigHolder {
+	holders := []*dcommon.ConfigHolder{}
+	for _, holder := range b.running([]interface{}{obj}) {
+		if holder.Source != nil {
+			holders = append(holders, holder)
+		}
+	}
+	return holders
+}
+
+// generate returns the configs the current builders and appenders produce for objs keyed by
+// holderKey. It must be called with the lock held.
+func (b *Builders) generate(objs []interface{}) map[string]*dcommon.ConfigHolder {
+	holders := map[string]*dcommon.ConfigHolder{}
+	add := func(holder *dcommon.ConfigHolder) {
+		if holder == nil || len(holder.Config) == 0 {
+			return
+		}
+
+		id, err := holderKey(holder)
+		if err != nil {
+			logp.Err("Unable to hash config due to error: %v", err)
+			return
+		}
+		holders[id] = holder
+	}
+
+	for _, build := range b.builders {
+		switch bType := build.(type) {
+		case builder.PollerBuilder:
+			for _, obj := range objs {
+				configs := bType.BuildModuleConfigs(obj)
+				setRoute(RoutePoll, configs...)
+				b.setOrigin(build, obj, configs...)
+				for _, config := range configs {
+					b.appendConfig(config)
+					add(config)
+				}
+			}
+		case builder.PushBuilder:
+			if config := bType.ModuleConfig(); config != nil {
+				setRoute(RoutePush, config)
+				b.setOrigin(build, nil, config)
+				b.appendConfig(config)
+				add(config)
+			}
+		}
+	}
+	return holders
+}
+
+// Validate collects the problems that all validating builders find in obj
+func (b *Builders) Validate(obj interface{}) []*builder.ValidationError {
+	b.RLock()
+	defer b.RUnlock()
+
+	var errs []*builder.ValidationError
+	for _, build := range b.builders {
+		if validator, ok := build.(builder.Validator); ok {
+			errs = append(errs, validator.Validate(obj)...)
+		}
+	}
+	return errs
+}
+
 func (b *Builders) SetFactory(factory factory.Factory) {
 	b.runnerFactory = factory
+	b.active = newTracker()
+}
+
+// Holders returns the configs that the factory currently runs grouped by namespace, pod and
+// builder
+func (b *Builders) Holders() []*dcommon.ConfigHolder {
+	if b.active == nil {
+		return nil
+	}
+	return b.active.List()
 }
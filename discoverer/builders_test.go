@@ -0,0 +1,450 @@
+package discoverer
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/factory/dryrun"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestBuildersReload(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	logs := &moduleBuilder{name: "logs"}
+	objs := []interface{}{"web", "db"}
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{metrics}, nil)
+	b.SetFactory(recorder)
+	for _, obj := range objs {
+		b.StartModuleRunners(obj)
+	}
+	assert.Equal(t, []string{"metrics/db", "metrics/web"}, modules(recorder))
+
+	// Adding a builder starts its configs only
+	started := recorder.Holders()
+	b.Reload([]builder.Builder{metrics, logs}, nil, objs)
+	assert.Equal(t, []string{"logs/db", "logs/web", "metrics/db", "metrics/web"}, modules(recorder))
+	for _, holder := range started {
+		assert.Contains(t, recorder.Holders(), holder)
+	}
+
+	// Changing the appenders restarts all configs
+	b.Reload([]builder.Builder{metrics, logs}, []appender.Appender{&tagAppender{}}, objs)
+	for _, holder := range recorder.Holders() {
+		assert.Equal(t, "reloaded", holder.Config["tag"])
+	}
+	assert.Equal(t, 4, recorder.Len())
+
+	// Removing a builder stops its configs
+	b.Reload([]builder.Builder{logs}, []appender.Appender{&tagAppender{}}, objs)
+	assert.Equal(t, []string{"logs/db", "logs/web"}, modules(recorder))
+
+	// Pods added later use the new builders
+	b.StartModuleRunners("cache")
+	assert.Equal(t, []string{"logs/cache", "logs/db", "logs/web"}, modules(recorder))
+}
+
+func TestBuildersReloadPushBuilder(t *testing.T) {
+	recorder := dryrun.New()
+	b := NewBuilder(nil, nil)
+	b.SetFactory(recorder)
+
+	push := &pushBuilder{}
+	b.Reload([]builder.Builder{push}, nil, []interface{}{"web", "db"})
+
+	// New push builders learn about all objects before their config is started
+	assert.Equal(t, []string{"web", "db"}, push.objs)
+	if assert.Equal(t, 1, recorder.Len()) {
+		assert.Equal(t, RoutePush, recorder.Holders()[0].Meta.GetString(dcommon.MetaRoute))
+	}
+
+	b.Reload(nil, nil, []interface{}{"web", "db"})
+	assert.Equal(t, 0, recorder.Len())
+}
+
+func TestBuildersRefresh(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	objs := []interface{}{"web", "db"}
+	token := &tokenAppender{token: "a"}
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{metrics}, []appender.Appender{token})
+	b.SetFactory(recorder)
+	for _, obj := range objs {
+		b.StartModuleRunners(obj)
+	}
+	started := recorder.Holders()
+
+	// Nothing is restarted while the appender reports no change
+	b.Refresh(objs)
+	assert.Equal(t, started, recorder.Holders())
+
+	token.token, token.changed = "b", true
+	b.Refresh(objs)
+	assert.Equal(t, 2, recorder.Len())
+	for _, holder := range recorder.Holders() {
+		assert.Equal(t, "b", holder.Config["token"])
+	}
+
+	// Configs of objects that are not refreshed, such as those of other discoverers, are kept
+	b.StartModuleRunners("cache")
+	token.token, token.changed = "c", true
+	b.Refresh(objs)
+	assert.Equal(t, []string{"metrics/cache", "metrics/db", "metrics/web"}, modules(recorder))
+	for _, holder := range recorder.Holders() {
+		if holder.Source == "cache" {
+			assert.Equal(t, "b", holder.Config["token"])
+		} else {
+			assert.Equal(t, "c", holder.Config["token"])
+		}
+	}
+}
+
+func TestBuildersReloadBuildsOnce(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	objs := []interface{}{"web", "db"}
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{metrics}, nil)
+	b.SetFactory(recorder)
+	for _, obj := range objs {
+		b.StartModuleRunners(obj)
+	}
+
+	// The running configs are known, only the new builders generate configs
+	logs := &moduleBuilder{name: "logs"}
+	metrics.calls = 0
+	b.Reload([]builder.Builder{metrics, logs}, nil, objs)
+	assert.Equal(t, 2, metrics.calls)
+	assert.Equal(t, 2, logs.calls)
+	assert.Equal(t, 4, recorder.Len())
+}
+
+func TestBuildersIdenticalConfigs(t *testing.T) {
+	first := &staticBuilder{name: "first"}
+	second := &staticBuilder{name: "second"}
+	objs := []interface{}{"web", "db"}
+
+	b := NewBuilder([]builder.Builder{first, second}, nil)
+	b.SetFactory(&failingFactory{})
+	for _, obj := range objs {
+		b.StartModuleRunners(obj)
+	}
+
+	// The same config generated for different pods and builders is tracked once for each
+	assert.Len(t, b.Holders(), 4)
+
+	b.Reload([]builder.Builder{first}, nil, objs)
+	assert.Len(t, b.Holders(), 2)
+	for _, holder := range b.Holders() {
+		assert.Equal(t, "first", holder.Builder)
+	}
+
+	b.StopModuleRunners("web")
+	if assert.Len(t, b.Holders(), 1) {
+		assert.Equal(t, "db", b.Holders()[0].Source)
+	}
+}
+
+func TestBuildersTrackAcceptedHolders(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	fac := &failingFactory{err: errors.New("no runner")}
+
+	b := NewBuilder([]builder.Builder{metrics}, nil)
+	b.SetFactory(fac)
+
+	// Holders the factory failed to start are not reported as running
+	b.StartModuleRunners("web")
+	assert.Len(t, b.Holders(), 0)
+
+	fac.err = nil
+	b.StartModuleRunners("web")
+	if assert.Len(t, b.Holders(), 1) {
+		assert.Equal(t, "metrics/web", b.Holders()[0].Config["module"])
+	}
+
+	b.StopModuleRunners("web")
+	assert.Len(t, b.Holders(), 0)
+}
+
+func TestBuildersStopStartedConfigs(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	token := &tokenAppender{token: "a"}
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{metrics}, []appender.Appender{token})
+	b.SetFactory(recorder)
+	b.StartModuleRunners("web")
+	b.StartModuleRunners("db")
+
+	// The configs started for an object are stopped although they would be appended
+	// differently now
+	token.token = "b"
+	metrics.calls = 0
+	b.StopModuleRunners("web")
+	assert.Equal(t, 0, metrics.calls)
+	assert.Equal(t, []string{"metrics/db"}, modules(recorder))
+	assert.Len(t, b.Holders(), 1)
+}
+
+func TestBuildersTrackPartialStarts(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics"}
+	logs := &moduleBuilder{name: "logs"}
+	fac := &rejectingFactory{module: "logs/web"}
+
+	b := NewBuilder([]builder.Builder{metrics, logs}, nil)
+	b.SetFactory(fac)
+
+	// Holders the factory kept are tracked although the batch failed
+	b.StartModuleRunners("web")
+	if assert.Len(t, b.Holders(), 1) {
+		assert.Equal(t, "metrics/web", b.Holders()[0].Config["module"])
+	}
+
+	// and are stopped once their builder is removed
+	b.Reload([]builder.Builder{logs}, nil, []interface{}{"web"})
+	assert.Equal(t, []string{"metrics/web"}, fac.stopped)
+}
+
+func TestBuildersAppenderOrder(t *testing.T) {
+	metrics := &moduleBuilder{name: "metrics", kind: dcommon.KindMetrics}
+	logs := &moduleBuilder{name: "logs", kind: dcommon.KindLogs}
+
+	configure := func(a appender.Appender, raw map[string]interface{}) appender.Appender {
+		cfg, err := common.NewConfigFrom(raw)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		configured, err := appender.Configure(a, cfg)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return configured
+	}
+
+	// Appenders run by order, appenders of the same order as configured
+	appenders := []appender.Appender{
+		&trailAppender{name: "late", order: 10},
+		configure(&trailAppender{name: "early", order: 10}, map[string]interface{}{"order": -1}),
+		&trailAppender{name: "first"},
+		&trailAppender{name: "second"},
+		configure(&trailAppender{name: "logs"}, map[string]interface{}{"scope.kinds": []string{"logs"}}),
+		configure(&trailAppender{name: "db"}, map[string]interface{}{"scope.modules": []string{"metrics/db"}}),
+		configure(&trailAppender{name: "metrics"}, map[string]interface{}{"scope.builders": []string{"metrics"}}),
+	}
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{metrics, logs}, appenders)
+	b.SetFactory(recorder)
+	b.StartModuleRunners("web")
+	b.StartModuleRunners("db")
+
+	trails := map[string][]string{}
+	for _, holder := range recorder.Holders() {
+		trails[holder.Config["module"].(string)] = holder.Config["trail"].([]string)
+
+		// Holders know where they come from
+		assert.Equal(t, holder.Meta.GetString(dcommon.MetaBuilder), holder.Builder)
+		assert.Equal(t, holder.Meta.GetString(dcommon.MetaPodName), holder.Source)
+		assert.Equal(t, holder.Builder, holder.Kind)
+	}
+	assert.Equal(t, map[string][]string{
+		"metrics/web": {"early", "first", "second", "metrics", "late"},
+		"metrics/db":  {"early", "first", "second", "db", "metrics", "late"},
+		"logs/web":    {"early", "first", "second", "logs", "late"},
+		"logs/db":     {"early", "first", "second", "logs", "late"},
+	}, trails)
+
+	// Invalid kinds are rejected
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"scope.kinds": []string{"traces"}})
+	assert.Nil(t, err)
+	_, err = appender.Configure(&trailAppender{}, cfg)
+	assert.NotNil(t, err)
+}
+
+func TestBuildersRegistryNames(t *testing.T) {
+	named, unnamed := &pushBuilder{}, &pushBuilder{}
+
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"scope.builders": []string{"graphite"}})
+	assert.Nil(t, err)
+	scoped, err := appender.Configure(&trailAppender{name: "graphite"}, cfg)
+	assert.Nil(t, err)
+
+	recorder := dryrun.New()
+	b := NewBuilder([]builder.Builder{named}, []appender.Appender{scoped})
+	b.SetName(named, "graphite")
+	b.SetFactory(recorder)
+	b.StartModuleRunners("web")
+
+	// Configs without builder meta are recorded under the registry name, not the display name
+	holders := recorder.Holders()
+	if assert.Len(t, holders, 1) {
+		assert.Equal(t, "graphite", holders[0].Builder)
+		assert.Equal(t, []string{"graphite"}, holders[0].Config["trail"])
+	}
+	assert.Equal(t, int64(1), metrics.Int(builderMetrics, "graphite.configs").Get())
+
+	// Names of removed builders are dropped
+	b.Reload([]builder.Builder{unnamed}, nil, []interface{}{"web"})
+	assert.Empty(t, b.names)
+	holders = recorder.Holders()
+	if assert.Len(t, holders, 1) {
+		assert.Equal(t, "", holders[0].Builder)
+	}
+}
+
+func modules(recorder *dryrun.DryRunFactory) []string {
+	out := []string{}
+	for _, holder := range recorder.Holders() {
+		out = append(out, holder.Config["module"].(string))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// moduleBuilder generates one config per object named after the builder and the object
+type moduleBuilder struct {
+	name  string
+	kind  string
+	calls int
+}
+
+func (m *moduleBuilder) Name() string { return m.name }
+
+func (m *moduleBuilder) Kind() string { return m.kind }
+
+func (m *moduleBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
+	m.calls++
+	return []*dcommon.ConfigHolder{
+		{
+			Config: common.MapStr{"module": m.name + "/" + obj.(string)},
+			Meta: dcommon.Meta{
+				dcommon.MetaPodName: obj.(string),
+				dcommon.MetaBuilder: m.name,
+			},
+		},
+	}
+}
+
+// staticBuilder generates the same config for every object
+type staticBuilder struct {
+	name string
+}
+
+func (s *staticBuilder) Name() string { return s.name }
+
+func (s *staticBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
+	return []*dcommon.ConfigHolder{
+		{
+			Config: common.MapStr{"module": "static"},
+			Meta: dcommon.Meta{
+				dcommon.MetaPodName: obj.(string),
+				dcommon.MetaBuilder: s.name,
+			},
+		},
+	}
+}
+
+// pushBuilder keeps one config listing all objects it was given
+type pushBuilder struct {
+	objs []string
+}
+
+func (p *pushBuilder) Name() string { return "push" }
+
+func (p *pushBuilder) AddModuleConfig(obj interface{}) *dcommon.ConfigHolder {
+	p.objs = append(p.objs, obj.(string))
+	return p.ModuleConfig()
+}
+
+func (p *pushBuilder) RemoveModuleConfig(obj interface{}) *dcommon.ConfigHolder {
+	return p.ModuleConfig()
+}
+
+func (p *pushBuilder) ModuleConfig() *dcommon.ConfigHolder {
+	return &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "push", "objs": append([]string{}, p.objs...)},
+	}
+}
+
+type failingFactory struct {
+	err error
+}
+
+func (f *failingFactory) Start(holders []*dcommon.ConfigHolder) error { return f.err }
+
+func (f *failingFactory) Stop(holders []*dcommon.ConfigHolder) error { return nil }
+
+func (f *failingFactory) Restart(old, new *dcommon.ConfigHolder) error { return f.err }
+
+// rejectingFactory keeps all holders but the ones of module
+type rejectingFactory struct {
+	module  string
+	stopped []string
+}
+
+func (f *rejectingFactory) Start(holders []*dcommon.ConfigHolder) error {
+	var failed []*dcommon.ConfigHolder
+	for _, holder := range holders {
+		if holder.Config["module"] == f.module {
+			failed = append(failed, holder)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &factory.StartError{Failed: failed, Err: errors.New("rejected " + f.module)}
+}
+
+func (f *rejectingFactory) Stop(holders []*dcommon.ConfigHolder) error {
+	for _, holder := range holders {
+		f.stopped = append(f.stopped, holder.Config["module"].(string))
+	}
+	return nil
+}
+
+func (f *rejectingFactory) Restart(old, new *dcommon.ConfigHolder) error { return nil }
+
+type tagAppender struct{}
+
+func (t *tagAppender) Append(holder *dcommon.ConfigHolder) {
+	holder.Config["tag"] = "reloaded"
+}
+
+// tokenAppender adds a token that can be rotated
+type tokenAppender struct {
+	token   string
+	changed bool
+}
+
+func (t *tokenAppender) Append(holder *dcommon.ConfigHolder) {
+	holder.Config["token"] = t.token
+}
+
+func (t *tokenAppender) Changed() bool {
+	changed := t.changed
+	t.changed = false
+	return changed
+}
+
+// trailAppender records its name in the configs it appends to
+type trailAppender struct {
+	name  string
+	order int
+}
+
+func (t *trailAppender) Append(holder *dcommon.ConfigHolder) {
+	trail, _ := holder.Config["trail"].([]string)
+	holder.Config["trail"] = append(trail, t.name)
+}
+
+func (t *trailAppender) Order() int { return t.order }
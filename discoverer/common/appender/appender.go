@@ -2,6 +2,8 @@ package appender
 
 import (
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
 
 	"github.com/elastic/beats/libbeat/common"
 )
@@ -11,4 +13,33 @@ type Appender interface {
 	Append(config *dcommon.ConfigHolder)
 }
 
+// Refresher is implemented by appenders whose output can change while the objects configs
+// are built for do not, such as appenders adding credentials that are rotated.
+type Refresher interface {
+	// Changed reports whether configs appended before would now be appended differently
+	Changed() bool
+}
+
 type AppenderConstructor func(config *common.Config) (Appender, error)
+
+// ClientAppenderConstructor creates appenders that need the clients of the discoverer, such as
+// the kubernetes client
+type ClientAppenderConstructor func(config *common.Config, clientInfo builder.ClientInfo) (Appender, error)
+
+// WithClient returns a ClientAppenderConstructor calling constructor without the clients
+func WithClient(constructor AppenderConstructor) ClientAppenderConstructor {
+	if constructor == nil {
+		return nil
+	}
+	return func(config *common.Config, _ builder.ClientInfo) (Appender, error) {
+		return constructor(config)
+	}
+}
+
+var appenderMetrics = metrics.Registry.NewRegistry("appenders")
+
+// ReportError counts a config the named appender failed to append to. Appenders log and skip
+// such configs, the counter makes these failures visible in the beat's metrics.
+func ReportError(name string) {
+	metrics.Int(appenderMetrics, name+".errors").Inc()
+}
@@ -0,0 +1,109 @@
+package appender
+
+import (
+	"fmt"
+	"strings"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Rule selects configs by their scope and the namespace and labels of the pod they were
+// generated for. Empty conditions match all configs. Appenders embed it in their rules with
+// `config:",inline"`.
+type Rule struct {
+	Scope      `config:",inline"`
+	Namespaces []string          `config:"namespaces"`
+	Labels     map[string]string `config:"labels"`
+}
+
+// Matches returns whether the rule selects holder
+func (r *Rule) Matches(holder *dcommon.ConfigHolder) bool {
+	if !r.Scope.Matches(holder) {
+		return false
+	}
+
+	meta := holder.Meta
+	if len(r.Namespaces) != 0 && !Contains(r.Namespaces, meta.GetString(dcommon.MetaNamespace)) {
+		return false
+	}
+
+	labels, _ := meta[dcommon.MetaLabels].(map[string]string)
+	for key, value := range r.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ModuleName returns the module of a config or the type of a prospector config
+func ModuleName(config common.MapStr) string {
+	module, ok := config["module"]
+	if !ok {
+		module, ok = config["type"]
+	}
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(module)
+}
+
+// Targets returns the parts of a config that settings such as processors and fields are
+// added to. Filebeat modules take them per fileset, other configs as a whole.
+func Targets(holder *dcommon.ConfigHolder) []common.MapStr {
+	if holder.Meta.GetString(dcommon.MetaRoute) != dcommon.RouteModule {
+		return []common.MapStr{holder.Config}
+	}
+
+	targets := []common.MapStr{}
+	for _, value := range holder.Config {
+		fileset, ok := value.(common.MapStr)
+		if !ok {
+			continue
+		}
+		if prospector, ok := fileset["prospector"].(common.MapStr); ok {
+			targets = append(targets, prospector)
+		}
+	}
+	return targets
+}
+
+// AppendProcessors adds processors to the processors of a config
+func AppendProcessors(existing interface{}, add ...common.MapStr) []common.MapStr {
+	out := []common.MapStr{}
+	switch list := existing.(type) {
+	case []common.MapStr:
+		out = append(out, list...)
+	case []interface{}:
+		for _, p := range list {
+			switch p := p.(type) {
+			case common.MapStr:
+				out = append(out, p)
+			case map[string]interface{}:
+				out = append(out, common.MapStr(p))
+			}
+		}
+	}
+	return append(out, add...)
+}
+
+// Contains returns whether list holds value
+func Contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservedFields are the event fields set by the builders. Annotations of pods can not set,
+// drop or rename them.
+var ReservedFields = []string{"namespace", "kubernetes"}
+
+// IsReserved returns whether field is one of the ReservedFields or under one of them
+func IsReserved(field string) bool {
+	return Contains(ReservedFields, strings.SplitN(field, ".", 2)[0])
+}
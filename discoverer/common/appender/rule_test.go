@@ -0,0 +1,66 @@
+package appender
+
+import (
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestRuleMatches(t *testing.T) {
+	holder := &dcommon.ConfigHolder{
+		Config:  common.MapStr{"type": "log"},
+		Builder: "log_annotations",
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: "web",
+			dcommon.MetaLabels:    map[string]string{"app": "nginx"},
+		},
+	}
+
+	tests := []struct {
+		rule    Rule
+		matches bool
+	}{
+		{rule: Rule{}, matches: true},
+		{rule: Rule{Scope: Scope{Modules: []string{"log"}}}, matches: true},
+		{rule: Rule{Scope: Scope{Modules: []string{"prometheus"}}}, matches: false},
+		{rule: Rule{Scope: Scope{Builders: []string{"log_annotations"}}}, matches: true},
+		{rule: Rule{Scope: Scope{Builders: []string{"metrics_annotations"}}}, matches: false},
+		{rule: Rule{Namespaces: []string{"db", "web"}}, matches: true},
+		{rule: Rule{Namespaces: []string{"db"}}, matches: false},
+		{rule: Rule{Labels: map[string]string{"app": "nginx"}}, matches: true},
+		{rule: Rule{Labels: map[string]string{"app": "redis"}}, matches: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, test.rule.Matches(holder), "%+v", test.rule)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	config := common.MapStr{"type": "log"}
+	assert.Equal(t, []common.MapStr{config}, Targets(&dcommon.ConfigHolder{Config: config}))
+
+	// Filebeat modules take settings per fileset
+	access := common.MapStr{"input": "docker"}
+	module := &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"module": "nginx",
+			"access": common.MapStr{"enabled": true, "prospector": access},
+			"error":  common.MapStr{"enabled": false},
+		},
+		Meta: dcommon.Meta{dcommon.MetaRoute: dcommon.RouteModule},
+	}
+	assert.Equal(t, []common.MapStr{access}, Targets(module))
+}
+
+func TestAppendProcessors(t *testing.T) {
+	drop := common.MapStr{"drop_event": nil}
+	rename := common.MapStr{"rename": nil}
+
+	assert.Equal(t, []common.MapStr{drop}, AppendProcessors(nil, drop))
+	assert.Equal(t, []common.MapStr{drop, rename}, AppendProcessors([]common.MapStr{drop}, rename))
+	assert.Equal(t, []common.MapStr{drop, rename}, AppendProcessors([]interface{}{map[string]interface{}{"drop_event": nil}}, rename))
+}
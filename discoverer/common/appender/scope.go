@@ -0,0 +1,138 @@
+package appender
+
+import (
+	"fmt"
+	"sort"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Ordered is implemented by appenders that declare when they run. Appenders run by increasing
+// order, appenders of the same order in the order they were configured.
+type Ordered interface {
+	Order() int
+}
+
+// Scoped is implemented by appenders that only append to some configs
+type Scoped interface {
+	Scope() Scope
+}
+
+// Scope selects configs by the builder that generated them, their module and their kind.
+// Empty lists select all configs.
+type Scope struct {
+	Builders []string `config:"builders"`
+	Modules  []string `config:"modules"`
+	Kinds    []string `config:"kinds"`
+}
+
+func (s *Scope) Validate() error {
+	for _, kind := range s.Kinds {
+		if kind != dcommon.KindLogs && kind != dcommon.KindMetrics {
+			return fmt.Errorf("kind '%s' is not valid, use %s or %s", kind, dcommon.KindLogs, dcommon.KindMetrics)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the scope selects the config of holder. The module of a config is
+// its module or, for prospectors, its type. Configs of builders that do not declare a kind
+// match any kind.
+func (s Scope) Matches(holder *dcommon.ConfigHolder) bool {
+	if len(s.Builders) != 0 && !Contains(s.Builders, holder.Builder) {
+		return false
+	}
+	if len(s.Kinds) != 0 && holder.Kind != "" && !Contains(s.Kinds, holder.Kind) {
+		return false
+	}
+	if len(s.Modules) != 0 && !Contains(s.Modules, ModuleName(holder.Config)) {
+		return false
+	}
+	return true
+}
+
+// Options are the settings every appender accepts next to its own
+type Options struct {
+	Order *int   `config:"order"`
+	Scope *Scope `config:"scope"`
+}
+
+// OptionsSchema describes the settings every appender accepts
+var OptionsSchema = schema.Schema{
+	{Name: "order", Type: schema.Int, Description: "Position of the appender, appenders with lower orders run first"},
+	{Name: "scope.builders", Type: schema.List, Description: "Builders whose configs the appender appends to, all if empty"},
+	{Name: "scope.modules", Type: schema.List, Description: "Modules or prospector types the appender appends to, all if empty"},
+	{Name: "scope.kinds", Type: schema.List, Description: "Kinds of configs the appender appends to, logs or metrics, all if empty"},
+}
+
+// Configure applies the order and scope settings of cfg to an appender. Settings that are not
+// set keep what the appender declares.
+func Configure(a Appender, cfg *common.Config) (Appender, error) {
+	options := Options{}
+	if cfg != nil {
+		if err := cfg.Unpack(&options); err != nil {
+			return nil, fmt.Errorf("fail to unpack the appender order and scope: %s", err)
+		}
+	}
+	if options.Order == nil && options.Scope == nil {
+		return a, nil
+	}
+
+	c := &configured{Appender: a, order: OrderOf(a)}
+	if options.Order != nil {
+		c.order = *options.Order
+	}
+	if scoped, ok := a.(Scoped); ok {
+		c.scope = scoped.Scope()
+	}
+	if options.Scope != nil {
+		c.scope = *options.Scope
+	}
+	return c, nil
+}
+
+// configured overrides the order and scope an appender declares
+type configured struct {
+	Appender
+	order int
+	scope Scope
+}
+
+func (c *configured) Order() int   { return c.order }
+func (c *configured) Scope() Scope { return c.scope }
+
+func (c *configured) Changed() bool {
+	if refresher, ok := c.Appender.(Refresher); ok {
+		return refresher.Changed()
+	}
+	return false
+}
+
+// OrderOf returns the order an appender declares, 0 if it does not
+func OrderOf(a Appender) int {
+	if ordered, ok := a.(Ordered); ok {
+		return ordered.Order()
+	}
+	return 0
+}
+
+// Applies returns true if an appender appends to the config of holder
+func Applies(a Appender, holder *dcommon.ConfigHolder) bool {
+	if scoped, ok := a.(Scoped); ok {
+		return scoped.Scope().Matches(holder)
+	}
+	return true
+}
+
+// Sort returns the appenders in the order they run
+func Sort(appenders []Appender) []Appender {
+	sorted := make([]Appender, len(appenders))
+	copy(sorted, appenders)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return OrderOf(sorted[i]) < OrderOf(sorted[j])
+	})
+	return sorted
+}
@@ -0,0 +1,90 @@
+package appender
+
+import (
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// scopedAppender declares an order and a scope like the built-in appenders do
+type scopedAppender struct {
+	order int
+	scope Scope
+}
+
+func (s *scopedAppender) Append(holder *dcommon.ConfigHolder) {}
+
+func (s *scopedAppender) Order() int { return s.order }
+
+func (s *scopedAppender) Scope() Scope { return s.scope }
+
+func TestScopeMatches(t *testing.T) {
+	holder := &dcommon.ConfigHolder{
+		Config:  common.MapStr{"type": "log"},
+		Builder: "log_annotations",
+		Kind:    dcommon.KindLogs,
+	}
+
+	tests := []struct {
+		scope   Scope
+		matches bool
+	}{
+		{scope: Scope{}, matches: true},
+		{scope: Scope{Builders: []string{"log_annotations"}}, matches: true},
+		{scope: Scope{Builders: []string{"metrics_annotations"}}, matches: false},
+		{scope: Scope{Modules: []string{"log"}}, matches: true},
+		{scope: Scope{Modules: []string{"prometheus"}}, matches: false},
+		{scope: Scope{Kinds: []string{dcommon.KindLogs}}, matches: true},
+		{scope: Scope{Kinds: []string{dcommon.KindMetrics}}, matches: false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, test.scope.Matches(holder), "%+v", test.scope)
+	}
+
+	// Configs of builders that do not declare a kind match any kind
+	holder.Kind = ""
+	assert.True(t, Scope{Kinds: []string{dcommon.KindMetrics}}.Matches(holder))
+}
+
+func TestConfigure(t *testing.T) {
+	declared := &scopedAppender{order: 5, scope: Scope{Kinds: []string{dcommon.KindLogs}}}
+
+	// Without order and scope the appender is used as is
+	a, err := Configure(declared, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, declared, a)
+
+	// Setting only the order keeps the declared scope
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"order": -1})
+	assert.Nil(t, err)
+	a, err = Configure(declared, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, OrderOf(a))
+	assert.Equal(t, declared.scope, a.(Scoped).Scope())
+
+	// Setting only the scope keeps the declared order
+	cfg, err = common.NewConfigFrom(map[string]interface{}{"scope.builders": []string{"graphite_annotations"}})
+	assert.Nil(t, err)
+	a, err = Configure(declared, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, OrderOf(a))
+	assert.Equal(t, Scope{Builders: []string{"graphite_annotations"}}, a.(Scoped).Scope())
+
+	// Kinds other than logs and metrics are rejected
+	cfg, err = common.NewConfigFrom(map[string]interface{}{"scope.kinds": []string{"traces"}})
+	assert.Nil(t, err)
+	_, err = Configure(declared, cfg)
+	assert.NotNil(t, err)
+}
+
+func TestSort(t *testing.T) {
+	late, early := &scopedAppender{order: 10}, &scopedAppender{order: -1}
+	first := &scopedAppender{scope: Scope{Builders: []string{"first"}}}
+	second := &scopedAppender{scope: Scope{Builders: []string{"second"}}}
+
+	assert.Equal(t, []Appender{early, first, second, late}, Sort([]Appender{late, first, early, second}))
+}
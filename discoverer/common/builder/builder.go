@@ -1,6 +1,8 @@
 package builder
 
 import (
+	"fmt"
+
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/metagen"
 
@@ -32,6 +34,39 @@ type PushBuilder interface {
 	ModuleConfig() *dcommon.ConfigHolder
 }
 
+// Kinder is implemented by builders that declare the kind of data their configs collect, one
+// of the dcommon.Kind constants
+type Kinder interface {
+	Builder
+	Kind() string
+}
+
+// Validator is implemented by builders that can check an object for invalid settings
+type Validator interface {
+	Builder
+	// Validate returns all problems found in the settings of obj that the builder reads
+	Validate(obj interface{}) []*ValidationError
+}
+
+// ValidationError describes a single invalid setting, such as an annotation
+type ValidationError struct {
+	// Builder is the name of the builder that found the problem
+	Builder string
+	// Key is the setting that is invalid
+	Key string
+	// Value is the invalid value
+	Value string
+	// Message explains what is wrong
+	Message string
+}
+
+func (v *ValidationError) Error() string {
+	if v.Key == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Key, v.Message)
+}
+
 type ClientInfo common.MapStr
 
 type BuilderConstructor func(config *common.Config, client ClientInfo, metagen metagen.MetaGen) (Builder, error)
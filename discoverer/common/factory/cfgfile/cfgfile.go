@@ -2,55 +2,94 @@ package cfgfile
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	"github.com/ghodss/yaml"
+	"github.com/joeshaw/multierror"
 	"github.com/mitchellh/hashstructure"
 
 	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
 )
 
 var (
 	debug = logp.MakeDebug("cfgfile_factory")
+
+	filesWritten = monitoring.NewInt(metrics.Registry, "factory.cfgfile.starts")
+	filesRemoved = monitoring.NewInt(metrics.Registry, "factory.cfgfile.stops")
+	filesFailed  = monitoring.NewInt(metrics.Registry, "factory.cfgfile.failures")
 )
 
+// fileMode is the mode of the config files and the manifest. Configs can hold credentials added
+// by appenders and are only readable by the beat.
+const fileMode os.FileMode = 0600
+
 func init() {
 	factory.RegisterFactoryPlugin("cfgfile", newCfgfileFactory)
+	factory.RegisterFactorySchema("cfgfile", schema.Schema{
+		{Name: "prefix", Type: schema.String, Default: "collectbeat-", Description: "Prefix of the config files written"},
+		{Name: "reloader_config", Type: schema.Object, Description: "Config file reloading settings of the beat"},
+		{Name: "adopt_timeout", Type: schema.Duration, Default: "5m", Description: "How long files of a previous run wait to be claimed by discovery"},
+	})
 }
 
+// claimed holds the manifests of the cfgfile factories of the process. Factories sharing a
+// directory and prefix would adopt and delete each other's files.
+var claimed = struct {
+	sync.Mutex
+	manifests map[string]bool
+}{manifests: map[string]bool{}}
+
 type cfgfileFactory struct {
 	cfgfiles cfgfileCache
 	path     string
 	prefix   string
+	manifest string
+	// release removes adopted files that were not claimed in time
+	release *time.Timer
 }
 
 type cfgfileCache struct {
 	sync.Mutex
-	cfgfiles map[uint64][]common.MapStr
+	cfgfiles map[uint64]*cfgfileState
+}
+
+// cfgfileState is the tracked state of a single deployed config file
+type cfgfileState struct {
+	entry manifestEntry
+	// adopted is set on files left over from a previous run until discovery claims them again
+	adopted bool
 }
 
 func NewCfgfileCache() cfgfileCache {
 	return cfgfileCache{
-		cfgfiles: make(map[uint64][]common.MapStr),
+		cfgfiles: make(map[uint64]*cfgfileState),
 	}
 }
 
-func newCfgfileFactory(cfg *common.Config, _ factory.Meta) (factory.Factory, error) {
+func newCfgfileFactory(cfg *common.Config, meta factory.Meta) (factory.Factory, error) {
 	config := defaultConfig()
 	err := cfg.Unpack(&config)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to unpack config with error: %v", err)
 	}
 
+	// Fall back to the reloader settings of the beat
+	if beatMeta, ok := meta.(*factory.BeatMeta); ok && config.ReloaderConfig == nil {
+		config.ReloaderConfig = beatMeta.ReloaderConfig
+	}
+
 	if config.ReloaderConfig.Enabled() == false {
 		return nil, fmt.Errorf("config.* needs to be enabled to use cfgfile factory")
 	}
@@ -83,103 +122,161 @@ func newCfgfileFactory(cfg *common.Config, _ factory.Meta) (factory.Factory, err
 		cfgfiles: NewCfgfileCache(),
 		path:     dir,
 		prefix:   config.Prefix,
+		manifest: filepath.Join(dir, "."+config.Prefix+manifestSuffix),
 	}
 
-	files, _ := filepath.Glob(fmt.Sprintf("%s/*", dir))
-	for _, file := range files {
-		cfgFactory.deleteFile(file)
+	if err = cfgFactory.claim(); err != nil {
+		return nil, err
+	}
+
+	adopted, err := cfgFactory.adopt()
+	if err != nil {
+		cfgFactory.unclaim()
+		return nil, err
+	}
+
+	if adopted != 0 {
+		logp.Info("Adopted %d config files from a previous run", adopted)
+		cfgFactory.release = time.AfterFunc(config.AdoptTimeout, cfgFactory.releaseUnclaimed)
 	}
 
 	return cfgFactory, nil
 }
 
+// Start deploys one config file for every holder
 func (r *cfgfileFactory) Start(configHolder []*dcommon.ConfigHolder) error {
 	r.cfgfiles.Lock()
 	defer r.cfgfiles.Unlock()
-	rawCfg := []common.MapStr{}
 
+	var errs multierror.Errors
+	var failed []*dcommon.ConfigHolder
+	changed := false
 	for _, holder := range configHolder {
-		rawCfg = append(rawCfg, holder.Config)
+		deployed, err := r.startHolder(holder)
+		if err != nil {
+			filesFailed.Inc()
+			errs = append(errs, err)
+			failed = append(failed, holder)
+		}
+		if deployed {
+			filesWritten.Inc()
+		}
+		changed = changed || deployed
 	}
 
-	debug("Current raw config coming in for creation: %v", rawCfg)
+	if changed {
+		r.saveManifest()
+	}
+	if len(errs) != 0 {
+		return &factory.StartError{Failed: failed, Err: errs.Err()}
+	}
+	return nil
+}
 
-	if len(rawCfg) == 0 {
-		return nil
+func (r *cfgfileFactory) startHolder(holder *dcommon.ConfigHolder) (bool, error) {
+	debug("Current raw config coming in for creation: %v", holder.Config)
+
+	if len(holder.Config) == 0 {
+		return false, nil
 	}
 
-	hash, err := hashstructure.Hash(rawCfg, nil)
+	hash, err := hashstructure.Hash(holder.Config, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	debug("Current hash coming in for creation: %s", hash)
+	debug("Current hash coming in for creation: %d", hash)
 
-	if _, ok := r.cfgfiles.cfgfiles[hash]; ok {
-		return nil
-	}
-	bytes, err := yaml.Marshal(rawCfg)
-	if err != nil {
-		return err
+	if state, ok := r.cfgfiles.cfgfiles[hash]; ok {
+		if state.adopted {
+			state.adopted = false
+			logp.Info("Claimed config file %s from a previous run", state.entry.File)
+		}
+		return false, nil
 	}
+
+	// Reloaders expect a list of configs in every file
+	bytes, err := yaml.Marshal([]common.MapStr{holder.Config})
 	if err != nil {
-		return fmt.Errorf("Unable to pack config due to error: %v", err)
+		return false, fmt.Errorf("Unable to pack config due to error: %v", err)
 	}
 	if len(bytes) == 0 {
-		return nil
+		return false, nil
 	}
+	bytes = append(header(holder, time.Now()), bytes...)
 
-	file := fmt.Sprintf("%s/%s%d.yml", r.path, r.prefix, hash)
+	name := fileName(r.prefix, hash, holder, r.fileInUse)
+	file := filepath.Join(r.path, name)
 	debug("Creating file %s with contents: %v", file, string(bytes))
-	err = ioutil.WriteFile(file, bytes, 0644)
+	err = writeFileAtomic(file, bytes, fileMode)
 	if err != nil {
-		return fmt.Errorf("Unable to write cfgfile due to error: %v", err)
+		return false, fmt.Errorf("Unable to write cfgfile due to error: %v", err)
 	}
 
-	r.cfgfiles.cfgfiles[hash] = rawCfg
-	logp.Info("Deployed config file %d", hash)
+	r.cfgfiles.cfgfiles[hash] = &cfgfileState{
+		entry: newManifestEntry(name, bytes, holder),
+	}
+	logp.Info("Deployed config file %s", name)
 
-	return nil
+	return true, nil
 }
 
+// Stop removes the config files of all holders
 func (r *cfgfileFactory) Stop(configHolder []*dcommon.ConfigHolder) error {
 	r.cfgfiles.Lock()
 	defer r.cfgfiles.Unlock()
-	rawCfg := []common.MapStr{}
 
+	var errs multierror.Errors
+	changed := false
 	for _, holder := range configHolder {
-		rawCfg = append(rawCfg, holder.Config)
+		removed, err := r.stopHolder(holder)
+		if err != nil {
+			filesFailed.Inc()
+			errs = append(errs, err)
+		}
+		if removed {
+			filesRemoved.Inc()
+		}
+		changed = changed || removed
 	}
 
-	debug("Current raw config coming in for deletion: %v", rawCfg)
+	if changed {
+		r.saveManifest()
+	}
+	return errs.Err()
+}
 
-	if len(rawCfg) == 0 {
-		return nil
+func (r *cfgfileFactory) stopHolder(holder *dcommon.ConfigHolder) (bool, error) {
+	debug("Current raw config coming in for deletion: %v", holder.Config)
+
+	if len(holder.Config) == 0 {
+		return false, nil
 	}
 
-	hash, err := hashstructure.Hash(rawCfg, nil)
+	hash, err := hashstructure.Hash(holder.Config, nil)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	debug("Current hash coming in for deletion: %s", hash)
+	debug("Current hash coming in for deletion: %d", hash)
 
-	if _, ok := r.cfgfiles.cfgfiles[hash]; !ok {
-		debug("hash %s for deletion not found", hash)
-		return nil
+	state, ok := r.cfgfiles.cfgfiles[hash]
+	if !ok {
+		debug("hash %d for deletion not found", hash)
+		return false, nil
 	}
 
-	file := fmt.Sprintf("%s/%s%d.yml", r.path, r.prefix, hash)
+	file := filepath.Join(r.path, state.entry.File)
 	debug("File being deleted: %s", file)
 	err = r.deleteFile(file)
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	delete(r.cfgfiles.cfgfiles, hash)
-	logp.Info("Removed config file %d", hash)
-	return nil
+	logp.Info("Removed config file %s", state.entry.File)
+	return true, nil
 }
 
 func (r *cfgfileFactory) Restart(old, new *dcommon.ConfigHolder) error {
@@ -192,13 +289,207 @@ func (r *cfgfileFactory) Restart(old, new *dcommon.ConfigHolder) error {
 	return err
 }
 
+// adopt loads the manifest of a previous run and keeps every config file that is still intact.
+// Files that are unknown, modified or half written are removed.
+func (r *cfgfileFactory) adopt() (int, error) {
+	r.cfgfiles.Lock()
+	defer r.cfgfiles.Unlock()
+
+	m, err := readManifest(r.manifest)
+	if err != nil {
+		logp.Warn("Discarding config files from previous run: %v", err)
+		m = &manifest{Entries: map[string]manifestEntry{}}
+	}
+
+	known := map[string]bool{}
+	for key, entry := range m.Entries {
+		hash, err := parseHashKey(key)
+		if err != nil {
+			debug("Skipping manifest entry with invalid hash %s", key)
+			continue
+		}
+
+		sum, err := fileChecksum(filepath.Join(r.path, entry.File))
+		if err != nil || sum != entry.Checksum {
+			debug("Not adopting config file %s as it is missing or modified", entry.File)
+			continue
+		}
+
+		// Files of earlier versions were readable by everyone
+		if err := os.Chmod(filepath.Join(r.path, entry.File), fileMode); err != nil {
+			logp.Warn("Unable to restrict permissions of config file %s due to error: %v", entry.File, err)
+		}
+		r.cfgfiles.cfgfiles[hash] = &cfgfileState{entry: entry, adopted: true}
+		known[entry.File] = true
+	}
+
+	files, _ := filepath.Glob(filepath.Join(r.path, "*"))
+	for _, file := range files {
+		base := filepath.Base(file)
+		if known[base] || file == r.manifest {
+			continue
+		}
+
+		// Clean up temp files left behind by an interrupted write
+		if strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempSuffix) && strings.Contains(base, r.prefix) {
+			os.Remove(file)
+			continue
+		}
+
+		if err := r.deleteFile(file); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = writeManifest(r.manifest, r.toManifest()); err != nil {
+		return 0, fmt.Errorf("Unable to write cfgfile manifest due to error: %v", err)
+	}
+
+	return len(known), nil
+}
+
+// Flush persists the manifest before exit. Config files are kept so that they can be adopted
+// on the next start, the directory and prefix can be used by a new factory.
+func (r *cfgfileFactory) Flush() error {
+	r.cfgfiles.Lock()
+	defer r.cfgfiles.Unlock()
+
+	if r.release != nil {
+		r.release.Stop()
+	}
+
+	defer r.unclaim()
+	return writeManifest(r.manifest, r.toManifest())
+}
+
+// Close releases the directory and prefix of a factory that is not used. Config files and
+// the manifest are left as they are.
+func (r *cfgfileFactory) Close() error {
+	r.cfgfiles.Lock()
+	defer r.cfgfiles.Unlock()
+
+	if r.release != nil {
+		r.release.Stop()
+	}
+
+	r.unclaim()
+	return nil
+}
+
+// claim reserves the directory and prefix of the factory within the process
+func (r *cfgfileFactory) claim() error {
+	key, err := filepath.Abs(r.manifest)
+	if err != nil {
+		key = r.manifest
+	}
+
+	claimed.Lock()
+	defer claimed.Unlock()
+
+	if claimed.manifests[key] {
+		return fmt.Errorf("Another cfgfile factory already writes files with prefix %s to %s, use a different prefix", r.prefix, r.path)
+	}
+	claimed.manifests[key] = true
+	return nil
+}
+
+func (r *cfgfileFactory) unclaim() {
+	key, err := filepath.Abs(r.manifest)
+	if err != nil {
+		key = r.manifest
+	}
+
+	claimed.Lock()
+	defer claimed.Unlock()
+	delete(claimed.manifests, key)
+}
+
+// cfgfileStatus describes a deployed config file
+type cfgfileStatus struct {
+	manifestEntry
+	// Adopted is set while a file from a previous run has not been claimed by discovery
+	Adopted bool `json:"adopted"`
+}
+
+// Status lists the deployed config files sorted by name
+func (r *cfgfileFactory) Status() interface{} {
+	r.cfgfiles.Lock()
+	defer r.cfgfiles.Unlock()
+
+	files := make([]cfgfileStatus, 0, len(r.cfgfiles.cfgfiles))
+	for _, state := range r.cfgfiles.cfgfiles {
+		files = append(files, cfgfileStatus{manifestEntry: state.entry, Adopted: state.adopted})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].File < files[j].File
+	})
+
+	return common.MapStr{
+		"name":  "cfgfile",
+		"path":  r.path,
+		"files": files,
+	}
+}
+
+// releaseUnclaimed removes adopted config files that discovery did not claim again
+func (r *cfgfileFactory) releaseUnclaimed() {
+	r.cfgfiles.Lock()
+	defer r.cfgfiles.Unlock()
+
+	for hash, state := range r.cfgfiles.cfgfiles {
+		if !state.adopted {
+			continue
+		}
+
+		err := r.deleteFile(filepath.Join(r.path, state.entry.File))
+		if err != nil {
+			filesFailed.Inc()
+			logp.Err("Unable to release config file: %v", err)
+			continue
+		}
+		filesRemoved.Inc()
+
+		delete(r.cfgfiles.cfgfiles, hash)
+		logp.Info("Removed unclaimed config file %s", state.entry.File)
+	}
+
+	r.saveManifest()
+}
+
+// saveManifest persists the current state. It must be called with the cache lock held.
+func (r *cfgfileFactory) saveManifest() {
+	err := writeManifest(r.manifest, r.toManifest())
+	if err != nil {
+		logp.Err("Unable to write cfgfile manifest due to error: %v", err)
+	}
+}
+
+// fileInUse checks if a tracked config file already has the given name
+func (r *cfgfileFactory) fileInUse(name string) bool {
+	for _, state := range r.cfgfiles.cfgfiles {
+		if state.entry.File == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *cfgfileFactory) toManifest() *manifest {
+	m := &manifest{Entries: make(map[string]manifestEntry, len(r.cfgfiles.cfgfiles))}
+	for hash, state := range r.cfgfiles.cfgfiles {
+		m.Entries[hashKey(hash)] = state.entry
+	}
+	return m
+}
+
 func (r *cfgfileFactory) deleteFile(file string) error {
-	f := path.Base(file)
+	f := filepath.Base(file)
 	if strings.HasPrefix(f, r.prefix) == false || strings.HasSuffix(file, ".yml") == false {
 		return nil
 	}
 	err := os.Remove(file)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Unable to delete file %s due to error: %v", file, err)
 	}
 
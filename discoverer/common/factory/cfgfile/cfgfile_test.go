@@ -0,0 +1,261 @@
+package cfgfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestCfgfileStartStop(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+	holders := testHolders()
+
+	err := f.Start(holders)
+	assert.Nil(t, err)
+
+	files := ymlFiles(t, dir)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "collectbeat-foo_bar_nginx_log_annotations.yml", filepath.Base(files[0]))
+	assertMode(t, files[0], 0600)
+	assertMode(t, f.manifest, 0600)
+
+	m, err := readManifest(f.manifest)
+	assert.Nil(t, err)
+	assert.Len(t, m.Entries, 1)
+	for _, entry := range m.Entries {
+		assert.Equal(t, filepath.Base(files[0]), entry.File)
+		assert.Equal(t, "log_annotations", entry.Builder)
+		assert.Equal(t, "abc", entry.PodUID)
+		assert.Equal(t, "nginx", entry.Container)
+	}
+
+	err = f.Stop(holders)
+	assert.Nil(t, err)
+	assert.Len(t, ymlFiles(t, dir), 0)
+
+	m, err = readManifest(f.manifest)
+	assert.Nil(t, err)
+	assert.Len(t, m.Entries, 0)
+}
+
+func TestCfgfileAdoption(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+	holders := testHolders()
+	assert.Nil(t, f.Start(holders))
+
+	files := ymlFiles(t, dir)
+	assert.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	assert.Nil(t, err)
+
+	// Leave behind an unknown config file and an interrupted write
+	stray := filepath.Join(dir, "collectbeat-1.yml")
+	assert.Nil(t, ioutil.WriteFile(stray, []byte("- module: foo"), 0644))
+	temp := filepath.Join(dir, ".collectbeat-2.yml.123"+tempSuffix)
+	assert.Nil(t, ioutil.WriteFile(temp, []byte("- mod"), 0644))
+
+	// A restarted factory keeps the intact file, restricting the permissions earlier versions
+	// set, and removes everything else
+	assert.Nil(t, os.Chmod(files[0], 0644))
+	assert.Nil(t, f.Flush())
+	restarted := newTestFactory(t, dir)
+	assert.Equal(t, files, ymlFiles(t, dir))
+	assertMode(t, files[0], 0600)
+	_, err = os.Stat(temp)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, restarted.Start(holders))
+	after, err := os.Stat(files[0])
+	assert.Nil(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+
+	// Claimed files survive releasing unclaimed files
+	restarted.releaseUnclaimed()
+	assert.Equal(t, files, ymlFiles(t, dir))
+}
+
+func TestCfgfileReleaseUnclaimed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+	assert.Nil(t, f.Start(testHolders()))
+	assert.Len(t, ymlFiles(t, dir), 1)
+
+	assert.Nil(t, f.Flush())
+	restarted := newTestFactory(t, dir)
+	restarted.releaseUnclaimed()
+	assert.Len(t, ymlFiles(t, dir), 0)
+
+	m, err := readManifest(restarted.manifest)
+	assert.Nil(t, err)
+	assert.Len(t, m.Entries, 0)
+}
+
+func TestCfgfileModifiedFileNotAdopted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+	assert.Nil(t, f.Start(testHolders()))
+
+	files := ymlFiles(t, dir)
+	assert.Len(t, files, 1)
+	assert.Nil(t, ioutil.WriteFile(files[0], []byte("- type: lo"), 0644))
+
+	assert.Nil(t, f.Flush())
+	newTestFactory(t, dir)
+	assert.Len(t, ymlFiles(t, dir), 0)
+}
+
+func TestCfgfileSharedDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+
+	// A second factory with the same directory and prefix is rejected
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"reloader_config": map[string]interface{}{"path": filepath.Join(dir, "*.yml")},
+	})
+	assert.Nil(t, err)
+	_, err = newCfgfileFactory(cfg, nil)
+	assert.NotNil(t, err)
+
+	// Another prefix can share the directory
+	assert.Nil(t, cfg.SetString("prefix", -1, "other-"))
+	other, err := newCfgfileFactory(cfg, nil)
+	if assert.Nil(t, err) {
+		assert.Nil(t, other.(*cfgfileFactory).Flush())
+	}
+
+	// Once flushed the directory and prefix can be used again
+	assert.Nil(t, f.Flush())
+	f = newTestFactory(t, dir)
+
+	// Factories that are closed instead of used release them as well
+	assert.Nil(t, f.Close())
+	assert.Nil(t, newTestFactory(t, dir).Flush())
+}
+
+func TestCfgfileHeader(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	f := newTestFactory(t, dir)
+	holders := testHolders()
+	holders[0].Meta[dcommon.MetaAnnotations] = map[string]string{
+		"io.collectbeat.logs/pattern": "^\\[",
+		"io.collectbeat.logs/negate":  "true",
+	}
+	assert.Nil(t, f.Start(holders))
+
+	files := ymlFiles(t, dir)
+	assert.Len(t, files, 1)
+	bytes, err := ioutil.ReadFile(files[0])
+	assert.Nil(t, err)
+
+	contents := string(bytes)
+	assert.Contains(t, contents, "# builder: log_annotations\n")
+	assert.Contains(t, contents, "# pod: foo/bar\n")
+	assert.Contains(t, contents, "# pod_uid: abc\n")
+	assert.Contains(t, contents, "# container: nginx\n")
+	assert.Contains(t, contents, "# generated: ")
+	assert.Contains(t, contents, "# annotations:\n#   io.collectbeat.logs/negate: true\n#   io.collectbeat.logs/pattern: ^\\[\n")
+
+	// The header must not change the parsed config
+	cfg, err := common.NewConfigWithYAML(bytes, "")
+	assert.Nil(t, err)
+	configs := []common.MapStr{}
+	assert.Nil(t, cfg.Unpack(&configs))
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "log", configs[0]["type"])
+}
+
+func TestFileName(t *testing.T) {
+	taken := func(string) bool { return false }
+	holder := &dcommon.ConfigHolder{
+		Meta: dcommon.Meta{
+			dcommon.MetaBuilder:   "metrics_annotations",
+			dcommon.MetaPodName:   "web/1",
+			dcommon.MetaNamespace: "..",
+		},
+	}
+
+	assert.Equal(t, "collectbeat-web-1_metrics_annotations.yml", fileName("collectbeat-", 1, holder, taken))
+	assert.Equal(t, "collectbeat-42.yml", fileName("collectbeat-", 42, &dcommon.ConfigHolder{}, taken))
+
+	taken = func(name string) bool { return name == "collectbeat-web-1_metrics_annotations.yml" }
+	assert.Equal(t, "collectbeat-web-1_metrics_annotations_42.yml", fileName("collectbeat-", 42, holder, taken))
+}
+
+func newTestFactory(t *testing.T, dir string) *cfgfileFactory {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"reloader_config": map[string]interface{}{
+			"path": filepath.Join(dir, "*.yml"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := newCfgfileFactory(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.(*cfgfileFactory)
+}
+
+func testHolders() []*dcommon.ConfigHolder {
+	return []*dcommon.ConfigHolder{
+		{
+			Config: common.MapStr{
+				"type":  "log",
+				"paths": []string{"/var/lib/docker/containers/123/*.log"},
+			},
+			Meta: dcommon.Meta{
+				dcommon.MetaBuilder:   "log_annotations",
+				dcommon.MetaPodUID:    "abc",
+				dcommon.MetaPodName:   "bar",
+				dcommon.MetaNamespace: "foo",
+				dcommon.MetaContainer: "nginx",
+			},
+		},
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cfgfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func ymlFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// assertMode checks the permissions of a file
+func assertMode(t *testing.T, file string, mode os.FileMode) {
+	info, err := os.Stat(file)
+	if assert.Nil(t, err) {
+		assert.Equal(t, mode, info.Mode().Perm(), file)
+	}
+}
@@ -1,14 +1,22 @@
 package cfgfile
 
-import "github.com/elastic/beats/libbeat/common"
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
 
 type CfgfileConfig struct {
 	Prefix         string         `config:"prefix"`
 	ReloaderConfig *common.Config `config:"reloader_config"`
+	// AdoptTimeout is how long files left over from a previous run are kept
+	// while waiting for discovery to claim them again
+	AdoptTimeout time.Duration `config:"adopt_timeout"`
 }
 
 func defaultConfig() CfgfileConfig {
 	return CfgfileConfig{
-		Prefix: "collectbeat-",
+		Prefix:       "collectbeat-",
+		AdoptTimeout: 5 * time.Minute,
 	}
 }
@@ -0,0 +1,148 @@
+package cfgfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+)
+
+const (
+	manifestSuffix = "manifest.json"
+	tempSuffix     = ".tmp"
+)
+
+// manifestEntry records a deployed config file and the objects it was generated from
+type manifestEntry struct {
+	File      string `json:"file"`
+	Checksum  string `json:"checksum"`
+	Builder   string `json:"builder,omitempty"`
+	PodUID    string `json:"pod_uid,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// manifest is the on disk state of the cfgfile factory keyed by config hash
+type manifest struct {
+	Entries map[string]manifestEntry `json:"entries"`
+}
+
+func newManifestEntry(file string, contents []byte, holder *dcommon.ConfigHolder) manifestEntry {
+	meta := holder.Meta
+	return manifestEntry{
+		File:      file,
+		Checksum:  checksum(contents),
+		Builder:   meta.GetString(dcommon.MetaBuilder),
+		PodUID:    meta.GetString(dcommon.MetaPodUID),
+		Namespace: meta.GetString(dcommon.MetaNamespace),
+		Pod:       meta.GetString(dcommon.MetaPodName),
+		Container: meta.GetString(dcommon.MetaContainer),
+	}
+}
+
+func readManifest(file string) (*manifest, error) {
+	m := &manifest{Entries: map[string]manifestEntry{}}
+
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(bytes, m); err != nil {
+		return nil, fmt.Errorf("Unable to parse manifest %s due to error: %v", file, err)
+	}
+
+	if m.Entries == nil {
+		m.Entries = map[string]manifestEntry{}
+	}
+	return m, nil
+}
+
+func writeManifest(file string, m *manifest) error {
+	bytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(file, bytes, fileMode)
+}
+
+// writeFileAtomic writes data to a temporary file in the target directory and renames it
+// into place so that readers never observe a partially written file
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(file)
+
+	tmp, err := createTemp(dir, base)
+	if err != nil {
+		return err
+	}
+
+	tmpName := tmp.Name()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, file)
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// tempCounter makes the names of temporary files unique within the process
+var tempCounter uint64
+
+// createTemp creates a new temporary file for base in dir. The file is hidden and does not
+// end in .yml so that reloaders never pick it up. Files left over by an earlier process
+// with the same PID are skipped.
+func createTemp(dir, base string) (*os.File, error) {
+	for i := 0; ; i++ {
+		suffix := fmt.Sprintf("%d-%d", os.Getpid(), atomic.AddUint64(&tempCounter, 1))
+		f, err := os.OpenFile(filepath.Join(dir, "."+base+"."+suffix+tempSuffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func fileChecksum(file string) (string, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return checksum(bytes), nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashKey(hash uint64) string {
+	return strconv.FormatUint(hash, 10)
+}
+
+func parseHashKey(key string) (uint64, error) {
+	return strconv.ParseUint(key, 10, 64)
+}
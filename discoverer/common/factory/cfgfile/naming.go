@@ -0,0 +1,98 @@
+package cfgfile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+)
+
+const (
+	// Keep generated names well below the file name limit of common filesystems
+	maxNameLength = 200
+	nameSeparator = "_"
+)
+
+// fileName returns a file name describing the origin of a config, e.g.
+// collectbeat-<namespace>_<pod>_<container>_<builder>.yml. If the name is already taken by a
+// different config, the config hash is appended. Configs without any origin are named by hash.
+func fileName(prefix string, hash uint64, holder *dcommon.ConfigHolder, taken func(string) bool) string {
+	meta := holder.Meta
+	parts := []string{}
+	for _, key := range []string{dcommon.MetaNamespace, dcommon.MetaPodName, dcommon.MetaContainer, dcommon.MetaBuilder} {
+		if part := sanitize(meta.GetString(key)); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return fmt.Sprintf("%s%d.yml", prefix, hash)
+	}
+
+	name := prefix + strings.Join(parts, nameSeparator)
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+
+	if taken(name + ".yml") {
+		name = fmt.Sprintf("%s%s%d", name, nameSeparator, hash)
+	}
+
+	return name + ".yml"
+}
+
+// sanitize replaces every character that is not safe in a file name
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.Trim(s, "."))
+}
+
+// header returns a YAML comment block describing where a config file came from
+func header(holder *dcommon.ConfigHolder, generated time.Time) []byte {
+	meta := holder.Meta
+	buf := &bytes.Buffer{}
+
+	buf.WriteString("# Generated by collectbeat. Changes to this file will be overwritten.\n")
+	writeComment(buf, "builder", meta.GetString(dcommon.MetaBuilder))
+	if ns, pod := meta.GetString(dcommon.MetaNamespace), meta.GetString(dcommon.MetaPodName); pod != "" {
+		writeComment(buf, "pod", ns+"/"+pod)
+	}
+	writeComment(buf, "pod_uid", meta.GetString(dcommon.MetaPodUID))
+	writeComment(buf, "container", meta.GetString(dcommon.MetaContainer))
+	writeComment(buf, "generated", generated.UTC().Format(time.RFC3339))
+
+	annotations, _ := meta[dcommon.MetaAnnotations].(map[string]string)
+	if len(annotations) != 0 {
+		keys := make([]string, 0, len(annotations))
+		for key := range annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteString("# annotations:\n")
+		for _, key := range keys {
+			writeComment(buf, "  "+key, annotations[key])
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func writeComment(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+
+	// Values must not break out of the comment
+	value = strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(value)
+	fmt.Fprintf(buf, "# %s: %s\n", key, value)
+}
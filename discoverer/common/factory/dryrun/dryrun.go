@@ -0,0 +1,74 @@
+package dryrun
+
+import (
+	"strconv"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/mitchellh/hashstructure"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+var (
+	debug = logp.MakeDebug("dryrun_factory")
+)
+
+func init() {
+	factory.RegisterFactoryPlugin("dryrun", newDryRunFactory)
+}
+
+// DryRunFactory records the holders it is asked to run instead of running them
+type DryRunFactory struct {
+	holders *dcommon.HolderSet
+}
+
+// New creates an empty DryRunFactory
+func New() *DryRunFactory {
+	return &DryRunFactory{
+		holders: dcommon.NewHolderSet(hash),
+	}
+}
+
+func newDryRunFactory(_ *common.Config, _ factory.Meta) (factory.Factory, error) {
+	return New(), nil
+}
+
+func (d *DryRunFactory) Start(holders []*dcommon.ConfigHolder) error {
+	d.holders.Add(holders...)
+	debug("Recorded %d configs", len(holders))
+	return nil
+}
+
+func (d *DryRunFactory) Stop(holders []*dcommon.ConfigHolder) error {
+	d.holders.Remove(holders...)
+	debug("Removed %d configs", len(holders))
+	return nil
+}
+
+func (d *DryRunFactory) Restart(old, new *dcommon.ConfigHolder) error {
+	d.holders.Remove(old)
+	d.holders.Add(new)
+	return nil
+}
+
+// Holders returns the holders that would currently be running, grouped by namespace, pod
+// and builder
+func (d *DryRunFactory) Holders() []*dcommon.ConfigHolder {
+	return d.holders.List()
+}
+
+// Len returns the number of holders that would currently be running
+func (d *DryRunFactory) Len() int {
+	return d.holders.Len()
+}
+
+// hash identifies holders by their config like the factories that run them
+func hash(holder *dcommon.ConfigHolder) (string, error) {
+	id, err := hashstructure.Hash(holder.Config, nil)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(id, 10), nil
+}
@@ -0,0 +1,44 @@
+package dryrun
+
+import (
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestDryRunFactory(t *testing.T) {
+	d := New()
+
+	web := holder("default", "web", "metrics_annotations", "prometheus")
+	db := holder("default", "db", "metrics_annotations", "mysql")
+	logs := holder("default", "db", "log_annotations", "log")
+
+	assert.Nil(t, d.Start([]*dcommon.ConfigHolder{web, logs, db}))
+	assert.Equal(t, []*dcommon.ConfigHolder{logs, db, web}, d.Holders())
+
+	assert.Nil(t, d.Stop([]*dcommon.ConfigHolder{logs}))
+	assert.Equal(t, []*dcommon.ConfigHolder{db, web}, d.Holders())
+
+	updated := holder("default", "web", "metrics_annotations", "http")
+	assert.Nil(t, d.Restart(web, updated))
+	assert.Equal(t, []*dcommon.ConfigHolder{db, updated}, d.Holders())
+	assert.Equal(t, 2, d.Len())
+
+	// Empty holders are never recorded
+	assert.Nil(t, d.Start([]*dcommon.ConfigHolder{{}}))
+	assert.Equal(t, 2, d.Len())
+}
+
+func holder(namespace, pod, builder, module string) *dcommon.ConfigHolder {
+	return &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": module},
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: namespace,
+			dcommon.MetaPodName:   pod,
+			dcommon.MetaBuilder:   builder,
+		},
+	}
+}
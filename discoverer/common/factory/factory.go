@@ -2,29 +2,129 @@ package factory
 
 import (
 	"fmt"
+	"sort"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 
+	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 )
 
-var factoryPlugins = make(map[string]FactoryConstructor)
+// DefaultFactory is used by the beats when no factory is configured
+const DefaultFactory = "cfgfile"
+
+var (
+	factoryPlugins = make(map[string]FactoryConstructor)
+	factorySchemas = make(map[string]schema.Schema)
+)
 
 type Meta interface{}
 
+// BeatMeta is passed as Meta by the beats and carries the objects factories may depend on
+type BeatMeta struct {
+	// RunnerFactory creates in process runners for a config
+	RunnerFactory cfgfile.RunnerFactory
+	// ReloaderConfig is the beat's config reloader setting (config.modules or config.prospectors)
+	ReloaderConfig *common.Config
+}
+
 type Factory interface {
 	Start(config []*dcommon.ConfigHolder) error
 	Stop(config []*dcommon.ConfigHolder) error
 	Restart(old, new *dcommon.ConfigHolder) error
 }
 
+// StartError is returned by Start when a factory keeps some holders of a batch although others
+// failed. Failed lists the holders the factory dropped, the others run or are retried.
+type StartError struct {
+	Failed []*dcommon.ConfigHolder
+	Err    error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+// Accepted returns the holders of a Start call that the factory kept. Factories that fail
+// without a StartError are expected to have kept none of them.
+func Accepted(holders []*dcommon.ConfigHolder, err error) []*dcommon.ConfigHolder {
+	if err == nil {
+		return holders
+	}
+
+	startErr, ok := err.(*StartError)
+	if !ok {
+		return nil
+	}
+
+	failed := map[*dcommon.ConfigHolder]bool{}
+	for _, holder := range startErr.Failed {
+		failed[holder] = true
+	}
+
+	accepted := []*dcommon.ConfigHolder{}
+	for _, holder := range holders {
+		if !failed[holder] {
+			accepted = append(accepted, holder)
+		}
+	}
+	return accepted
+}
+
+// Flusher is implemented by factories that need to complete pending work before the beat exits
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is implemented by factories that hold resources which must be released when the
+// factory is discarded without being flushed, such as when the beat fails to start
+type Closer interface {
+	Close() error
+}
+
+// Close releases a factory that will not be used, if it needs it
+func Close(f Factory) {
+	if closer, ok := f.(Closer); ok {
+		if err := closer.Close(); err != nil {
+			logp.Err("Unable to close factory due to error: %v", err)
+		}
+	}
+}
+
+// StatusReporter is implemented by factories that can describe the state of what they run.
+// The returned value is served as JSON.
+type StatusReporter interface {
+	Status() interface{}
+}
+
 type FactoryConstructor func(config *common.Config, meta Meta) (Factory, error)
 
 func RegisterFactoryPlugin(name string, factory FactoryConstructor) {
 	factoryPlugins[name] = factory
 }
 
+// RegisterFactorySchema describes the settings of a factory plugin
+func RegisterFactorySchema(name string, s schema.Schema) {
+	factorySchemas[name] = s
+}
+
+// Plugins returns the names of all registered factory plugins, sorted
+func Plugins() []string {
+	names := make([]string, 0, len(factoryPlugins))
+	for name := range factoryPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Schema returns the registered settings of a factory plugin
+func Schema(name string) schema.Schema {
+	return factorySchemas[name]
+}
+
 type FactoryPlugin struct {
 	Name    string
 	Config  *common.Config
@@ -0,0 +1,35 @@
+package multi
+
+import (
+	"fmt"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+type multiConfig struct {
+	// Factories are the child factories holders can be routed to, keyed by a name used in routes
+	Factories map[string]*common.Config `config:"factories" validate:"required"`
+	// Routes maps a holder's route key or builder name to a child factory
+	Routes map[string]string `config:"routes"`
+	// Default is the child factory used for holders without a matching route
+	Default string `config:"default"`
+}
+
+func (c *multiConfig) Validate() error {
+	if len(c.Factories) == 0 {
+		return fmt.Errorf("at least one factory needs to be configured")
+	}
+
+	if c.Default != "" {
+		if _, ok := c.Factories[c.Default]; !ok {
+			return fmt.Errorf("default factory %s is not configured", c.Default)
+		}
+	}
+
+	for route, name := range c.Routes {
+		if _, ok := c.Factories[name]; !ok {
+			return fmt.Errorf("factory %s for route %s is not configured", name, route)
+		}
+	}
+	return nil
+}
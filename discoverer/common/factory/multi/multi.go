@@ -0,0 +1,211 @@
+package multi
+
+import (
+	"fmt"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+	"github.com/joeshaw/multierror"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+var (
+	debug = logp.MakeDebug("multi_factory")
+)
+
+func init() {
+	factory.RegisterFactoryPlugin("multi", newMultiFactory)
+	factory.RegisterFactorySchema("multi", schema.Schema{
+		{Name: "factories", Type: schema.Object, Required: true, Description: "Child factories by name"},
+		{Name: "routes", Type: schema.Object, Description: "Child factory by route key or builder name"},
+		{Name: "default", Type: schema.String, Description: "Child factory for holders without a matching route"},
+	})
+}
+
+// multiFactory dispatches every holder to one of several child factories
+type multiFactory struct {
+	factories map[string]factory.Factory
+	routes    map[string]string
+	fallback  string
+}
+
+func newMultiFactory(cfg *common.Config, meta factory.Meta) (factory.Factory, error) {
+	config := multiConfig{}
+	err := cfg.Unpack(&config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to unpack multi factory config due to error: %v", err)
+	}
+
+	factories := map[string]factory.Factory{}
+	for name, childCfg := range config.Factories {
+		plugin, err := factory.InitFactory(childCfg, meta)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to initialize factory %s due to error: %v", name, err)
+		}
+
+		factories[name] = plugin.Factory
+		logp.Info("Activated %s factory as %s", plugin.Name, name)
+	}
+
+	return New(factories, config.Routes, config.Default), nil
+}
+
+// New creates a factory that dispatches holders to factories by route or builder name.
+// Holders without a route go to the fallback factory, or are dropped if it is empty.
+func New(factories map[string]factory.Factory, routes map[string]string, fallback string) factory.Factory {
+	return &multiFactory{
+		factories: factories,
+		routes:    routes,
+		fallback:  fallback,
+	}
+}
+
+// Start starts holders in the factories they are routed to. Holders a child factory did not
+// keep are reported as failed.
+func (m *multiFactory) Start(holders []*dcommon.ConfigHolder) error {
+	var errs multierror.Errors
+	var failed []*dcommon.ConfigHolder
+	for name, routed := range m.group(holders) {
+		if err := m.factories[name].Start(routed); err != nil {
+			errs = append(errs, err)
+			kept := factory.Accepted(routed, err)
+			for _, holder := range routed {
+				if !contains(kept, holder) {
+					failed = append(failed, holder)
+				}
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return &factory.StartError{Failed: failed, Err: errs.Err()}
+	}
+	return nil
+}
+
+func contains(holders []*dcommon.ConfigHolder, holder *dcommon.ConfigHolder) bool {
+	for _, existing := range holders {
+		if existing == holder {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multiFactory) Stop(holders []*dcommon.ConfigHolder) error {
+	var errs multierror.Errors
+	for name, routed := range m.group(holders) {
+		if err := m.factories[name].Stop(routed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.Err()
+}
+
+func (m *multiFactory) Restart(old, new *dcommon.ConfigHolder) error {
+	oldName, newName := m.route(old), m.route(new)
+	if oldName == newName {
+		if oldName == "" {
+			return nil
+		}
+		return m.factories[oldName].Restart(old, new)
+	}
+
+	// The holder moved between factories
+	var errs multierror.Errors
+	if oldName != "" {
+		if err := m.factories[oldName].Stop([]*dcommon.ConfigHolder{old}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if newName != "" {
+		if err := m.factories[newName].Start([]*dcommon.ConfigHolder{new}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs.Err()
+}
+
+// Flush flushes all child factories that need it
+func (m *multiFactory) Flush() error {
+	var errs multierror.Errors
+	for name, f := range m.factories {
+		if flusher, ok := f.(factory.Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("Unable to flush factory %s due to error: %v", name, err))
+			}
+		}
+	}
+
+	return errs.Err()
+}
+
+// Close closes all child factories that need it
+func (m *multiFactory) Close() error {
+	var errs multierror.Errors
+	for name, f := range m.factories {
+		if closer, ok := f.(factory.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("Unable to close factory %s due to error: %v", name, err))
+			}
+		}
+	}
+
+	return errs.Err()
+}
+
+// Status reports the status of every child factory that supports it
+func (m *multiFactory) Status() interface{} {
+	factories := common.MapStr{}
+	for name, f := range m.factories {
+		if reporter, ok := f.(factory.StatusReporter); ok {
+			factories[name] = reporter.Status()
+		} else {
+			factories[name] = nil
+		}
+	}
+
+	return common.MapStr{
+		"name":      "multi",
+		"routes":    m.routes,
+		"default":   m.fallback,
+		"factories": factories,
+	}
+}
+
+// group splits holders by the name of the factory they are routed to
+func (m *multiFactory) group(holders []*dcommon.ConfigHolder) map[string][]*dcommon.ConfigHolder {
+	groups := map[string][]*dcommon.ConfigHolder{}
+	for _, holder := range holders {
+		name := m.route(holder)
+		if name == "" {
+			continue
+		}
+		groups[name] = append(groups[name], holder)
+	}
+	return groups
+}
+
+// route returns the factory a holder is dispatched to. The holder's route key takes
+// precedence over the name of the builder that generated it.
+func (m *multiFactory) route(holder *dcommon.ConfigHolder) string {
+	if holder == nil {
+		return ""
+	}
+
+	for _, key := range []string{dcommon.MetaRoute, dcommon.MetaBuilder} {
+		if name, ok := m.routes[holder.Meta.GetString(key)]; ok {
+			return name
+		}
+	}
+
+	if m.fallback == "" {
+		debug("Dropping config without a route: %v", holder.Config)
+	}
+	return m.fallback
+}
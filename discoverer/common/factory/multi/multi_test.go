@@ -0,0 +1,156 @@
+package multi
+
+import (
+	"errors"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+var recorders = map[string]*recordingFactory{}
+
+func init() {
+	factory.RegisterFactoryPlugin("recorder", newRecordingFactory)
+}
+
+func TestMultiFactoryRouting(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"name":    "multi",
+		"default": "files",
+		"factories": map[string]interface{}{
+			"files":     map[string]interface{}{"name": "recorder", "id": "files"},
+			"inprocess": map[string]interface{}{"name": "recorder", "id": "inprocess"},
+		},
+		"routes": map[string]interface{}{
+			"push":           "inprocess",
+			"metrics_secret": "inprocess",
+		},
+	})
+	assert.Nil(t, err)
+
+	plugin, err := factory.InitFactory(cfg, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	f := plugin.Factory
+
+	poll := holder("poll", "metrics_annotations")
+	push := holder("push", "graphite_annotations")
+	secret := holder("poll", "metrics_secret")
+
+	assert.Nil(t, f.Start([]*dcommon.ConfigHolder{poll, push, secret}))
+	assert.Equal(t, []*dcommon.ConfigHolder{poll}, recorders["files"].started)
+	assert.Equal(t, []*dcommon.ConfigHolder{push, secret}, recorders["inprocess"].started)
+
+	assert.Nil(t, f.Stop([]*dcommon.ConfigHolder{poll}))
+	assert.Equal(t, []*dcommon.ConfigHolder{poll}, recorders["files"].stopped)
+
+	// Restarting across factories stops on one and starts on the other
+	assert.Nil(t, f.Restart(push, poll))
+	assert.Equal(t, []*dcommon.ConfigHolder{push}, recorders["inprocess"].stopped)
+	assert.Equal(t, []*dcommon.ConfigHolder{poll, poll}, recorders["files"].started)
+
+	assert.Nil(t, f.Restart(push, push))
+	assert.Equal(t, 1, recorders["inprocess"].restarts)
+}
+
+func TestMultiFactoryConfig(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{"name": "multi"},
+		{
+			"name":      "multi",
+			"default":   "missing",
+			"factories": map[string]interface{}{"files": map[string]interface{}{"name": "recorder"}},
+		},
+		{
+			"name":      "multi",
+			"factories": map[string]interface{}{"files": map[string]interface{}{"name": "recorder"}},
+			"routes":    map[string]interface{}{"push": "missing"},
+		},
+		{
+			"name":      "multi",
+			"factories": map[string]interface{}{"files": map[string]interface{}{"name": "unknown"}},
+		},
+	} {
+		cfg, err := common.NewConfigFrom(raw)
+		assert.Nil(t, err)
+
+		_, err = factory.InitFactory(cfg, nil)
+		assert.NotNil(t, err)
+	}
+}
+
+func holder(route, builder string) *dcommon.ConfigHolder {
+	return &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": builder},
+		Meta: dcommon.Meta{
+			dcommon.MetaRoute:   route,
+			dcommon.MetaBuilder: builder,
+		},
+	}
+}
+
+type recordingFactory struct {
+	// err fails every Start
+	err      error
+	started  []*dcommon.ConfigHolder
+	stopped  []*dcommon.ConfigHolder
+	restarts int
+}
+
+func newRecordingFactory(cfg *common.Config, _ factory.Meta) (factory.Factory, error) {
+	id, _ := cfg.String("id", -1)
+	f := &recordingFactory{}
+	recorders[id] = f
+	return f, nil
+}
+
+func (f *recordingFactory) Start(holders []*dcommon.ConfigHolder) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.started = append(f.started, holders...)
+	return nil
+}
+
+func (f *recordingFactory) Stop(holders []*dcommon.ConfigHolder) error {
+	f.stopped = append(f.stopped, holders...)
+	return nil
+}
+
+func (f *recordingFactory) Restart(old, new *dcommon.ConfigHolder) error {
+	f.restarts++
+	return nil
+}
+
+func TestNewMultiFactory(t *testing.T) {
+	logs, metrics := &recordingFactory{}, &recordingFactory{}
+	f := New(map[string]factory.Factory{"logs": logs, "metrics": metrics},
+		map[string]string{"log_annotations": "logs"}, "metrics")
+
+	log := holder("poll", "log_annotations")
+	metric := holder("poll", "metrics_annotations")
+
+	assert.Nil(t, f.Start([]*dcommon.ConfigHolder{log, metric}))
+	assert.Equal(t, []*dcommon.ConfigHolder{log}, logs.started)
+	assert.Equal(t, []*dcommon.ConfigHolder{metric}, metrics.started)
+}
+
+func TestMultiFactoryPartialStart(t *testing.T) {
+	logs, metrics := &recordingFactory{err: errors.New("no logs")}, &recordingFactory{}
+	f := New(map[string]factory.Factory{"logs": logs, "metrics": metrics},
+		map[string]string{"log_annotations": "logs"}, "metrics")
+
+	log := holder("poll", "log_annotations")
+	metric := holder("poll", "metrics_annotations")
+
+	// Holders of the failing child are reported as failed, the others as kept
+	holders := []*dcommon.ConfigHolder{log, metric}
+	err := f.Start(holders)
+	assert.NotNil(t, err)
+	assert.Equal(t, []*dcommon.ConfigHolder{metric}, factory.Accepted(holders, err))
+}
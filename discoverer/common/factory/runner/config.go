@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+)
+
+type runnerConfig struct {
+	Retry retryConfig `config:"retry"`
+}
+
+// retryConfig controls the exponential backoff used when runner creation fails
+type retryConfig struct {
+	Initial     time.Duration `config:"initial"`
+	Max         time.Duration `config:"max"`
+	MaxAttempts int           `config:"max_attempts"`
+}
+
+func defaultConfig() runnerConfig {
+	return runnerConfig{
+		Retry: retryConfig{
+			Initial:     time.Second,
+			Max:         5 * time.Minute,
+			MaxAttempts: 10,
+		},
+	}
+}
+
+func (c *retryConfig) Validate() error {
+	if c.Initial <= 0 || c.Max < c.Initial {
+		return fmt.Errorf("retry.initial must be positive and not larger than retry.max")
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("retry.max_attempts must not be negative")
+	}
+	return nil
+}
+
+// backoff returns the delay before the given retry attempt
+func (c *retryConfig) backoff(attempt int) time.Duration {
+	delay := c.Initial
+	for i := 1; i < attempt && delay < c.Max; i++ {
+		delay *= 2
+	}
+
+	if delay > c.Max {
+		return c.Max
+	}
+	return delay
+}
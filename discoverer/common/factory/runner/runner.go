@@ -2,125 +2,299 @@ package runner
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+	"github.com/joeshaw/multierror"
 	"github.com/mitchellh/hashstructure"
 
 	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
 )
 
 var (
 	debug = logp.MakeDebug("runner_factory")
+
+	runnersStarted = monitoring.NewInt(metrics.Registry, "factory.runner.starts")
+	runnersStopped = monitoring.NewInt(metrics.Registry, "factory.runner.stops")
+	runnersFailed  = monitoring.NewInt(metrics.Registry, "factory.runner.failures")
+	runnersRunning = monitoring.NewInt(metrics.Registry, "factory.runner.running")
 )
 
 func init() {
 	factory.RegisterFactoryPlugin("runner", newRunnerFactory)
+	factory.RegisterFactorySchema("runner", schema.Schema{
+		{Name: "retry.initial", Type: schema.Duration, Default: "1s", Description: "Delay before retrying a runner that failed to start"},
+		{Name: "retry.max", Type: schema.Duration, Default: "5m", Description: "Maximum delay between retries"},
+		{Name: "retry.max_attempts", Type: schema.Int, Default: 10, Description: "Attempts to start a runner before it is given up, 0 retries forever"},
+	})
+}
+
+// runnerState is the lifecycle state of a single runner
+type runnerState int
+
+const (
+	statePending runnerState = iota
+	stateRunning
+	// stateRetrying is set on runners that failed to start and are retried
+	stateRetrying
+	// stateFailed is set on runners that are given up after retry.max_attempts
+	stateFailed
+)
+
+func (s runnerState) String() string {
+	switch s {
+	case statePending:
+		return "pending"
+	case stateRunning:
+		return "running"
+	case stateRetrying:
+		return "retrying"
+	case stateFailed:
+		return "failed"
+	}
+	return "unknown"
 }
 
 type runnerFactory struct {
 	factory cfgfile.RunnerFactory
 	runners runnerCache
+	retry   retryConfig
+}
+
+// runnerEntry tracks a runner created from a single config holder
+type runnerEntry struct {
+	holder   *dcommon.ConfigHolder
+	runner   cfgfile.Runner
+	state    runnerState
+	err      error
+	attempts int
+	timer    *time.Timer
 }
 
 type runnerCache struct {
 	sync.Mutex
-	runners map[uint64]cfgfile.Runner
+	runners map[uint64]*runnerEntry
 }
 
 func NewRunnerCache() runnerCache {
 	return runnerCache{
-		runners: make(map[uint64]cfgfile.Runner),
+		runners: make(map[uint64]*runnerEntry),
 	}
 }
 
-func newRunnerFactory(_ *common.Config, meta factory.Meta) (factory.Factory, error) {
+func newRunnerFactory(cfg *common.Config, meta factory.Meta) (factory.Factory, error) {
 	if meta == nil {
 		return nil, fmt.Errorf("Unable to get config file runner factory")
 	}
 
+	config := defaultConfig()
+	if cfg != nil {
+		if err := cfg.Unpack(&config); err != nil {
+			return nil, fmt.Errorf("Unable to unpack runner factory config due to error: %v", err)
+		}
+	}
+
+	if beatMeta, ok := meta.(*factory.BeatMeta); ok {
+		if beatMeta.RunnerFactory == nil {
+			return nil, fmt.Errorf("Runner factory is not supported by this beat")
+		}
+		meta = beatMeta.RunnerFactory
+	}
+
 	if factory, ok := meta.(cfgfile.RunnerFactory); ok {
-		return &runnerFactory{factory: factory, runners: NewRunnerCache()}, nil
+		return &runnerFactory{factory: factory, runners: NewRunnerCache(), retry: config.Retry}, nil
 	} else {
 		return nil, fmt.Errorf("Unable to cast object to cfgfile.RunnerFactory")
 	}
 }
 
+// Start creates a runner for every holder. A holder that fails to start does not prevent
+// the others from starting and is retried in the background, so all holders are kept.
 func (r *runnerFactory) Start(holders []*dcommon.ConfigHolder) error {
+	var errs multierror.Errors
 	for _, holder := range holders {
-		config := holder.Config
-		id := configHash(config)
-		runner, err := r.buildModuleRunner(config)
+		if err := r.startHolder(holder); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) != 0 {
+		return &factory.StartError{Err: errs.Err()}
+	}
+	return nil
+}
 
-		if err != nil {
+func (r *runnerFactory) startHolder(holder *dcommon.ConfigHolder) error {
+	id := configHash(holder.Config)
+
+	r.runners.Lock()
+	if _, ok := r.runners.runners[id]; ok {
+		r.runners.Unlock()
+		debug("Runner %d already exists", id)
+		return nil
+	}
+
+	entry := &runnerEntry{holder: holder, state: statePending}
+	r.runners.runners[id] = entry
+	r.runners.Unlock()
+
+	return r.create(id, entry)
+}
+
+// create builds and starts the runner of an entry and schedules a retry on failure until
+// retry.max_attempts is reached
+func (r *runnerFactory) create(id uint64, entry *runnerEntry) error {
+	runner, err := r.buildModuleRunner(entry.holder.Config)
+
+	r.runners.Lock()
+	// The holder was stopped while the runner was being created
+	if r.runners.runners[id] != entry {
+		r.runners.Unlock()
+		if runner != nil {
+			runner.Stop()
+			debug("Stopped runner %d created for a removed config", id)
+		}
+		return nil
+	}
+	defer r.runners.Unlock()
+
+	entry.attempts++
+	if err != nil {
+		runnersFailed.Inc()
+		entry.err = err
+		if r.retry.MaxAttempts > 0 && entry.attempts >= r.retry.MaxAttempts {
+			entry.state = stateFailed
+			entry.timer = nil
+			logp.Err("Runner %d failed to start %d times, giving up: %v", id, entry.attempts, err)
 			return err
 		}
-		r.runners.Lock()
-		runner.Start()
-		logp.Info("Starting runner %d", id)
-		r.runners.runners[id] = runner
-		r.runners.Unlock()
+
+		entry.state = stateRetrying
+		delay := r.retry.backoff(entry.attempts)
+		entry.timer = time.AfterFunc(delay, func() { r.create(id, entry) })
+		logp.Err("Runner %d failed to start (attempt %d), retrying in %v: %v", id, entry.attempts, delay, err)
+		return err
 	}
 
+	runner.Start()
+	runnersStarted.Inc()
+	runnersRunning.Inc()
+	entry.runner = runner
+	entry.state = stateRunning
+	entry.err = nil
+	entry.timer = nil
+	logp.Info("Starting runner %d", id)
 	return nil
 }
 
 func (r *runnerFactory) Stop(holders []*dcommon.ConfigHolder) error {
 	for _, holder := range holders {
-		config := holder.Config
-		id := configHash(config)
-
-		r.runners.Lock()
-		if run, ok := r.runners.runners[id]; ok {
-			run.Stop()
-			logp.Info("Stopping runner %d", id)
-			delete(r.runners.runners, id)
-		}
+		r.remove(configHash(holder.Config))
 	}
 
 	return nil
 }
 
-func (r *runnerFactory) Restart(oldHolder, newHolder *dcommon.ConfigHolder) error {
-	old := oldHolder.Config
-	oldID := configHash(old)
-	new := newHolder.Config
-	newID := configHash(new)
-
-	// Do not restart the module if there is no change
+// remove stops the runner with the given id and cancels any pending retries
+func (r *runnerFactory) remove(id uint64) {
 	r.runners.Lock()
-	_, ok := r.runners.runners[oldID]
+	entry, ok := r.runners.runners[id]
+	if ok {
+		delete(r.runners.runners, id)
+	}
 	r.runners.Unlock()
-	if oldID == newID && ok {
-		debug("Not restarting as configs remain the same")
-		return nil
+
+	if !ok {
+		return
 	}
 
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	if entry.runner != nil {
+		entry.runner.Stop()
+		runnersStopped.Inc()
+		runnersRunning.Dec()
+		logp.Info("Stopping runner %d", id)
+	}
+}
+
+// Flush stops all runners and pending retries before exit
+func (r *runnerFactory) Flush() error {
 	r.runners.Lock()
-	if run, ok := r.runners.runners[oldID]; ok {
-		run.Stop()
-		logp.Info("Stopping old runner %d", oldID)
-		delete(r.runners.runners, oldID)
+	ids := make([]uint64, 0, len(r.runners.runners))
+	for id := range r.runners.runners {
+		ids = append(ids, id)
 	}
 	r.runners.Unlock()
 
-	runner, err := r.buildModuleRunner(new)
-	if err != nil {
-		return err
+	for _, id := range ids {
+		r.remove(id)
 	}
+	return nil
+}
 
-	if runner != nil {
-		r.runners.Lock()
-		logp.Info("Starting new runner %d", newID)
-		runner.Start()
-		r.runners.runners[newID] = runner
-		r.runners.Unlock()
+// runnerStatus describes a runner created from a holder. Configs can hold credentials, so
+// runners are only identified by the hash of their config.
+type runnerStatus struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Status lists all runners sorted by id
+func (r *runnerFactory) Status() interface{} {
+	r.runners.Lock()
+	defer r.runners.Unlock()
+
+	runners := make([]runnerStatus, 0, len(r.runners.runners))
+	for id, entry := range r.runners.runners {
+		status := runnerStatus{
+			ID:       strconv.FormatUint(id, 10),
+			State:    entry.state.String(),
+			Attempts: entry.attempts,
+		}
+		if entry.err != nil {
+			status.Error = entry.err.Error()
+		}
+		runners = append(runners, status)
 	}
 
-	return nil
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].ID < runners[j].ID
+	})
+
+	return common.MapStr{
+		"name":    "runner",
+		"runners": runners,
+	}
+}
+
+func (r *runnerFactory) Restart(oldHolder, newHolder *dcommon.ConfigHolder) error {
+	oldID := configHash(oldHolder.Config)
+	newID := configHash(newHolder.Config)
+
+	// Do not restart the module if there is no change
+	r.runners.Lock()
+	_, ok := r.runners.runners[oldID]
+	r.runners.Unlock()
+	if oldID == newID && ok {
+		debug("Not restarting as configs remain the same")
+		return nil
+	}
+
+	r.remove(oldID)
+	return r.startHolder(newHolder)
 }
 
 func (r *runnerFactory) buildModuleRunner(config common.MapStr) (cfgfile.Runner, error) {
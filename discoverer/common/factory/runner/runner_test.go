@@ -1,6 +1,8 @@
 package runner
 
 import (
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -8,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/cfgfile"
 	"github.com/elastic/beats/libbeat/common"
 	pubtest "github.com/elastic/beats/libbeat/publisher/testing"
 	"github.com/elastic/beats/metricbeat/mb"
@@ -73,6 +76,205 @@ func TestRunnerRestart(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestRunnerRetry(t *testing.T) {
+	fac := &flakyRunnerFactory{failures: 2}
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"retry.initial": "10ms",
+		"retry.max":     "20ms",
+	})
+	assert.Nil(t, err)
+
+	f, err := newRunnerFactory(cfg, fac)
+	assert.Nil(t, err)
+	runner := f.(*runnerFactory)
+
+	good := &dcommon.ConfigHolder{Config: common.MapStr{"module": "good"}}
+	bad := &dcommon.ConfigHolder{Config: common.MapStr{"module": "flaky"}}
+	started, stopped, failed := runnersStarted.Get(), runnersStopped.Get(), runnersFailed.Get()
+	running := runnersRunning.Get()
+
+	// A failing holder must not keep the others from starting
+	err = runner.Start([]*dcommon.ConfigHolder{bad, good})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, stateRunning, runnerStateOf(runner, good))
+	assert.Equal(t, stateRetrying, runnerStateOf(runner, bad))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runnerStateOf(runner, bad) != stateRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, stateRunning, runnerStateOf(runner, bad))
+	assert.Equal(t, 3, fac.attempts())
+
+	// Stopping releases the lock so that subsequent calls do not block
+	assert.Nil(t, runner.Stop([]*dcommon.ConfigHolder{good, bad}))
+	assert.Nil(t, runner.Stop([]*dcommon.ConfigHolder{good}))
+	assert.Len(t, runner.runners.runners, 0)
+
+	assert.Equal(t, int64(2), runnersStarted.Get()-started)
+	assert.Equal(t, int64(2), runnersStopped.Get()-stopped)
+	assert.Equal(t, int64(2), runnersFailed.Get()-failed)
+	assert.Equal(t, running, runnersRunning.Get())
+}
+
+func TestRunnerStopCancelsRetry(t *testing.T) {
+	fac := &flakyRunnerFactory{failures: 100}
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"retry.initial": "10ms",
+		"retry.max":     "10ms",
+	})
+	assert.Nil(t, err)
+
+	f, err := newRunnerFactory(cfg, fac)
+	assert.Nil(t, err)
+
+	bad := &dcommon.ConfigHolder{Config: common.MapStr{"module": "flaky"}}
+	assert.NotNil(t, f.Start([]*dcommon.ConfigHolder{bad}))
+	assert.Nil(t, f.Stop([]*dcommon.ConfigHolder{bad}))
+
+	attempts := fac.attempts()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, attempts, fac.attempts())
+}
+
+func TestRunnerGivesUp(t *testing.T) {
+	fac := &flakyRunnerFactory{failures: 100}
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"retry.initial":      "5ms",
+		"retry.max":          "5ms",
+		"retry.max_attempts": 3,
+	})
+	assert.Nil(t, err)
+
+	f, err := newRunnerFactory(cfg, fac)
+	assert.Nil(t, err)
+	runner := f.(*runnerFactory)
+
+	bad := &dcommon.ConfigHolder{Config: common.MapStr{"module": "flaky"}}
+	assert.NotNil(t, runner.Start([]*dcommon.ConfigHolder{bad}))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runnerStateOf(runner, bad) != stateFailed && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, stateFailed, runnerStateOf(runner, bad))
+
+	// Failed runners are not retried and show up in the status
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 3, fac.attempts())
+	status := runner.Status().(common.MapStr)["runners"].([]runnerStatus)
+	if assert.Len(t, status, 1) {
+		assert.Equal(t, "failed", status[0].State)
+		assert.Equal(t, "Unable to create module runner due to error flaky runner", status[0].Error)
+	}
+}
+
+func TestRunnerStoppedWhileCreating(t *testing.T) {
+	fac := &blockingRunnerFactory{created: make(chan *fakeRunner), release: make(chan struct{})}
+	f, err := newRunnerFactory(nil, fac)
+	assert.Nil(t, err)
+
+	holder := &dcommon.ConfigHolder{Config: common.MapStr{"module": "slow"}}
+	done := make(chan struct{})
+	go func() {
+		f.Start([]*dcommon.ConfigHolder{holder})
+		close(done)
+	}()
+
+	// The holder is stopped before its runner is created
+	runner := <-fac.created
+	assert.Nil(t, f.Stop([]*dcommon.ConfigHolder{holder}))
+	close(fac.release)
+	<-done
+
+	assert.True(t, runner.stopped)
+	assert.False(t, runner.started)
+}
+
+func TestRunnerFlush(t *testing.T) {
+	fac := &flakyRunnerFactory{failures: 100}
+	f, err := newRunnerFactory(nil, fac)
+	assert.Nil(t, err)
+	runner := f.(*runnerFactory)
+
+	good := &dcommon.ConfigHolder{Config: common.MapStr{"module": "good"}}
+	bad := &dcommon.ConfigHolder{Config: common.MapStr{"module": "flaky"}}
+	assert.NotNil(t, runner.Start([]*dcommon.ConfigHolder{good, bad}))
+
+	// Flushing stops running runners and pending retries
+	assert.Nil(t, runner.Flush())
+	assert.Len(t, runner.runners.runners, 0)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retry := retryConfig{Initial: time.Second, Max: 10 * time.Second}
+
+	assert.Equal(t, time.Second, retry.backoff(1))
+	assert.Equal(t, 2*time.Second, retry.backoff(2))
+	assert.Equal(t, 8*time.Second, retry.backoff(4))
+	assert.Equal(t, 10*time.Second, retry.backoff(5))
+	assert.Equal(t, 10*time.Second, retry.backoff(100))
+}
+
+func runnerStateOf(r *runnerFactory, holder *dcommon.ConfigHolder) runnerState {
+	r.runners.Lock()
+	defer r.runners.Unlock()
+
+	entry, ok := r.runners.runners[configHash(holder.Config)]
+	if !ok {
+		return -1
+	}
+	return entry.state
+}
+
+// flakyRunnerFactory fails to create runners for the "flaky" module a given number of times
+type flakyRunnerFactory struct {
+	sync.Mutex
+	failures int
+	calls    int
+}
+
+func (f *flakyRunnerFactory) Create(cfg *common.Config) (cfgfile.Runner, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	module, _ := cfg.String("module", -1)
+	if module == "flaky" {
+		f.calls++
+		if f.calls <= f.failures {
+			return nil, errors.New("flaky runner")
+		}
+	}
+	return &fakeRunner{}, nil
+}
+
+func (f *flakyRunnerFactory) attempts() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls
+}
+
+type fakeRunner struct {
+	started, stopped bool
+}
+
+func (f *fakeRunner) Start() { f.started = true }
+func (f *fakeRunner) Stop()  { f.stopped = true }
+
+// blockingRunnerFactory hands out runners once release is closed
+type blockingRunnerFactory struct {
+	created chan *fakeRunner
+	release chan struct{}
+}
+
+func (f *blockingRunnerFactory) Create(cfg *common.Config) (cfgfile.Runner, error) {
+	runner := &fakeRunner{}
+	f.created <- runner
+	<-f.release
+	return runner, nil
+}
+
 const (
 	moduleName       = "fake"
 	eventFetcherName = "EventFetcher"
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// HolderSet keeps holders by key in the order they were first added
+type HolderSet struct {
+	sync.Mutex
+	key     func(*ConfigHolder) (string, error)
+	holders map[string]*ConfigHolder
+	order   []string
+}
+
+// NewHolderSet creates an empty set identifying holders with key
+func NewHolderSet(key func(*ConfigHolder) (string, error)) *HolderSet {
+	return &HolderSet{
+		key:     key,
+		holders: make(map[string]*ConfigHolder),
+	}
+}
+
+// Add adds holders, replacing the ones with the same key. Empty holders are ignored.
+func (s *HolderSet) Add(holders ...*ConfigHolder) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, holder := range holders {
+		if holder == nil || len(holder.Config) == 0 {
+			continue
+		}
+
+		id, err := s.key(holder)
+		if err != nil {
+			logp.Err("Unable to hash config due to error: %v", err)
+			continue
+		}
+
+		if _, ok := s.holders[id]; !ok {
+			s.order = append(s.order, id)
+		}
+		s.holders[id] = holder
+	}
+}
+
+// Remove removes the holders with the same key as holders
+func (s *HolderSet) Remove(holders ...*ConfigHolder) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, holder := range holders {
+		if holder == nil {
+			continue
+		}
+
+		id, err := s.key(holder)
+		if err != nil {
+			logp.Err("Unable to hash config due to error: %v", err)
+			continue
+		}
+
+		if _, ok := s.holders[id]; !ok {
+			continue
+		}
+
+		delete(s.holders, id)
+		for i, existing := range s.order {
+			if existing == id {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// List returns the holders grouped by namespace, pod and builder
+func (s *HolderSet) List() []*ConfigHolder {
+	s.Lock()
+	defer s.Unlock()
+
+	out := make([]*ConfigHolder, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.holders[id])
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return GroupKey(out[i]) < GroupKey(out[j])
+	})
+	return out
+}
+
+// Len returns the number of holders
+func (s *HolderSet) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.holders)
+}
+
+// GroupKey returns the namespace, pod and builder a holder was generated for
+func GroupKey(holder *ConfigHolder) string {
+	meta := holder.Meta
+	return meta.GetString(MetaNamespace) + "/" + meta.GetString(MetaPodName) + "/" + meta.GetString(MetaBuilder)
+}
@@ -1,14 +1,65 @@
 package common
 
 import (
+	"fmt"
+
 	"github.com/elastic/beats/libbeat/common"
 )
 
+// Well known Meta keys describing where a generated config originated from and where it goes
+const (
+	MetaBuilder     = "builder"
+	MetaPodUID      = "pod_uid"
+	MetaPodName     = "pod_name"
+	MetaNamespace   = "pod_namespace"
+	MetaContainer   = "container"
+	MetaAnnotations = "annotations"
+	MetaLabels      = "labels"
+	// MetaPrefix is the prefix of the annotations the builder read
+	MetaPrefix = "prefix"
+	// MetaRoute is the key used by factories to decide where a config is dispatched to
+	MetaRoute = "route"
+)
+
+// RouteModule is the route of configs that run a filebeat module instead of a prospector.
+// Filebeat runs them through config.modules.
+const RouteModule = "filebeat_module"
+
+// Kinds of data a generated config collects
+const (
+	KindLogs    = "logs"
+	KindMetrics = "metrics"
+)
+
 type Meta common.MapStr
 
+// GetString returns the string value stored under key or an empty string if it is not set
+func (m Meta) GetString(key string) string {
+	if m == nil {
+		return ""
+	}
+
+	value, ok := m[key]
+	if !ok || value == nil {
+		return ""
+	}
+
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return fmt.Sprint(value)
+}
+
 type ConfigHolder struct {
 	Config common.MapStr
 	Meta   Meta
+	// Builder is the registry name of the builder that generated the config
+	Builder string
+	// Kind is the kind of data the config collects, empty if its builder does not declare one
+	Kind string
+	// Source is the object the config was generated from. It is nil for configs of push
+	// builders as they are generated from all objects.
+	Source interface{}
 }
 
 func (c *ConfigHolder) GetConfigFromHolder() *common.Config {
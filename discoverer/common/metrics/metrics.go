@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+// Registry holds the self-monitoring metrics of discovery. They are reported with the beat's
+// own metrics under the "discovery" namespace.
+var Registry = monitoring.Default.NewRegistry("discovery")
+
+var lock sync.Mutex
+
+// Int returns the metric registered under the dotted name in r. Metrics are created on first
+// use so that names can include builder, factory or appender names known only at runtime.
+func Int(r *monitoring.Registry, name string) *monitoring.Int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if v, ok := r.Get(name).(*monitoring.Int); ok {
+		return v
+	}
+	return monitoring.NewInt(r, name)
+}
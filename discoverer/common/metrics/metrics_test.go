@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+func TestInt(t *testing.T) {
+	r := monitoring.NewRegistry()
+
+	started := Int(r, "runner.starts")
+	started.Inc()
+
+	// The same metric is returned for the same name
+	assert.Equal(t, started, Int(r, "runner.starts"))
+	assert.Equal(t, int64(1), Int(r, "runner.starts").Get())
+
+	assert.Equal(t, int64(0), Int(r, "runner.stops").Get())
+}
@@ -2,12 +2,13 @@ package registry
 
 import (
 	"errors"
-	"sync"
-
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 
 	"github.com/elastic/beats/libbeat/common"
 	p "github.com/elastic/beats/libbeat/plugin"
@@ -20,11 +21,15 @@ var BuilderRegistry = NewRegister()
 // Register contains Builder to use on pod indexing and event matching
 type Register struct {
 	sync.RWMutex
-	builders  map[string]builder.BuilderConstructor
-	appenders map[string]appender.AppenderConstructor
+	builders        map[string]builder.BuilderConstructor
+	appenders       map[string]appender.AppenderConstructor
+	clientAppenders map[string]appender.ClientAppenderConstructor
 
 	defaultBuilderConfigs  map[string]common.Config
 	defaultAppenderConfigs map[string]common.Config
+
+	builderSchemas  map[string]schema.Schema
+	appenderSchemas map[string]schema.Schema
 }
 
 // NewRegister creates and returns a new Register.
@@ -32,8 +37,11 @@ func NewRegister() *Register {
 	return &Register{
 		builders:               make(map[string]builder.BuilderConstructor, 0),
 		appenders:              make(map[string]appender.AppenderConstructor, 0),
+		clientAppenders:        make(map[string]appender.ClientAppenderConstructor, 0),
 		defaultBuilderConfigs:  make(map[string]common.Config, 0),
 		defaultAppenderConfigs: make(map[string]common.Config, 0),
+		builderSchemas:         make(map[string]schema.Schema),
+		appenderSchemas:        make(map[string]schema.Schema),
 	}
 }
 
@@ -74,11 +82,89 @@ func (r *Register) GetAppender(name string) appender.AppenderConstructor {
 	return appender
 }
 
+// AddClientAppender adds an appender that needs the clients of the discoverer to the register
+func (r *Register) AddClientAppender(name string, appender appender.ClientAppenderConstructor) {
+	r.RWMutex.Lock()
+	defer r.RWMutex.Unlock()
+	r.clientAppenders[name] = appender
+}
+
+// GetClientAppender returns the constructor of an appender added with AddClientAppender or
+// AddAppender, the latter ignoring the clients
+func (r *Register) GetClientAppender(name string) appender.ClientAppenderConstructor {
+	r.RWMutex.Lock()
+	defer r.RWMutex.Unlock()
+
+	if constructor, ok := r.clientAppenders[name]; ok {
+		return constructor
+	}
+	return appender.WithClient(r.appenders[name])
+}
+
 // Add Builder to the register
 func (r *Register) AddDefaultBuilderConfig(name string, config common.Config) {
 	r.defaultBuilderConfigs[name] = config
 }
 
+// AddBuilderSchema describes the settings of a builder
+func (r *Register) AddBuilderSchema(name string, s schema.Schema) {
+	r.RWMutex.Lock()
+	defer r.RWMutex.Unlock()
+	r.builderSchemas[name] = s
+}
+
+// AddAppenderSchema describes the settings of an appender
+func (r *Register) AddAppenderSchema(name string, s schema.Schema) {
+	r.RWMutex.Lock()
+	defer r.RWMutex.Unlock()
+	r.appenderSchemas[name] = s
+}
+
+// GetBuilderSchema returns the registered settings of a builder
+func (r *Register) GetBuilderSchema(name string) schema.Schema {
+	r.RWMutex.RLock()
+	defer r.RWMutex.RUnlock()
+	return r.builderSchemas[name]
+}
+
+// GetAppenderSchema returns the registered settings of an appender
+func (r *Register) GetAppenderSchema(name string) schema.Schema {
+	r.RWMutex.RLock()
+	defer r.RWMutex.RUnlock()
+	return r.appenderSchemas[name]
+}
+
+// BuilderNames returns the names of all registered builders, sorted
+func (r *Register) BuilderNames() []string {
+	r.RWMutex.RLock()
+	defer r.RWMutex.RUnlock()
+
+	names := make([]string, 0, len(r.builders))
+	for name := range r.builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AppenderNames returns the names of all registered appenders, sorted
+func (r *Register) AppenderNames() []string {
+	r.RWMutex.RLock()
+	defer r.RWMutex.RUnlock()
+
+	names := make([]string, 0, len(r.appenders)+len(r.clientAppenders))
+	for name := range r.appenders {
+		names = append(names, name)
+	}
+	for name := range r.clientAppenders {
+		if _, ok := r.appenders[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (r *Register) GetDefaultBuilderConfigs() map[string]common.Config {
 	return r.defaultBuilderConfigs
 }
@@ -102,6 +188,11 @@ type appenderPlugin struct {
 	constructor appender.AppenderConstructor
 }
 
+type clientAppenderPlugin struct {
+	name        string
+	constructor appender.ClientAppenderConstructor
+}
+
 func BuilderPlugin(name string, b builder.BuilderConstructor) map[string][]interface{} {
 	return p.MakePlugin(builderKey, builderPlugin{name, b})
 }
@@ -110,6 +201,10 @@ func AppenderPlugin(name string, a appender.AppenderConstructor) map[string][]in
 	return p.MakePlugin(appenderKey, appenderPlugin{name, a})
 }
 
+func ClientAppenderPlugin(name string, a appender.ClientAppenderConstructor) map[string][]interface{} {
+	return p.MakePlugin(appenderKey, clientAppenderPlugin{name, a})
+}
+
 func init() {
 	p.MustRegisterLoader(builderKey, func(ifc interface{}) error {
 		m, ok := ifc.(builderPlugin)
@@ -118,7 +213,6 @@ func init() {
 		}
 
 		name := m.name
-		fmt.Println(name)
 		if BuilderRegistry.GetBuilder(name) != nil {
 			return fmt.Errorf("builder type %v already registered", name)
 		}
@@ -128,17 +222,22 @@ func init() {
 	})
 
 	p.MustRegisterLoader(appenderKey, func(ifc interface{}) error {
-		m, ok := ifc.(appenderPlugin)
-		if !ok {
+		switch m := ifc.(type) {
+		case appenderPlugin:
+			if BuilderRegistry.GetClientAppender(m.name) != nil {
+				return fmt.Errorf("appender type %v already registered", m.name)
+			}
+			BuilderRegistry.AddAppender(m.name, m.constructor)
+
+		case clientAppenderPlugin:
+			if BuilderRegistry.GetClientAppender(m.name) != nil {
+				return fmt.Errorf("appender type %v already registered", m.name)
+			}
+			BuilderRegistry.AddClientAppender(m.name, m.constructor)
+
+		default:
 			return errors.New("plugin does not match appender plugin type")
 		}
-
-		name := m.name
-		if BuilderRegistry.GetAppender(name) != nil {
-			return fmt.Errorf("appender type %v already registered", name)
-		}
-
-		BuilderRegistry.AddAppender(name, m.constructor)
 		return nil
 	})
 }
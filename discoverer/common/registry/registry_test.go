@@ -1,11 +1,13 @@
 package registry
 
 import (
+	"errors"
 	"testing"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 
 	"github.com/stretchr/testify/assert"
 
@@ -62,6 +64,41 @@ func TestRegistry(t *testing.T) {
 	assert.Nil(t, noFoo)
 }
 
+func TestRegistryClientAppenders(t *testing.T) {
+	register := NewRegister()
+	register.AddAppender("plain", newFakeAppender)
+	register.AddClientAppender("client", newFakeClientAppender)
+
+	assert.Equal(t, []string{"client", "plain"}, register.AppenderNames())
+	assert.Nil(t, register.GetAppender("client"))
+	assert.Nil(t, register.GetClientAppender("missing"))
+
+	// Appenders without clients are created through the client constructor as well
+	for _, name := range []string{"plain", "client"} {
+		a, err := register.GetClientAppender(name)(common.NewConfig(), builder.ClientInfo{})
+		assert.Nil(t, err, name)
+		assert.NotNil(t, a, name)
+	}
+}
+
+func TestRegistrySchemas(t *testing.T) {
+	register := NewRegister()
+	register.AddBuilder("foo", newFakeBuilder)
+	register.AddBuilder("bar", newFakeBuilder)
+	register.AddAppender("baz", newFakeAppender)
+
+	assert.Equal(t, []string{"bar", "foo"}, register.BuilderNames())
+	assert.Equal(t, []string{"baz"}, register.AppenderNames())
+
+	fooSchema := schema.Schema{{Name: "prefix", Type: schema.String, Default: "io.foo/"}}
+	register.AddBuilderSchema("foo", fooSchema)
+	register.AddAppenderSchema("baz", schema.Schema{{Name: "token_path", Type: schema.String}})
+
+	assert.Equal(t, fooSchema, register.GetBuilderSchema("foo"))
+	assert.Nil(t, register.GetBuilderSchema("bar"))
+	assert.Len(t, register.GetAppenderSchema("baz"), 1)
+}
+
 // Define a fake builder
 type fakeBuilder struct{}
 
@@ -85,3 +122,10 @@ func (f *fakeAppender) Append(config *dcommon.ConfigHolder) {}
 func newFakeAppender(_ *common.Config) (appender.Appender, error) {
 	return &fakeAppender{}, nil
 }
+
+func newFakeClientAppender(_ *common.Config, client builder.ClientInfo) (appender.Appender, error) {
+	if client == nil {
+		return nil, errors.New("no client")
+	}
+	return &fakeAppender{}, nil
+}
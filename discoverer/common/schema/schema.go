@@ -0,0 +1,24 @@
+package schema
+
+// Types of config settings
+const (
+	String   = "string"
+	Bool     = "bool"
+	Int      = "int"
+	Duration = "duration"
+	List     = "list"
+	Object   = "object"
+)
+
+// Field describes a single config setting of a plugin
+type Field struct {
+	// Name is the dotted path of the setting
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// Schema lists the settings a plugin accepts
+type Schema []Field
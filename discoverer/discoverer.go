@@ -1,26 +1,57 @@
 package discoverer
 
 import (
+	"context"
+
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 
 	_ "github.com/ebay/collectbeat/discoverer/include"
 )
 
-var discovererPlugins = make(map[string]Constructor)
+var (
+	discovererPlugins = make(map[string]Constructor)
+	discovererSchemas = make(map[string]schema.Schema)
+)
 
 type Discoverer interface {
-	Start(builder *Builders)
+	// Start begins discovery and feeds discovered objects to the builders until ctx is
+	// cancelled or Stop is called
+	Start(ctx context.Context, builder *Builders)
+	// Stop ends discovery and returns once all in-flight events have been processed
 	Stop()
 	String() string
 }
 
+// StatusReporter is implemented by discoverers that can describe the objects they know
+// about. The returned value is served as JSON.
+type StatusReporter interface {
+	Status() interface{}
+}
+
+// Resyncer is implemented by discoverers that can reconcile their state on demand
+type Resyncer interface {
+	Resync() error
+}
+
+// Reloader is implemented by discoverers that can apply a changed config while running
+type Reloader interface {
+	Reload(config *common.Config) error
+}
+
 type Constructor func(config *common.Config) (Discoverer, error)
 
 func RegisterDiscovererPlugin(name string, discoverer Constructor) {
 	discovererPlugins[name] = discoverer
 }
 
+// RegisterDiscovererSchema describes the settings of a discoverer plugin
+func RegisterDiscovererSchema(name string, s schema.Schema) {
+	discovererSchemas[name] = s
+}
+
 type DiscovererPlugin struct {
 	Name       string
 	Config     *common.Config
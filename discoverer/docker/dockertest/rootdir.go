@@ -0,0 +1,92 @@
+package dockertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// RootDir is a temporary directory laid out like the docker root directory. Containers it
+// returns describe their storage as the daemon would, relative to DefaultRootDir.
+type RootDir struct {
+	Path string
+}
+
+// NewRootDir creates an empty docker root directory
+func NewRootDir() (*RootDir, error) {
+	dir, err := ioutil.TempDir("", "dockerroot")
+	if err != nil {
+		return nil, err
+	}
+	return &RootDir{Path: dir}, nil
+}
+
+// Close removes the directory
+func (r *RootDir) Close() error {
+	return os.RemoveAll(r.Path)
+}
+
+// Join returns a path inside the directory
+func (r *RootDir) Join(elem ...string) string {
+	return filepath.Join(append([]string{r.Path}, elem...)...)
+}
+
+// Overlay returns a container using the overlay or overlay2 driver and creates its merged
+// directory
+func (r *RootDir) Overlay(id, driver string) (*docker.Container, error) {
+	if err := os.MkdirAll(r.Join(driver, id, "merged"), 0755); err != nil {
+		return nil, err
+	}
+
+	return newContainer(id, driver, map[string]string{
+		"LowerDir":  filepath.Join(DefaultRootDir, driver, id+"-init", "diff"),
+		"UpperDir":  filepath.Join(DefaultRootDir, driver, id, "diff"),
+		"WorkDir":   filepath.Join(DefaultRootDir, driver, id, "work"),
+		"MergedDir": filepath.Join(DefaultRootDir, driver, id, "merged"),
+	}), nil
+}
+
+// Aufs returns a container using the aufs driver, writes its mount id and creates its mount
+func (r *RootDir) Aufs(id, mountID string) (*docker.Container, error) {
+	mounts := r.Join("image", "aufs", "layerdb", "mounts", id)
+	if err := os.MkdirAll(mounts, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(mounts, "mount-id"), []byte(mountID), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.Join("aufs", "mnt", mountID), 0755); err != nil {
+		return nil, err
+	}
+
+	return newContainer(id, "aufs", nil), nil
+}
+
+// DeviceMapper returns a container using the devicemapper driver and creates its root
+// filesystem directory
+func (r *RootDir) DeviceMapper(id, deviceID string) (*docker.Container, error) {
+	if err := os.MkdirAll(r.Join("devicemapper", "mnt", deviceID, "rootfs"), 0755); err != nil {
+		return nil, err
+	}
+
+	return newContainer(id, "devicemapper", map[string]string{
+		"DeviceId":   "42",
+		"DeviceName": "docker-253:0-1048577-" + deviceID,
+		"DeviceSize": "10737418240",
+	}), nil
+}
+
+func newContainer(id, driver string, data map[string]string) *docker.Container {
+	return &docker.Container{
+		ID:     id,
+		Name:   "/" + id,
+		Driver: driver,
+		State:  docker.State{Running: true, Pid: 4242},
+		GraphDriver: &docker.GraphDriver{
+			Name: driver,
+			Data: data,
+		},
+	}
+}
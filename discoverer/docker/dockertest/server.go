@@ -0,0 +1,168 @@
+// Package dockertest provides an in-process fake of the Docker Engine API listening on a
+// unix socket and a temporary docker root directory for tests.
+package dockertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// DefaultRootDir is the docker root directory reported by the server
+const DefaultRootDir = "/var/lib/docker"
+
+// versionPrefix matches the API version clients may prefix paths with
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+/`)
+
+// Server is a fake Docker daemon. It answers info, ping and container inspect requests.
+type Server struct {
+	sync.Mutex
+	dir        string
+	listener   net.Listener
+	server     *http.Server
+	info       docker.DockerInfo
+	containers map[string]*docker.Container
+	failure    int
+	requests   map[string]int
+}
+
+// NewServer starts a fake Docker daemon on a unix socket in a temporary directory
+func NewServer() (*Server, error) {
+	dir, err := ioutil.TempDir("", "dockertest")
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		dir:      dir,
+		listener: listener,
+		info: docker.DockerInfo{
+			ID:            "dockertest",
+			Name:          "dockertest",
+			Driver:        "overlay2",
+			DockerRootDir: DefaultRootDir,
+		},
+		containers: map[string]*docker.Container{},
+		requests:   map[string]int{},
+	}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	go s.server.Serve(listener)
+
+	return s, nil
+}
+
+// Close stops the server and removes its socket
+func (s *Server) Close() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// Endpoint is the address clients connect to
+func (s *Server) Endpoint() string {
+	return "unix://" + s.listener.Addr().String()
+}
+
+// SetInfo replaces the daemon info returned by the server
+func (s *Server) SetInfo(info docker.DockerInfo) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.info = info
+}
+
+// AddContainer creates or replaces a container
+func (s *Server) AddContainer(container *docker.Container) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.containers[container.ID] = container
+}
+
+// RemoveContainer removes a container so that inspecting it fails
+func (s *Server) RemoveContainer(id string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.containers, id)
+}
+
+// Fail makes all requests fail with the given HTTP status code. A code of 0 lets requests
+// succeed again.
+func (s *Server) Fail(code int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.failure = code
+}
+
+// Requests returns how many requests were made for a path without API version
+func (s *Server) Requests(path string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.requests[path]
+}
+
+func (s *Server) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if versionPrefix.MatchString(path) {
+		path = "/" + versionPrefix.ReplaceAllString(path, "")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests[path]++
+	if s.failure != 0 {
+		writeError(rw, s.failure, http.StatusText(s.failure))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(rw, http.StatusMethodNotAllowed, r.Method+" is not supported")
+		return
+	}
+
+	switch {
+	case path == "/_ping":
+		rw.Write([]byte("OK"))
+	case path == "/info":
+		writeJSON(rw, s.info)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		container, ok := s.containers[id]
+		if !ok {
+			writeError(rw, http.StatusNotFound, fmt.Sprintf("No such container: %s", id))
+			return
+		}
+		writeJSON(rw, container)
+	default:
+		writeError(rw, http.StatusNotFound, "page not found")
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(obj)
+}
+
+func writeError(rw http.ResponseWriter, code int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(map[string]string{"message": message})
+}
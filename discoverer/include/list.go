@@ -8,10 +8,14 @@ import (
 	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/metrics_secret"
 
 	// Include all appenders
+	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/appender/add_fields"
 	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/appender/auth"
 	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/appender/log_path"
+	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/appender/processors"
 
 	// Include all factories
 	_ "github.com/ebay/collectbeat/discoverer/common/factory/cfgfile"
+	_ "github.com/ebay/collectbeat/discoverer/common/factory/dryrun"
+	_ "github.com/ebay/collectbeat/discoverer/common/factory/multi"
 	_ "github.com/ebay/collectbeat/discoverer/common/factory/runner"
 )
@@ -0,0 +1,339 @@
+package add_fields
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+
+	"github.com/ericchiang/k8s"
+)
+
+const (
+	AddFields = "add_fields"
+
+	// Sources of the values of templates
+	sourceEnv            = "env"
+	sourceNode           = "node"
+	sourceNodeLabel      = "node_label"
+	sourceNamespace      = "namespace"
+	sourceNamespaceLabel = "namespace_label"
+	sourcePodLabel       = "pod_label"
+
+	// unknownHost is the host of the discoverer when the node it runs on can not be found
+	unknownHost = "localhost"
+
+	requestTimeout = 5 * time.Second
+)
+
+var (
+	debug = logp.MakeDebug(AddFields)
+
+	// templates look like %{env:CLUSTER}, ucfg would already expand ${CLUSTER}
+	templatePattern = regexp.MustCompile(`%\{([a-z_]+)(?::([^}]*))?\}`)
+)
+
+func init() {
+	registry.BuilderRegistry.AddClientAppender(AddFields, NewAddFieldsAppender)
+	registry.BuilderRegistry.AddAppenderSchema(AddFields, schema.Schema{
+		{Name: "rules", Type: schema.List, Description: "Fields added to the configs matching modules, builders, kinds, namespaces and labels"},
+		{Name: "fields_under_root", Type: schema.Bool, Default: true, Description: "Set fields_under_root on configs that do not set it"},
+	})
+}
+
+// rule adds fields to the configs it matches. Empty conditions match all configs. String
+// values are templates that may read environment variables and labels.
+type rule struct {
+	appender.Rule `config:",inline"`
+	Fields        common.MapStr `config:"fields" validate:"required"`
+}
+
+// AddFieldsAppender merges fields into the fields of configs, next to the kubernetes metadata
+// of builders. Node and namespace labels are read from the API server once and again when the
+// discoverer checks for changes.
+type AddFieldsAppender struct {
+	sync.Mutex
+	rules           []rule
+	fieldsUnderRoot bool
+	client          *k8s.Client
+	specs           kubecommon.PodSpecs
+	host            string
+	ctx             context.Context
+	// nodeLabels and namespaceLabels are nil until they are read
+	nodeLabels      map[string]string
+	namespaceLabels map[string]map[string]string
+	// namespacePods holds the UIDs of the pods namespace labels were read for by namespace
+	namespacePods map[string]map[string]bool
+}
+
+func NewAddFieldsAppender(cfg *common.Config, clientInfo builder.ClientInfo) (appender.Appender, error) {
+	config := struct {
+		Rules           []rule `config:"rules"`
+		FieldsUnderRoot bool   `config:"fields_under_root"`
+	}{
+		FieldsUnderRoot: true,
+	}
+
+	err := cfg.Unpack(&config)
+	if err != nil {
+		return nil, fmt.Errorf("fail to unpack the `add_fields` appender configuration: %s", err)
+	}
+
+	client, _ := clientInfo[kubecommon.ClientKey].(*k8s.Client)
+	host, _ := clientInfo[kubecommon.HostKey].(string)
+	specs, _ := clientInfo[kubecommon.PodSpecsKey].(kubecommon.PodSpecs)
+	if host == unknownHost {
+		host = ""
+	}
+
+	for i := range config.Rules {
+		config.Rules[i].Fields = config.Rules[i].Fields.Flatten()
+		for key, value := range config.Rules[i].Fields {
+			str, ok := value.(string)
+			if !ok {
+				continue
+			}
+			for _, match := range templatePattern.FindAllStringSubmatch(str, -1) {
+				switch match[1] {
+				case sourceEnv, sourceNodeLabel, sourceNamespaceLabel, sourcePodLabel:
+					if match[2] == "" {
+						return nil, fmt.Errorf("field %s of rule %d needs a name in %s", key, i, match[0])
+					}
+				case sourceNode, sourceNamespace:
+				default:
+					return nil, fmt.Errorf("field %s of rule %d reads unknown source %s", key, i, match[1])
+				}
+				if (match[1] == sourceNodeLabel || match[1] == sourceNamespaceLabel) && client == nil {
+					return nil, fmt.Errorf("field %s of rule %d reads labels but there is no kubernetes client", key, i)
+				}
+				if match[1] == sourceNodeLabel && host == "" {
+					logp.Warn("%s: Field %s of rule %d is left out as the node collectbeat runs on is unknown", AddFields, key, i)
+				}
+			}
+		}
+	}
+
+	return &AddFieldsAppender{
+		rules:           config.Rules,
+		fieldsUnderRoot: config.FieldsUnderRoot,
+		client:          client,
+		specs:           specs,
+		host:            host,
+		ctx:             context.Background(),
+		namespaceLabels: map[string]map[string]string{},
+		namespacePods:   map[string]map[string]bool{},
+	}, nil
+}
+
+func (a *AddFieldsAppender) Append(configHolder *dcommon.ConfigHolder) {
+	config := configHolder.Config
+	if config == nil {
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	fields := common.MapStr{}
+	for _, r := range a.rules {
+		if !r.Matches(configHolder) {
+			continue
+		}
+
+		for key, value := range r.Fields {
+			if str, ok := value.(string); ok {
+				if value, ok = a.expand(str, configHolder.Meta); !ok {
+					debug("Skipping field %s as a value of %s is missing", key, str)
+					continue
+				}
+			}
+			fields[key] = value
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	for _, target := range appender.Targets(configHolder) {
+		a.addFields(target, fields)
+	}
+}
+
+// addFields puts fields into the fields of a config, replacing values at the same keys
+func (a *AddFieldsAppender) addFields(config, fields common.MapStr) {
+	target, ok := config["fields"].(common.MapStr)
+	if !ok {
+		target = common.MapStr{}
+		if existing, ok := config["fields"].(map[string]interface{}); ok {
+			target.Update(common.MapStr(existing))
+		}
+		config["fields"] = target
+	}
+
+	for key, value := range fields {
+		target.Put(key, value)
+	}
+
+	if _, ok := config["fields_under_root"]; !ok && a.fieldsUnderRoot {
+		config["fields_under_root"] = true
+	}
+}
+
+// expand replaces the templates of a value. It returns false if a value is missing.
+func (a *AddFieldsAppender) expand(value string, meta dcommon.Meta) (string, bool) {
+	found := true
+	out := templatePattern.ReplaceAllStringFunc(value, func(template string) string {
+		match := templatePattern.FindStringSubmatch(template)
+		source, name := match[1], match[2]
+
+		var result string
+		var ok bool
+		switch source {
+		case sourceEnv:
+			result, ok = os.LookupEnv(name)
+		case sourceNode:
+			result, ok = a.host, a.host != ""
+		case sourceNodeLabel:
+			result, ok = a.getNodeLabels()[name]
+		case sourceNamespace:
+			result = meta.GetString(dcommon.MetaNamespace)
+			ok = result != ""
+		case sourceNamespaceLabel:
+			result, ok = a.getNamespaceLabels(meta.GetString(dcommon.MetaNamespace), meta.GetString(dcommon.MetaPodUID))[name]
+		case sourcePodLabel:
+			labels, _ := meta[dcommon.MetaLabels].(map[string]string)
+			result, ok = labels[name]
+		}
+
+		if !ok {
+			found = false
+		}
+		return result
+	})
+	return out, found
+}
+
+// getNodeLabels returns the labels of the node. Without a known node there are no labels.
+func (a *AddFieldsAppender) getNodeLabels() map[string]string {
+	if a.host == "" {
+		return nil
+	}
+	if a.nodeLabels == nil {
+		// Labels that can not be read are missing until the next check for changes
+		a.nodeLabels, _ = a.readNodeLabels()
+	}
+	return a.nodeLabels
+}
+
+// getNamespaceLabels returns the labels of a namespace and records the pod they are read for
+func (a *AddFieldsAppender) getNamespaceLabels(namespace, uid string) map[string]string {
+	if namespace == "" {
+		return nil
+	}
+
+	if a.namespacePods[namespace] == nil {
+		a.namespacePods[namespace] = map[string]bool{}
+	}
+	a.namespacePods[namespace][uid] = true
+
+	labels, ok := a.namespaceLabels[namespace]
+	if !ok {
+		labels, _ = a.readNamespaceLabels(namespace)
+		a.namespaceLabels[namespace] = labels
+	}
+	return labels
+}
+
+// readNodeLabels returns the labels of the node or no labels and the error if they can not be
+// read
+func (a *AddFieldsAppender) readNodeLabels() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(a.ctx, requestTimeout)
+	defer cancel()
+	node, err := a.client.CoreV1().GetNode(ctx, a.host)
+	if err != nil {
+		appender.ReportError(AddFields)
+		logp.Err("Unable to get node %s due to error: %v", a.host, err)
+		return map[string]string{}, err
+	}
+	return labelsOf(node.GetMetadata().GetLabels()), nil
+}
+
+// readNamespaceLabels returns the labels of a namespace or no labels and the error if they can
+// not be read
+func (a *AddFieldsAppender) readNamespaceLabels(namespace string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(a.ctx, requestTimeout)
+	defer cancel()
+	ns, err := a.client.CoreV1().GetNamespace(ctx, namespace)
+	if err != nil {
+		appender.ReportError(AddFields)
+		logp.Err("Unable to get namespace %s due to error: %v", namespace, err)
+		return map[string]string{}, err
+	}
+	return labelsOf(ns.GetMetadata().GetLabels()), nil
+}
+
+// Changed reads the labels that were used again and reports whether any of them changed.
+// Labels that can not be read keep their previous value and namespaces without known pods
+// are forgotten.
+func (a *AddFieldsAppender) Changed() bool {
+	a.Lock()
+	defer a.Unlock()
+
+	changed := false
+	if a.nodeLabels != nil {
+		if labels, err := a.readNodeLabels(); err == nil && !reflect.DeepEqual(labels, a.nodeLabels) {
+			a.nodeLabels = labels
+			changed = true
+		}
+	}
+
+	a.prune()
+	for namespace, old := range a.namespaceLabels {
+		if labels, err := a.readNamespaceLabels(namespace); err == nil && !reflect.DeepEqual(labels, old) {
+			a.namespaceLabels[namespace] = labels
+			changed = true
+		}
+	}
+	return changed
+}
+
+// prune forgets the labels of namespaces whose pods are gone. Configs not generated for a pod
+// keep the labels of their namespace. Without the specs of pods nothing is forgotten.
+func (a *AddFieldsAppender) prune() {
+	if a.specs == nil {
+		return
+	}
+
+	for namespace, pods := range a.namespacePods {
+		for uid := range pods {
+			if uid != "" && a.specs.GetPodSpec(uid) == nil {
+				delete(pods, uid)
+			}
+		}
+		if len(pods) == 0 {
+			delete(a.namespacePods, namespace)
+			delete(a.namespaceLabels, namespace)
+		}
+	}
+}
+
+// labelsOf returns labels, never nil so that read labels can be told from missing ones
+func labelsOf(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
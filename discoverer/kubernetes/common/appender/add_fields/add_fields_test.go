@@ -0,0 +1,228 @@
+package add_fields
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/log_annotations"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+
+	corev1 "github.com/ericchiang/k8s/api/v1"
+)
+
+func TestAddFields(t *testing.T) {
+	os.Setenv("ADD_FIELDS_CLUSTER", "minikube")
+	defer os.Unsetenv("ADD_FIELDS_CLUSTER")
+
+	server := kubetest.NewServer()
+	defer server.Close()
+	node := kubetest.NewNode("node-1")
+	node.Metadata.Labels["failure-domain.beta.kubernetes.io/zone"] = "us-west-1a"
+	server.AddNode(node)
+	ns := kubetest.NewNamespace("payments")
+	ns.Metadata.Labels = map[string]string{"cost-center": "cc-42"}
+	server.AddNamespace(ns)
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{
+				"fields": map[string]interface{}{
+					"kubernetes.cluster.name": "%{env:ADD_FIELDS_CLUSTER}",
+					"kubernetes.node.zone":    "%{node_label:failure-domain.beta.kubernetes.io/zone}",
+					"cost_center":             "%{namespace_label:cost-center}",
+					"environment":             "prod",
+					"missing":                 "%{env:ADD_FIELDS_MISSING}",
+				},
+			},
+			{
+				"builders": []string{log_annotations.LogAnnotationsBuilder},
+				"labels":   map[string]string{"app": "api"},
+				"fields": map[string]interface{}{
+					"service":     "%{pod_label:app}-%{namespace}",
+					"environment": "staging",
+				},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	a, err := NewAddFieldsAppender(config, builder.ClientInfo{
+		kubecommon.ClientKey: server.Client(),
+		kubecommon.HostKey:   "node-1",
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Fields are merged with the kubernetes metadata of the builder
+	h := &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"type":              "log",
+			"fields":            common.MapStr{"kubernetes": common.MapStr{"pod": common.MapStr{"name": "web"}}},
+			"fields_under_root": true,
+		},
+		Builder: log_annotations.LogAnnotationsBuilder,
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: "payments",
+			dcommon.MetaLabels:    map[string]string{"app": "api"},
+		},
+	}
+	a.Append(h)
+	assert.Equal(t, common.MapStr{
+		"kubernetes": common.MapStr{
+			"pod":     common.MapStr{"name": "web"},
+			"cluster": common.MapStr{"name": "minikube"},
+			"node":    common.MapStr{"zone": "us-west-1a"},
+		},
+		"cost_center": "cc-42",
+		"environment": "staging",
+		"service":     "api-payments",
+	}, h.Config["fields"])
+
+	// Configs without fields get them under root, missing labels are skipped
+	h = &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "prometheus"},
+		Meta:   dcommon.Meta{dcommon.MetaNamespace: "other"},
+	}
+	a.Append(h)
+	assert.Equal(t, true, h.Config["fields_under_root"])
+	assert.Equal(t, common.MapStr{
+		"kubernetes":  common.MapStr{"cluster": common.MapStr{"name": "minikube"}, "node": common.MapStr{"zone": "us-west-1a"}},
+		"environment": "prod",
+	}, h.Config["fields"])
+
+	// Filebeat modules take the fields in their filesets
+	h = &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"module": "nginx",
+			"access": common.MapStr{"enabled": true, "prospector": common.MapStr{}},
+		},
+		Meta: dcommon.Meta{dcommon.MetaRoute: dcommon.RouteModule},
+	}
+	a.Append(h)
+	assert.Nil(t, h.Config["fields"])
+	prospector := h.Config["access"].(common.MapStr)["prospector"].(common.MapStr)
+	assert.Equal(t, "prod", prospector["fields"].(common.MapStr)["environment"])
+
+	// Changed labels are read again
+	refresher := a.(appender.Refresher)
+	assert.False(t, refresher.Changed())
+	ns.Metadata.Labels["cost-center"] = "cc-7"
+	server.AddNamespace(ns)
+	assert.True(t, refresher.Changed())
+
+	h = &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "prometheus"},
+		Meta:   dcommon.Meta{dcommon.MetaNamespace: "payments"},
+	}
+	a.Append(h)
+	assert.Equal(t, "cc-7", h.Config["fields"].(common.MapStr)["cost_center"])
+}
+
+func TestAddFieldsUnknownNode(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{"fields": map[string]interface{}{"zone": "%{node_label:zone}", "node": "%{node}", "team": "core"}},
+		},
+	})
+	assert.Nil(t, err)
+	a, err := NewAddFieldsAppender(config, builder.ClientInfo{
+		kubecommon.ClientKey: server.Client(),
+		kubecommon.HostKey:   "localhost",
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Without a known node its fields are left out and the node is never looked up
+	h := &dcommon.ConfigHolder{Config: common.MapStr{"module": "prometheus"}}
+	a.Append(h)
+	assert.Equal(t, common.MapStr{"team": "core"}, h.Config["fields"])
+	assert.False(t, a.(appender.Refresher).Changed())
+	assert.Equal(t, 0, server.Requests("GET", kubetest.Nodes))
+}
+
+func TestAddFieldsNamespaceLabels(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+	ns := kubetest.NewNamespace("payments")
+	ns.Metadata.Labels = map[string]string{"cost-center": "cc-42"}
+	server.AddNamespace(ns)
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{"fields": map[string]interface{}{"cost_center": "%{namespace_label:cost-center}"}},
+		},
+	})
+	assert.Nil(t, err)
+	specs := podSpecs{"web": &corev1.PodSpec{}}
+	a, err := NewAddFieldsAppender(config, builder.ClientInfo{
+		kubecommon.ClientKey:   server.Client(),
+		kubecommon.PodSpecsKey: specs,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	costCenter := func() interface{} {
+		h := &dcommon.ConfigHolder{
+			Config: common.MapStr{"module": "prometheus"},
+			Meta:   dcommon.Meta{dcommon.MetaNamespace: "payments", dcommon.MetaPodUID: "web"},
+		}
+		a.Append(h)
+		return h.Config["fields"].(common.MapStr)["cost_center"]
+	}
+	assert.Equal(t, "cc-42", costCenter())
+
+	// Labels that can not be read again are kept
+	refresher := a.(appender.Refresher)
+	server.Fail(kubetest.Namespaces, http.StatusInternalServerError)
+	assert.False(t, refresher.Changed())
+	server.Fail(kubetest.Namespaces, 0)
+	assert.Equal(t, "cc-42", costCenter())
+
+	// Namespaces are no longer read once their pods are gone
+	delete(specs, "web")
+	requests := server.Requests("GET", kubetest.Namespaces)
+	assert.False(t, refresher.Changed())
+	assert.Equal(t, requests, server.Requests("GET", kubetest.Namespaces))
+}
+
+func TestAddFieldsConfig(t *testing.T) {
+	for _, fields := range []map[string]interface{}{
+		{"zone": "%{node_label:zone}"},
+		{"cost_center": "%{namespace_label:cost-center}"},
+		{"cluster": "%{cluster}"},
+		{"cluster": "%{env}"},
+	} {
+		config, err := common.NewConfigFrom(map[string]interface{}{
+			"rules": []map[string]interface{}{{"fields": fields}},
+		})
+		assert.Nil(t, err)
+		_, err = NewAddFieldsAppender(config, nil)
+		assert.NotNil(t, err, "%v", fields)
+	}
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"rules": []map[string]interface{}{{"modules": []string{"prometheus"}}},
+	})
+	assert.Nil(t, err)
+	_, err = NewAddFieldsAppender(config, nil)
+	assert.NotNil(t, err)
+}
+
+// podSpecs holds the specs of known pods by UID
+type podSpecs map[string]*corev1.PodSpec
+
+func (p podSpecs) GetPodSpec(uid string) *corev1.PodSpec {
+	return p[uid]
+}
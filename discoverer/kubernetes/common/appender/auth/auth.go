@@ -1,96 +1,262 @@
 package auth
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/paths"
+
+	"github.com/ericchiang/k8s"
 )
 
 const (
 	Auth = "auth"
+
+	defaultTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+var (
+	debug = logp.MakeDebug(Auth)
+
+	defaultNamespaces = []string{"apiserver", "scheduler", "controller_manager"}
 )
 
 func init() {
-	registry.BuilderRegistry.AddAppender(Auth, NewSecurityAppender)
+	registry.BuilderRegistry.AddClientAppender(Auth, NewSecurityAppender)
+	registry.BuilderRegistry.AddAppenderSchema(Auth, schema.Schema{
+		{Name: "namespaces", Type: schema.List, Default: defaultNamespaces, Description: "Metric namespaces of prometheus modules that get the service account token"},
+		{Name: "token_path", Type: schema.String, Default: defaultTokenPath, Description: "Path of the service account token"},
+		{Name: "rules", Type: schema.List, Description: "Credentials added to the configs matching modules, builders, kinds, namespaces, labels and hosts"},
+		{Name: "secrets_dir", Type: schema.String, Default: "${path.data}/auth", Description: "Directory the certificates and keys used by modules are written to"},
+	})
 
 	cfg := common.NewConfig()
 	// Register default builders
 	registry.BuilderRegistry.AddDefaultAppenderConfig(Auth, *cfg)
 }
 
-type SecurityAppender struct {
-	Namespaces []string
-	TokenPath  string
+// rule adds credentials to the configs it matches. Empty conditions match all configs.
+type rule struct {
+	appender.Rule `config:",inline"`
+	// MetricNamespaces match the namespace setting of modules such as prometheus
+	MetricNamespaces []string `config:"metric_namespaces"`
+	// Hosts are patterns of the hosts of the module, such as *.kube-system.svc
+	Hosts []string `config:"hosts"`
+
+	BearerTokenFile string     `config:"bearer_token_file"`
+	Username        string     `config:"username"`
+	Password        string     `config:"password"`
+	SSL             tlsFiles   `config:"ssl"`
+	Secret          *secretRef `config:"secret"`
+}
+
+func (r *rule) Validate() error {
+	if r.BearerTokenFile == "" && r.Username == "" && r.SSL == (tlsFiles{}) && r.Secret == nil {
+		return fmt.Errorf("a rule needs bearer_token_file, username, ssl or secret")
+	}
+	if (r.SSL.Certificate == "") != (r.SSL.Key == "") {
+		return fmt.Errorf("ssl needs both certificate and key")
+	}
+	for _, host := range r.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return fmt.Errorf("host pattern '%s' is invalid: %v", host, err)
+		}
+	}
+	return nil
 }
 
-func NewSecurityAppender(cfg *common.Config) (appender.Appender, error) {
+// SecurityAppender adds credentials to configs. The first rule matching a config is used.
+// Credentials are read once and read again when the discoverer checks for changes, so that
+// rotated tokens and certificates restart the configs using them.
+type SecurityAppender struct {
+	sync.Mutex
+	rules      []rule
+	secretsDir string
+	client     *k8s.Client
+	ctx        context.Context
+	// creds holds the credentials of rules by index once they were read
+	creds map[int]*credentials
+	// used holds the rules that matched a config, only their credentials are checked for changes
+	used map[int]bool
+}
 
+func NewSecurityAppender(cfg *common.Config, clientInfo builder.ClientInfo) (appender.Appender, error) {
 	config := struct {
 		Namespaces []string `config:"namespaces"`
 		TokenPath  string   `config:"token_path"`
+		Rules      []rule   `config:"rules"`
+		SecretsDir string   `config:"secrets_dir"`
 	}{
-		Namespaces: []string{"apiserver", "scheduler", "controller_manager"},
-		TokenPath:  "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		Namespaces: defaultNamespaces,
+		TokenPath:  defaultTokenPath,
+		SecretsDir: paths.Resolve(paths.Data, Auth),
 	}
 
 	err := cfg.Unpack(&config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("fail to unpack the `auth` appender configuration: %s", err)
+	}
+
+	client, _ := clientInfo[kubecommon.ClientKey].(*k8s.Client)
+	for i, r := range config.Rules {
+		if r.Secret != nil && client == nil {
+			return nil, fmt.Errorf("rule %d reads a secret but there is no kubernetes client", i)
+		}
+	}
+
+	// The service account token of prometheus modules is kept for existing configs
+	rules := config.Rules
+	if len(config.Namespaces) != 0 && config.TokenPath != "" {
+		rules = append(rules, rule{
+			Rule:             appender.Rule{Scope: appender.Scope{Modules: []string{"prometheus"}}},
+			MetricNamespaces: config.Namespaces,
+			BearerTokenFile:  config.TokenPath,
+		})
 	}
 
 	return &SecurityAppender{
-		Namespaces: config.Namespaces,
-		TokenPath:  config.TokenPath,
+		rules:      rules,
+		secretsDir: config.SecretsDir,
+		client:     client,
+		ctx:        context.Background(),
+		creds:      map[int]*credentials{},
+		used:       map[int]bool{},
 	}, nil
 }
 
+// Scope limits the appender to metric configs as only metricbeat modules take credentials
+func (i *SecurityAppender) Scope() appender.Scope {
+	return appender.Scope{Kinds: []string{dcommon.KindMetrics}}
+}
+
 func (i *SecurityAppender) Append(configHolder *dcommon.ConfigHolder) {
 	config := configHolder.Config
 	if config == nil {
 		return
 	}
 
-	if config["module"] == "prometheus" {
-		namespace, ok := config["namespace"]
-		if ok {
-			for _, ns := range i.Namespaces {
-				if ns == namespace {
-					token := i.getAuthHeaderFromToken()
-					if token != "" {
-						headers := common.MapStr{}
-						headers["Authorization"] = token
-
-						config["headers"] = headers
-					}
-					return
-				}
+	i.Lock()
+	defer i.Unlock()
+
+	for index, r := range i.rules {
+		if !r.matches(configHolder) {
+			continue
+		}
+		i.used[index] = true
+
+		creds, ok := i.creds[index]
+		if !ok {
+			var err error
+			if creds, err = i.load(&r); err != nil {
+				appender.ReportError(Auth)
+				logp.Err("Unable to read credentials of rule %d due to error: %v", index, err)
+				return
 			}
+			i.creds[index] = creds
 		}
+
+		creds.apply(config)
+		return
 	}
 }
 
-func (i *SecurityAppender) getAuthHeaderFromToken() string {
-	var token string
+// Changed reads the credentials of the rules that matched configs again and reports whether
+// any of them changed or could be read for the first time
+func (i *SecurityAppender) Changed() bool {
+	i.Lock()
+	defer i.Unlock()
 
-	if i.TokenPath != "" {
-		b, err := ioutil.ReadFile(i.TokenPath)
+	changed := false
+	for index := range i.used {
+		creds, err := i.load(&i.rules[index])
 		if err != nil {
-			logp.Err("Reading token file failed with err: %v", err)
+			appender.ReportError(Auth)
+			logp.Err("Unable to read credentials of rule %d due to error: %v", index, err)
+			continue
 		}
 
-		if len(b) != 0 {
-			if b[len(b)-1] == '\n' {
-				b = b[0 : len(b)-1]
+		if old, ok := i.creds[index]; !ok || !reflect.DeepEqual(old, creds) {
+			debug("Credentials of rule %d changed", index)
+			i.creds[index] = creds
+			changed = true
+		}
+	}
+
+	if changed {
+		i.removeUnused()
+	}
+	return changed
+}
+
+func (r *rule) matches(holder *dcommon.ConfigHolder) bool {
+	if !r.Rule.Matches(holder) {
+		return false
+	}
+
+	config := holder.Config
+	if len(r.MetricNamespaces) != 0 {
+		namespace, ok := config["namespace"]
+		if !ok || !appender.Contains(r.MetricNamespaces, fmt.Sprint(namespace)) {
+			return false
+		}
+	}
+
+	if len(r.Hosts) != 0 {
+		for _, host := range hosts(config) {
+			for _, pattern := range r.Hosts {
+				if ok, _ := path.Match(pattern, host); ok {
+					return true
+				}
 			}
-			token = fmt.Sprintf("Bearer %s", string(b))
 		}
+		return false
+	}
+	return true
+}
+
+// hosts returns the host names of the hosts setting of a module
+func hosts(config common.MapStr) []string {
+	var raw []string
+	switch list := config["hosts"].(type) {
+	case []string:
+		raw = list
+	case []interface{}:
+		for _, host := range list {
+			raw = append(raw, fmt.Sprint(host))
+		}
+	case string:
+		raw = []string{list}
 	}
 
-	return token
+	out := []string{}
+	for _, host := range raw {
+		if strings.Contains(host, "://") {
+			if u, err := url.Parse(host); err == nil {
+				out = append(out, u.Hostname())
+			}
+			continue
+		}
+
+		host = strings.SplitN(host, "/", 2)[0]
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		out = append(out, host)
+	}
+	return out
 }
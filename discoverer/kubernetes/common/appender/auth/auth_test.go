@@ -3,9 +3,14 @@ package auth
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -20,7 +25,7 @@ func TestAuth(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	sec, err := NewSecurityAppender(config)
+	sec, err := NewSecurityAppender(config, nil)
 	assert.NotNil(t, sec)
 	assert.Nil(t, err)
 
@@ -63,3 +68,192 @@ func writeFile(name, message string) {
 func deleteFile(name string) {
 	os.Remove(name)
 }
+
+func TestAuthRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	token := filepath.Join(dir, "token")
+	writeFile(token, "abc\n")
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"token_path":  token,
+		"secrets_dir": dir,
+		"rules": []map[string]interface{}{
+			{
+				"modules":  []string{"prometheus"},
+				"hosts":    []string{"*.kube-system.svc"},
+				"username": "admin",
+				"password": "secret",
+			},
+			{
+				"namespaces":        []string{"payments"},
+				"labels":            map[string]string{"app": "api"},
+				"bearer_token_file": token,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	a, err := NewSecurityAppender(config, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Rules win over the service account token of prometheus modules
+	h := &dcommon.ConfigHolder{Config: common.MapStr{
+		"module":    "prometheus",
+		"namespace": "apiserver",
+		"hosts":     []string{"https://metrics.kube-system.svc:443/metrics"},
+	}}
+	a.Append(h)
+	assert.Equal(t, "admin", h.Config["username"])
+	assert.Equal(t, "secret", h.Config["password"])
+	assert.Nil(t, h.Config["headers"])
+
+	h = &dcommon.ConfigHolder{Config: common.MapStr{
+		"module":    "prometheus",
+		"namespace": "apiserver",
+		"hosts":     []string{"10.0.0.1:443"},
+	}}
+	a.Append(h)
+	assert.Equal(t, common.MapStr{"Authorization": "Bearer abc"}, h.Config["headers"])
+
+	// Any module of matching pods, other headers are kept
+	h = &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"module":  "jolokia",
+			"headers": map[string]interface{}{"Accept": "application/json"},
+		},
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: "payments",
+			dcommon.MetaLabels:    map[string]string{"app": "api", "tier": "backend"},
+		},
+	}
+	a.Append(h)
+	assert.Equal(t, common.MapStr{"Accept": "application/json", "Authorization": "Bearer abc"}, h.Config["headers"])
+
+	h = &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "jolokia"},
+		Meta:   dcommon.Meta{dcommon.MetaNamespace: "payments"},
+	}
+	a.Append(h)
+	assert.Nil(t, h.Config["headers"])
+
+	for _, rules := range []map[string]interface{}{
+		{"modules": []string{"prometheus"}},
+		{"ssl": map[string]interface{}{"certificate": "/tls.crt"}, "username": "admin"},
+		{"hosts": []string{"["}, "username": "admin"},
+		{"secret": map[string]interface{}{"namespace": "default", "name": "creds"}},
+	} {
+		config, err = common.NewConfigFrom(map[string]interface{}{"rules": []map[string]interface{}{rules}})
+		assert.Nil(t, err)
+		_, err = NewSecurityAppender(config, nil)
+		assert.NotNil(t, err, "%v", rules)
+	}
+}
+
+func TestAuthRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	secretsDir := filepath.Join(dir, "secrets")
+	token, cert, key := filepath.Join(dir, "token"), filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(token, "one")
+	writeFile(cert, "cert one")
+	writeFile(key, "key one")
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"namespaces":  []string{},
+		"secrets_dir": secretsDir,
+		"rules": []map[string]interface{}{{
+			"bearer_token_file": token,
+			"ssl":               map[string]interface{}{"certificate": cert, "key": key},
+		}},
+	})
+	assert.Nil(t, err)
+	a, err := NewSecurityAppender(config, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	refresher := a.(appender.Refresher)
+
+	build := func() common.MapStr {
+		h := &dcommon.ConfigHolder{Config: common.MapStr{"module": "http"}}
+		a.Append(h)
+		return h.Config
+	}
+
+	first := build()
+	assert.Equal(t, "Bearer one", first["headers"].(common.MapStr)["Authorization"])
+	ssl := first["ssl"].(common.MapStr)
+	data, err := ioutil.ReadFile(ssl["certificate"].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, "cert one", string(data))
+	assert.False(t, refresher.Changed())
+
+	// Rotated files change the configs
+	writeFile(token, "two")
+	writeFile(cert, "cert two")
+	assert.True(t, refresher.Changed())
+	assert.False(t, refresher.Changed())
+
+	second := build()
+	assert.Equal(t, "Bearer two", second["headers"].(common.MapStr)["Authorization"])
+	assert.NotEqual(t, ssl["certificate"], second["ssl"].(common.MapStr)["certificate"])
+	assert.Equal(t, ssl["key"], second["ssl"].(common.MapStr)["key"])
+
+	// Files of the old certificate are removed
+	_, err = os.Stat(ssl["certificate"].(string))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAuthSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	server := kubetest.NewServer()
+	defer server.Close()
+	server.AddSecret(kubetest.NewSecret("monitoring", "creds", map[string]string{
+		"username": "admin",
+		"password": "secret",
+		"ca.crt":   "ca",
+	}))
+
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"secrets_dir": dir,
+		"rules": []map[string]interface{}{{
+			"modules": []string{"mongodb"},
+			"secret":  map[string]interface{}{"namespace": "monitoring", "name": "creds"},
+		}},
+	})
+	assert.Nil(t, err)
+	a, err := NewSecurityAppender(config, builder.ClientInfo{kubecommon.ClientKey: server.Client()})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	h := &dcommon.ConfigHolder{Config: common.MapStr{"module": "mongodb"}}
+	a.Append(h)
+	assert.Equal(t, "admin", h.Config["username"])
+	assert.Equal(t, "secret", h.Config["password"])
+	cas := h.Config["ssl"].(common.MapStr)["certificate_authorities"].([]string)
+	if assert.Len(t, cas, 1) {
+		data, err := ioutil.ReadFile(cas[0])
+		assert.Nil(t, err)
+		assert.Equal(t, "ca", string(data))
+	}
+
+	server.AddSecret(kubetest.NewSecret("monitoring", "creds", map[string]string{"username": "admin", "password": "rotated"}))
+	assert.True(t, a.(appender.Refresher).Changed())
+	h = &dcommon.ConfigHolder{Config: common.MapStr{"module": "mongodb"}}
+	a.Append(h)
+	assert.Equal(t, "rotated", h.Config["password"])
+	assert.Nil(t, h.Config["ssl"])
+}
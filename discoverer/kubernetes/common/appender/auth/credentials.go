@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// Keys of the secrets holding credentials, as used by kubernetes.io/tls and
+// kubernetes.io/basic-auth secrets
+const (
+	secretToken    = "token"
+	secretUsername = "username"
+	secretPassword = "password"
+	secretCert     = "tls.crt"
+	secretKey      = "tls.key"
+	secretCA       = "ca.crt"
+)
+
+// Extensions of the files written to the secrets directory
+var extensions = map[string]string{
+	secretCert: ".crt",
+	secretKey:  ".key",
+	secretCA:   ".ca",
+}
+
+type tlsFiles struct {
+	Certificate          string `config:"certificate"`
+	Key                  string `config:"key"`
+	CertificateAuthority string `config:"certificate_authority"`
+}
+
+type secretRef struct {
+	Namespace string `config:"namespace" validate:"required"`
+	Name      string `config:"name" validate:"required"`
+}
+
+// credentials are added to the configs matching a rule. Certificates and keys are files in the
+// secrets directory named after their content, so that configs change when they are rotated.
+type credentials struct {
+	Token    string
+	Username string
+	Password string
+	TLS      tlsFiles
+}
+
+// load reads the credentials of a rule from its files and secret. Values of the secret win.
+func (i *SecurityAppender) load(r *rule) (*credentials, error) {
+	creds := &credentials{Username: r.Username, Password: r.Password}
+	pem := map[string][]byte{}
+
+	if r.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(r.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		creds.Token = strings.TrimSpace(string(token))
+	}
+
+	for key, file := range map[string]string{
+		secretCert: r.SSL.Certificate,
+		secretKey:  r.SSL.Key,
+		secretCA:   r.SSL.CertificateAuthority,
+	} {
+		if file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pem[key] = data
+	}
+
+	if r.Secret != nil {
+		secret, err := i.client.CoreV1().GetSecret(i.ctx, r.Secret.Name, r.Secret.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get secret %s/%s: %v", r.Secret.Namespace, r.Secret.Name, err)
+		}
+
+		data := secret.GetData()
+		if token, ok := data[secretToken]; ok {
+			creds.Token = strings.TrimSpace(string(token))
+		}
+		if username, ok := data[secretUsername]; ok {
+			creds.Username = string(username)
+			creds.Password = string(data[secretPassword])
+		}
+		for key := range extensions {
+			if value, ok := data[key]; ok {
+				pem[key] = value
+			}
+		}
+	}
+
+	if (len(pem[secretCert]) == 0) != (len(pem[secretKey]) == 0) {
+		return nil, fmt.Errorf("a certificate needs a key")
+	}
+
+	for key, data := range pem {
+		file, err := i.write(key, data)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case secretCert:
+			creds.TLS.Certificate = file
+		case secretKey:
+			creds.TLS.Key = file
+		case secretCA:
+			creds.TLS.CertificateAuthority = file
+		}
+	}
+
+	if *creds == (credentials{}) {
+		return nil, fmt.Errorf("no credentials found")
+	}
+	return creds, nil
+}
+
+// write stores a certificate or key in the secrets directory unless it is already there
+func (i *SecurityAppender) write(key string, data []byte) (string, error) {
+	if err := os.MkdirAll(i.secretsDir, 0700); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	file := filepath.Join(i.secretsDir, fmt.Sprintf("%x%s", sum[:8], extensions[key]))
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return "", err
+	}
+	return file, os.Rename(tmp, file)
+}
+
+// removeUnused deletes the files of the secrets directory no rule uses anymore
+func (i *SecurityAppender) removeUnused() {
+	used := map[string]bool{}
+	for _, creds := range i.creds {
+		used[creds.TLS.Certificate] = true
+		used[creds.TLS.Key] = true
+		used[creds.TLS.CertificateAuthority] = true
+	}
+
+	for _, ext := range extensions {
+		files, _ := filepath.Glob(filepath.Join(i.secretsDir, "*"+ext))
+		for _, file := range files {
+			if !used[file] {
+				debug("Removing unused file %s", file)
+				os.Remove(file)
+			}
+		}
+	}
+}
+
+// apply adds the credentials to a module config, keeping the other headers and ssl settings
+func (c *credentials) apply(config common.MapStr) {
+	if c.Token != "" {
+		headers := common.MapStr{}
+		switch existing := config["headers"].(type) {
+		case common.MapStr:
+			headers.Update(existing)
+		case map[string]interface{}:
+			headers.Update(common.MapStr(existing))
+		case map[string]string:
+			for key, value := range existing {
+				headers[key] = value
+			}
+		}
+		headers["Authorization"] = "Bearer " + c.Token
+		config["headers"] = headers
+	}
+
+	if c.Username != "" {
+		config["username"] = c.Username
+		config["password"] = c.Password
+	}
+
+	if c.TLS != (tlsFiles{}) {
+		ssl := common.MapStr{}
+		switch existing := config["ssl"].(type) {
+		case common.MapStr:
+			ssl.Update(existing)
+		case map[string]interface{}:
+			ssl.Update(common.MapStr(existing))
+		}
+		if c.TLS.Certificate != "" {
+			ssl["certificate"] = c.TLS.Certificate
+			ssl["key"] = c.TLS.Key
+		}
+		if c.TLS.CertificateAuthority != "" {
+			ssl["certificate_authorities"] = []string{c.TLS.CertificateAuthority}
+		}
+		config["ssl"] = ssl
+	}
+}
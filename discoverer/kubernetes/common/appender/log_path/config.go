@@ -0,0 +1,40 @@
+package log_path
+
+import (
+	"fmt"
+
+	dc "github.com/ebay/collectbeat/discoverer/docker/common"
+)
+
+// Strategies to find the root filesystem of a container
+const (
+	// StrategyGraphDriver reads the mount of the container from the docker storage driver
+	StrategyGraphDriver = "graph_driver"
+	// StrategyProc uses /proc/<pid>/root of the main process of the container
+	StrategyProc = "proc"
+	// StrategyAuto uses the storage driver and falls back to /proc
+	StrategyAuto = "auto"
+)
+
+type logPathConfig struct {
+	dc.Config `config:",inline"`
+	Strategy  string `config:"strategy"`
+	// ProcDir is where the /proc of the host is mounted
+	ProcDir string `config:"proc_dir"`
+}
+
+func defaultConfig() logPathConfig {
+	return logPathConfig{
+		Config:   dc.DefaultDockerConfig(),
+		Strategy: StrategyGraphDriver,
+		ProcDir:  "/proc",
+	}
+}
+
+func (c *logPathConfig) Validate() error {
+	switch c.Strategy {
+	case StrategyGraphDriver, StrategyProc, StrategyAuto:
+		return nil
+	}
+	return fmt.Errorf("strategy must be one of %s, %s or %s", StrategyGraphDriver, StrategyProc, StrategyAuto)
+}
@@ -3,13 +3,13 @@ package log_path
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
-	"reflect"
+	"path/filepath"
 	"strings"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	dc "github.com/ebay/collectbeat/discoverer/docker/common"
 	"github.com/fsouza/go-dockerclient"
 
@@ -26,34 +26,68 @@ const (
 	DeviceMapper string = "devicemapper"
 )
 
+var debug = logp.MakeDebug(LogPath)
+
 func init() {
 	registry.BuilderRegistry.AddAppender(LogPath, NewLogPathAppender)
+	registry.BuilderRegistry.AddAppenderSchema(LogPath, schema.Schema{
+		{Name: "host", Type: schema.String, Default: "unix:///var/run/docker.sock", Description: "Docker daemon to look up containers with"},
+		{Name: "root_dir", Type: schema.String, Default: "/var/lib/docker", Description: "Root directory of the docker daemon"},
+		{Name: "strategy", Type: schema.String, Default: StrategyGraphDriver, Description: "How container filesystems are found: graph_driver, proc or auto"},
+		{Name: "proc_dir", Type: schema.String, Default: "/proc", Description: "Where the /proc of the host is mounted"},
+		{Name: "ssl.enabled", Type: schema.Bool, Description: "Connect to the docker daemon over TLS"},
+		{Name: "ssl.certificate_authority", Type: schema.String, Description: "CA used to verify the docker daemon"},
+		{Name: "ssl.certificate", Type: schema.String, Description: "Client certificate"},
+		{Name: "ssl.key", Type: schema.String, Description: "Client certificate key"},
+	})
 }
 
 type LogPathAppender struct {
 	dockerClient *docker.Client
-	rootDir      string
+	// rootDir is where the docker root directory is found, dockerRootDir is where the daemon
+	// reports it. They differ when collectbeat sees the host filesystem under another path.
+	rootDir       string
+	dockerRootDir string
+	strategy      string
+	proc          *procResolver
 }
 
 func NewLogPathAppender(cfg *common.Config) (appender.Appender, error) {
-	config := dc.DefaultDockerConfig()
+	config := defaultConfig()
 	if err := cfg.Unpack(&config); err != nil {
 		return nil, err
 	}
 
-	client, err := dc.NewDockerClient(config.Host, config)
-	if err != nil {
-		return nil, err
+	l := &LogPathAppender{
+		rootDir:  filepath.Clean(config.RootDir),
+		strategy: config.Strategy,
+		proc:     &procResolver{procDir: filepath.Clean(config.ProcDir)},
 	}
 
-	_, err = client.Info()
+	client, err := dc.NewDockerClient(config.Host, config.Config)
+	if err == nil {
+		var info *docker.DockerInfo
+		if info, err = client.Info(); err == nil {
+			l.dockerClient = client
+			l.dockerRootDir = info.DockerRootDir
+			l.proc.dockerClient = client
+		}
+	}
+
+	// Docker is only required to read the storage driver mounts
 	if err != nil {
-		return nil, err
+		if config.Strategy == StrategyGraphDriver {
+			return nil, err
+		}
+		logp.Warn("%s: Unable to connect to docker, using %s only: %v", LogPath, config.ProcDir, err)
 	}
 
-	return &LogPathAppender{
-		dockerClient: client,
-	}, nil
+	return l, nil
+}
+
+// Scope limits the appender to log configs as only they read files
+func (l *LogPathAppender) Scope() appender.Scope {
+	return appender.Scope{Kinds: []string{dcommon.KindLogs}}
 }
 
 func (l *LogPathAppender) Append(configHolder *dcommon.ConfigHolder) {
@@ -68,74 +102,133 @@ func (l *LogPathAppender) Append(configHolder *dcommon.ConfigHolder) {
 	}
 
 	for key, value := range configHolder.Meta {
-		container, err := l.dockerClient.InspectContainer(key)
-		if err != nil {
-			logp.Err("Unable to get container info for container %s due to error: %v", key, err)
-			continue
-		}
-
+		// Custom log paths are stored against the container ID
 		paths, _ := value.([]string)
 		if len(paths) == 0 {
 			continue
 		}
-		driver := container.Driver
-		switch driver {
-		case Overlay, Overlay2:
-			if container.GraphDriver.Name == driver {
-				mergedDir, ok := container.GraphDriver.Data["MergedDir"]
-				if ok {
-					appendDockerStoragePath(config, paths, mergedDir)
-				}
-			}
-		case Aufs:
-			if container.GraphDriver.Name == driver {
-				imagePath := fmt.Sprintf("%s/image/aufs/layerdb/mounts/%s/mount-id", l.rootDir, key)
-				if _, err = os.Stat(imagePath); os.IsExist(err) {
-					bytes, err := ioutil.ReadFile(imagePath)
-					if err != nil {
-						logp.Err("Unable to read file %s due to error %v", imagePath, err)
-						continue
-					}
-
-					if len(bytes) == 0 {
-						logp.Err("Unable to find filesystem id for container %s", key)
-						continue
-					}
-					fsId := string(bytes)
-					rootFs := fmt.Sprintf("%s/aufs/mnt/%s", l.rootDir, fsId)
-					appendDockerStoragePath(config, paths, rootFs)
-				}
-			}
-		case DeviceMapper:
-			if container.GraphDriver.Name == driver {
-				deviceName, ok := container.GraphDriver.Data["DeviceName"]
-				if ok {
-					deviceNameParts := strings.Split(deviceName, "-")
-					if len(deviceNameParts) > 0 {
-						fsId := deviceNameParts[len(deviceNameParts)-1]
-						rootFs := fmt.Sprintf("%s/devicemapper/mnt/%s/rootfs", l.rootDir, fsId)
-						appendDockerStoragePath(config, paths, rootFs)
-					}
-				}
-			}
-		default:
-			logp.Err("Unsupported driver %s", driver)
+
+		rootFs, err := l.resolve(key)
+		if err != nil {
+			appender.ReportError(LogPath)
+			logp.Err("Unable to find root filesystem of container %s due to error: %v", key, err)
+			continue
 		}
+		appendDockerStoragePath(config, paths, rootFs)
 	}
 
 	configHolder.Config = config
 }
-func appendDockerStoragePath(rawConfig common.MapStr, paths []string, mergedDir string) {
-	rawPaths, _ := rawConfig["paths"].([]interface{})
-	pathConf := []string{}
 
-	for _, rawPath := range rawPaths {
-		pathConf = append(pathConf, fmt.Sprint(rawPath))
+// resolve returns the root filesystem of a container using the configured strategy
+func (l *LogPathAppender) resolve(id string) (string, error) {
+	switch l.strategy {
+	case StrategyProc:
+		return l.proc.rootFs(id)
+
+	case StrategyAuto:
+		rootFs, err := l.graphDriverRootFs(id)
+		if err == nil {
+			return rootFs, nil
+		}
+		debug("Falling back to %s for container %s: %v", l.proc.procDir, id, err)
+		return l.proc.rootFs(id)
+
+	default:
+		return l.graphDriverRootFs(id)
+	}
+}
+
+// graphDriverRootFs inspects a docker container and returns where its storage driver mounts it
+func (l *LogPathAppender) graphDriverRootFs(id string) (string, error) {
+	if l.dockerClient == nil {
+		return "", fmt.Errorf("docker is not available")
+	}
+
+	container, err := l.dockerClient.InspectContainer(id)
+	if err != nil {
+		return "", fmt.Errorf("unable to get container info: %v", err)
+	}
+	return l.rootFs(container)
+}
+
+// rootFs returns the directory the root filesystem of a container is mounted at
+func (l *LogPathAppender) rootFs(container *docker.Container) (string, error) {
+	driver := container.Driver
+	if container.GraphDriver == nil || container.GraphDriver.Name != driver {
+		return "", fmt.Errorf("graph driver does not match storage driver %s", driver)
+	}
+	data := container.GraphDriver.Data
+
+	switch driver {
+	case Overlay, Overlay2:
+		mergedDir, ok := data["MergedDir"]
+		if !ok {
+			return "", fmt.Errorf("%s driver data has no MergedDir", driver)
+		}
+		return l.hostPath(mergedDir), nil
+
+	case Aufs:
+		mountIdPath := filepath.Join(l.rootDir, "image", "aufs", "layerdb", "mounts", container.ID, "mount-id")
+		bytes, err := ioutil.ReadFile(mountIdPath)
+		if err != nil {
+			return "", err
+		}
+
+		fsId := strings.TrimSpace(string(bytes))
+		if fsId == "" {
+			return "", fmt.Errorf("%s is empty", mountIdPath)
+		}
+		return filepath.Join(l.rootDir, "aufs", "mnt", fsId), nil
+
+	case DeviceMapper:
+		deviceNameParts := strings.Split(data["DeviceName"], "-")
+		fsId := deviceNameParts[len(deviceNameParts)-1]
+		if fsId == "" {
+			return "", fmt.Errorf("%s driver data has no DeviceName", driver)
+		}
+		return filepath.Join(l.rootDir, "devicemapper", "mnt", fsId, "rootfs"), nil
+
+	default:
+		return "", fmt.Errorf("unsupported driver %s", driver)
 	}
-	if reflect.DeepEqual(pathConf, paths) == true {
-		for j := 0; j < len(pathConf); j++ {
-			pathConf[j] = fmt.Sprintf("%s%s", mergedDir, pathConf[j])
+}
+
+// hostPath maps a path reported by the docker daemon below its root directory to root_dir
+func (l *LogPathAppender) hostPath(path string) string {
+	if l.dockerRootDir == "" || l.dockerRootDir == l.rootDir {
+		return path
+	}
+
+	rel, err := filepath.Rel(l.dockerRootDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.Join(l.rootDir, rel)
+}
+
+// appendDockerStoragePath prefixes the custom log paths of a config with the root filesystem
+// of their container
+func appendDockerStoragePath(rawConfig common.MapStr, paths []string, rootFs string) {
+	custom := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		custom[path] = true
+	}
+
+	pathConf := []string{}
+	switch rawPaths := rawConfig["paths"].(type) {
+	case []string:
+		pathConf = append(pathConf, rawPaths...)
+	case []interface{}:
+		for _, rawPath := range rawPaths {
+			pathConf = append(pathConf, fmt.Sprint(rawPath))
+		}
+	}
+
+	for i, path := range pathConf {
+		if custom[path] {
+			pathConf[i] = rootFs + path
 		}
-		rawConfig["paths"] = pathConf
 	}
+	rawConfig["paths"] = pathConf
 }
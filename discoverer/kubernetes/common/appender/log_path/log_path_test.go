@@ -0,0 +1,169 @@
+package log_path
+
+import (
+	"net/http"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/docker/dockertest"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestLogPathAppender(t *testing.T) {
+	server, root := newTestDocker(t)
+	defer server.Close()
+	defer root.Close()
+
+	overlay, err := root.Overlay("overlay", Overlay)
+	assert.Nil(t, err)
+	overlay2, err := root.Overlay("overlay2", Overlay2)
+	assert.Nil(t, err)
+	aufs, err := root.Aufs("aufs", "aufs-mount")
+	assert.Nil(t, err)
+	devicemapper, err := root.DeviceMapper("devicemapper", "0f1e2d3c")
+	assert.Nil(t, err)
+
+	for _, container := range []*docker.Container{overlay, overlay2, aufs, devicemapper} {
+		server.AddContainer(container)
+	}
+
+	a := newTestAppender(t, server, root.Path)
+
+	tests := []struct {
+		container string
+		rootFs    string
+	}{
+		{container: "overlay", rootFs: root.Join("overlay", "overlay", "merged")},
+		{container: "overlay2", rootFs: root.Join("overlay2", "overlay2", "merged")},
+		{container: "aufs", rootFs: root.Join("aufs", "mnt", "aufs-mount")},
+		{container: "devicemapper", rootFs: root.Join("devicemapper", "mnt", "0f1e2d3c", "rootfs")},
+	}
+
+	for _, test := range tests {
+		holder := newHolder(test.container, []string{"/var/log/app.log", "/var/log/access.log"})
+		a.Append(holder)
+		assert.Equal(t, []string{test.rootFs + "/var/log/app.log", test.rootFs + "/var/log/access.log"},
+			holder.Config["paths"], test.container)
+	}
+}
+
+func TestLogPathAppenderDockerRootDir(t *testing.T) {
+	server, root := newTestDocker(t)
+	defer server.Close()
+	defer root.Close()
+
+	container, err := root.Overlay("web", Overlay2)
+	assert.Nil(t, err)
+	server.AddContainer(container)
+
+	// Paths are reported as seen by the daemon when root_dir is the daemon's root directory
+	a := newTestAppender(t, server, dockertest.DefaultRootDir)
+	holder := newHolder("web", []string{"/app.log"})
+	a.Append(holder)
+	assert.Equal(t, []string{dockertest.DefaultRootDir + "/overlay2/web/merged/app.log"}, holder.Config["paths"])
+}
+
+func TestLogPathAppenderErrors(t *testing.T) {
+	server, root := newTestDocker(t)
+	defer server.Close()
+	defer root.Close()
+
+	aufs, err := root.Aufs("aufs", "")
+	assert.Nil(t, err)
+	noMount := &docker.Container{ID: "no-mount", Driver: Aufs, GraphDriver: &docker.GraphDriver{Name: Aufs}}
+	mismatch := &docker.Container{ID: "mismatch", Driver: Overlay2, GraphDriver: &docker.GraphDriver{Name: Overlay}}
+	noGraphDriver := &docker.Container{ID: "no-graph-driver", Driver: Overlay2}
+	vfs := &docker.Container{ID: "vfs", Driver: "vfs", GraphDriver: &docker.GraphDriver{Name: "vfs"}}
+
+	for _, container := range []*docker.Container{aufs, noMount, mismatch, noGraphDriver, vfs} {
+		server.AddContainer(container)
+	}
+
+	a := newTestAppender(t, server, root.Path)
+
+	// Paths of containers whose root filesystem is not found are left alone
+	for _, id := range []string{"aufs", "no-mount", "mismatch", "no-graph-driver", "vfs", "missing"} {
+		holder := newHolder(id, []string{"/app.log"})
+		a.Append(holder)
+		assert.Equal(t, []string{"/app.log"}, holder.Config["paths"], id)
+	}
+
+	// Holders without custom paths are not looked up
+	requests := server.Requests("/containers/web/json")
+	a.Append(&dcommon.ConfigHolder{
+		Config: common.MapStr{"paths": []string{"/var/lib/docker/containers/web/*.log"}},
+		Meta:   dcommon.Meta{dcommon.MetaPodName: "web"},
+	})
+	assert.Equal(t, requests, server.Requests("/containers/web/json"))
+}
+
+func TestNewLogPathAppender(t *testing.T) {
+	server, err := dockertest.NewServer()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer server.Close()
+
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"host": server.Endpoint()})
+	assert.Nil(t, err)
+
+	a, err := NewLogPathAppender(cfg)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "/var/lib/docker", a.(*LogPathAppender).rootDir)
+	}
+
+	// The daemon must be reachable
+	server.Fail(http.StatusInternalServerError)
+	_, err = NewLogPathAppender(cfg)
+	assert.NotNil(t, err)
+}
+
+func TestAppendDockerStoragePath(t *testing.T) {
+	config := common.MapStr{"paths": []interface{}{"/app.log", "/var/lib/docker/containers/web/*.log"}}
+	appendDockerStoragePath(config, []string{"/app.log"}, "/rootfs")
+	assert.Equal(t, []string{"/rootfs/app.log", "/var/lib/docker/containers/web/*.log"}, config["paths"])
+}
+
+func newTestDocker(t *testing.T) (*dockertest.Server, *dockertest.RootDir) {
+	server, err := dockertest.NewServer()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	root, err := dockertest.NewRootDir()
+	if !assert.Nil(t, err) {
+		server.Close()
+		t.FailNow()
+	}
+	return server, root
+}
+
+func newTestAppender(t *testing.T, server *dockertest.Server, rootDir string) *LogPathAppender {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"host":     server.Endpoint(),
+		"root_dir": rootDir,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	a, err := NewLogPathAppender(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return a.(*LogPathAppender)
+}
+
+// newHolder returns a config with custom log paths of a container like log_annotations does
+func newHolder(container string, paths []string) *dcommon.ConfigHolder {
+	return &dcommon.ConfigHolder{
+		Config: common.MapStr{"paths": paths},
+		Meta: dcommon.Meta{
+			dcommon.MetaPodName: "web",
+			container:           paths,
+		},
+	}
+}
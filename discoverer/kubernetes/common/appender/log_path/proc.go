@@ -0,0 +1,160 @@
+package log_path
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// procResolver finds the root filesystem of a container below /proc/<pid>/root of its main
+// process, which works with every container runtime
+type procResolver struct {
+	procDir string
+	// dockerClient is used to look up the PID of docker containers when it is set
+	dockerClient *docker.Client
+
+	sync.Mutex
+	// pids caches the PID of containers until their process is gone
+	pids map[string]int
+}
+
+// rootFs returns the root filesystem of a container as seen through /proc
+func (p *procResolver) rootFs(id string) (string, error) {
+	pid, err := p.pid(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.procDir, strconv.Itoa(pid), "root"), nil
+}
+
+// pid returns the PID of the main process of a container. A cached PID is used while its
+// process still belongs to the container.
+func (p *procResolver) pid(id string) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if pid, ok := p.pids[id]; ok {
+		if inContainer(p.procDir, pid, id) {
+			return pid, nil
+		}
+		delete(p.pids, id)
+	}
+
+	pid, err := p.lookup(id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Containers that are gone are forgotten whenever a PID is looked up
+	for cached, cachedPid := range p.pids {
+		if !inContainer(p.procDir, cachedPid, cached) {
+			delete(p.pids, cached)
+		}
+	}
+	if p.pids == nil {
+		p.pids = map[string]int{}
+	}
+	p.pids[id] = pid
+	return pid, nil
+}
+
+// lookup finds the PID of the main process of a container. The container runtime is asked
+// first, the cgroups of all processes are searched for the container ID otherwise.
+func (p *procResolver) lookup(id string) (int, error) {
+	if p.dockerClient != nil {
+		container, err := p.dockerClient.InspectContainer(id)
+		if err == nil && container.State.Pid > 0 {
+			return container.State.Pid, nil
+		}
+		debug("Unable to get PID of container %s from docker, searching cgroups: %v", id, err)
+	}
+	return cgroupPid(p.procDir, id)
+}
+
+// cgroupPid returns the PID of the process of a container whose parent is not part of the
+// container. Containers are matched by their ID in /proc/<pid>/cgroup.
+func cgroupPid(procDir, id string) (int, error) {
+	if id == "" {
+		return 0, fmt.Errorf("container ID is empty")
+	}
+
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+
+	pids := map[int]bool{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		cgroup, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "cgroup"))
+		if err != nil {
+			// Processes may exit while scanning
+			continue
+		}
+		if strings.Contains(string(cgroup), id) {
+			pids[pid] = true
+		}
+	}
+
+	if len(pids) == 0 {
+		return 0, fmt.Errorf("no process of container %s found in %s", id, procDir)
+	}
+
+	main := 0
+	for pid := range pids {
+		if pids[parentPid(procDir, pid)] {
+			continue
+		}
+		if main == 0 || pid < main {
+			main = pid
+		}
+	}
+
+	// All processes have a parent in the container if PIDs were reused, pick the oldest
+	if main == 0 {
+		for pid := range pids {
+			if main == 0 || pid < main {
+				main = pid
+			}
+		}
+	}
+	return main, nil
+}
+
+// inContainer returns whether the process with pid exists and belongs to the container id
+func inContainer(procDir string, pid int, id string) bool {
+	cgroup, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cgroup"))
+	return err == nil && strings.Contains(string(cgroup), id)
+}
+
+// parentPid reads the parent PID from /proc/<pid>/stat. It returns 0 if it is unknown.
+func parentPid(procDir string, pid int) int {
+	stat, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+
+	// The command name may contain spaces and parentheses, fields after it are fixed
+	s := string(stat)
+	end := strings.LastIndex(s, ")")
+	if end < 0 {
+		return 0
+	}
+
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
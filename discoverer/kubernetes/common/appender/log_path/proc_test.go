@@ -0,0 +1,188 @@
+package log_path
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ebay/collectbeat/discoverer/docker/dockertest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	containerdID = "3f4e5d6c7b8a3f4e5d6c7b8a3f4e5d6c7b8a3f4e5d6c7b8a3f4e5d6c7b8a3f4e"
+	otherID      = "9a8b7c6d5e4f9a8b7c6d5e4f9a8b7c6d5e4f9a8b7c6d5e4f9a8b7c6d5e4f9a8b"
+)
+
+func TestCgroupPid(t *testing.T) {
+	procDir := newProcDir(t)
+	defer os.RemoveAll(procDir)
+
+	// The shim of the container is outside of it, its children are inside
+	addProcess(t, procDir, 90, 1, "containerd-shim", "")
+	addProcess(t, procDir, 100, 90, "nginx: master (pid 1)", containerdID)
+	addProcess(t, procDir, 101, 100, "nginx: worker", containerdID)
+	addProcess(t, procDir, 102, 100, "nginx: worker", containerdID)
+	addProcess(t, procDir, 50, 1, "sidecar", otherID)
+
+	pid, err := cgroupPid(procDir, containerdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, pid)
+
+	pid, err = cgroupPid(procDir, otherID)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, pid)
+
+	_, err = cgroupPid(procDir, "unknown")
+	assert.NotNil(t, err)
+	_, err = cgroupPid(procDir, "")
+	assert.NotNil(t, err)
+}
+
+func TestCgroupPidReusedPids(t *testing.T) {
+	procDir := newProcDir(t)
+	defer os.RemoveAll(procDir)
+
+	addProcess(t, procDir, 301, 300, "app", containerdID)
+	addProcess(t, procDir, 300, 301, "app", containerdID)
+
+	pid, err := cgroupPid(procDir, containerdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 300, pid)
+}
+
+func TestProcResolverCache(t *testing.T) {
+	procDir := newProcDir(t)
+	defer os.RemoveAll(procDir)
+
+	addProcess(t, procDir, 100, 1, "app", containerdID)
+	addProcess(t, procDir, 50, 1, "sidecar", otherID)
+	p := &procResolver{procDir: procDir}
+
+	pid, err := p.pid(containerdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, pid)
+
+	// A cached PID is used without scanning all processes
+	addProcess(t, procDir, 90, 1, "app", containerdID)
+	pid, err = p.pid(containerdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, pid)
+
+	// Entries are dropped once their process is gone
+	_, err = p.pid(otherID)
+	assert.Nil(t, err)
+	assert.Nil(t, os.RemoveAll(filepath.Join(procDir, "100")))
+	assert.Nil(t, os.RemoveAll(filepath.Join(procDir, "50")))
+	pid, err = p.pid(containerdID)
+	assert.Nil(t, err)
+	assert.Equal(t, 90, pid)
+	assert.Equal(t, map[string]int{containerdID: 90}, p.pids)
+}
+
+func TestLogPathAppenderProcStrategy(t *testing.T) {
+	procDir := newProcDir(t)
+	defer os.RemoveAll(procDir)
+	addProcess(t, procDir, 100, 1, "app", containerdID)
+
+	// Docker is not needed to look at /proc
+	a, err := NewLogPathAppender(procConfig(t, "unix:///nonexistent/docker.sock", StrategyProc, procDir))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	holder := newHolder(containerdID, []string{"/var/log/app.log"})
+	a.Append(holder)
+	assert.Equal(t, []string{filepath.Join(procDir, "100", "root") + "/var/log/app.log"}, holder.Config["paths"])
+
+	_, err = NewLogPathAppender(procConfig(t, "unix:///nonexistent/docker.sock", StrategyGraphDriver, procDir))
+	assert.NotNil(t, err)
+
+	_, err = NewLogPathAppender(procConfig(t, "unix:///nonexistent/docker.sock", "btrfs", procDir))
+	assert.NotNil(t, err)
+}
+
+func TestLogPathAppenderProcStrategyDocker(t *testing.T) {
+	server, root := newTestDocker(t)
+	defer server.Close()
+	defer root.Close()
+
+	container, err := root.Overlay("web", Overlay2)
+	assert.Nil(t, err)
+	server.AddContainer(container)
+
+	procDir := newProcDir(t)
+	defer os.RemoveAll(procDir)
+	addProcess(t, procDir, 100, 1, "app", containerdID)
+
+	// The PID of docker containers comes from the daemon
+	a, err := NewLogPathAppender(procConfig(t, server.Endpoint(), StrategyProc, procDir))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	holder := newHolder("web", []string{"/app.log"})
+	a.Append(holder)
+	assert.Equal(t, []string{filepath.Join(procDir, "4242", "root") + "/app.log"}, holder.Config["paths"])
+
+	// Auto uses the storage driver and falls back to /proc for other runtimes
+	a, err = NewLogPathAppender(procConfig(t, server.Endpoint(), StrategyAuto, procDir))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	holder = newHolder("web", []string{"/app.log"})
+	a.Append(holder)
+	assert.Equal(t, []string{dockerRootPath("overlay2", "web", "merged") + "/app.log"}, holder.Config["paths"])
+
+	holder = newHolder(containerdID, []string{"/app.log"})
+	a.Append(holder)
+	assert.Equal(t, []string{filepath.Join(procDir, "100", "root") + "/app.log"}, holder.Config["paths"])
+}
+
+func procConfig(t *testing.T, host, strategy, procDir string) *common.Config {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"host":     host,
+		"strategy": strategy,
+		"proc_dir": procDir,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return cfg
+}
+
+func newProcDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "proc")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return dir
+}
+
+// addProcess writes the cgroup and stat files of a process. Processes with an empty
+// container ID are in the root cgroup.
+func addProcess(t *testing.T, procDir string, pid, ppid int, command, container string) {
+	dir := filepath.Join(procDir, fmt.Sprint(pid))
+	if !assert.Nil(t, os.MkdirAll(dir, 0755)) {
+		t.FailNow()
+	}
+
+	cgroup := "0::/\n"
+	if container != "" {
+		cgroup = fmt.Sprintf("12:memory:/kubepods/besteffort/pod1234/%s\n0::/kubepods/besteffort/pod1234/%s\n", container, container)
+	}
+	stat := fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560", pid, command, ppid, pid, pid)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644))
+}
+
+// dockerRootPath returns a path below the docker root directory of the fake daemon
+func dockerRootPath(elem ...string) string {
+	return filepath.Join(append([]string{dockertest.DefaultRootDir}, elem...)...)
+}
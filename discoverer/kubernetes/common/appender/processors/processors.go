@@ -0,0 +1,219 @@
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/processors"
+
+	_ "github.com/ebay/collectbeat/processors/rename"
+	_ "github.com/elastic/beats/libbeat/processors/actions"
+)
+
+const (
+	Processors = "processors"
+)
+
+var debug = logp.MakeDebug(Processors)
+
+func init() {
+	registry.BuilderRegistry.AddAppender(Processors, NewProcessorsAppender)
+	registry.BuilderRegistry.AddAppenderSchema(Processors, schema.Schema{
+		{Name: "rules", Type: schema.List, Description: "Processors added to the configs matching modules, builders, kinds, namespaces and labels"},
+		{Name: "tenant.enabled", Type: schema.Bool, Default: true, Description: "Read processors from the <prefix>/processors annotations of pods"},
+		{Name: "tenant.allowed", Type: schema.List, Default: defaultAllowed, Description: "Processors pods can add with annotations"},
+	})
+}
+
+var defaultAllowed = []string{"drop_fields", "include_fields", "drop_event", "rename"}
+
+// rule adds processors to the configs it matches
+type rule struct {
+	appender.Rule `config:",inline"`
+	Processors    []common.MapStr `config:"processors" validate:"required"`
+}
+
+type tenant struct {
+	Enabled bool     `config:"enabled"`
+	Allowed []string `config:"allowed"`
+}
+
+// ProcessorsAppender adds processors to configs. Processors of pod annotations come first, the
+// processors of operator rules last so that they see the events as they are shipped.
+type ProcessorsAppender struct {
+	rules  []rule
+	tenant tenant
+}
+
+func NewProcessorsAppender(cfg *common.Config) (appender.Appender, error) {
+	config := struct {
+		Rules  []rule `config:"rules"`
+		Tenant tenant `config:"tenant"`
+	}{
+		Tenant: tenant{
+			Enabled: true,
+			Allowed: defaultAllowed,
+		},
+	}
+
+	err := cfg.Unpack(&config)
+	if err != nil {
+		return nil, fmt.Errorf("fail to unpack the `processors` appender configuration: %s", err)
+	}
+
+	for i, r := range config.Rules {
+		if err := checkProcessors(r.Processors, nil); err != nil {
+			return nil, fmt.Errorf("rule %d is invalid: %v", i, err)
+		}
+	}
+
+	return &ProcessorsAppender{
+		rules:  config.Rules,
+		tenant: config.Tenant,
+	}, nil
+}
+
+func (p *ProcessorsAppender) Append(configHolder *dcommon.ConfigHolder) {
+	config := configHolder.Config
+	if config == nil {
+		return
+	}
+
+	added := []common.MapStr{}
+	if p.tenant.Enabled {
+		added = append(added, p.tenantProcessors(configHolder.Meta)...)
+	}
+	for _, r := range p.rules {
+		if r.Matches(configHolder) {
+			added = append(added, r.Processors...)
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	for _, target := range appender.Targets(configHolder) {
+		target[Processors] = appender.AppendProcessors(target[Processors], added...)
+	}
+}
+
+// tenantProcessors returns the processors of the pod and then of the container a config was
+// generated for. Annotations with processors that are not allowed are skipped.
+func (p *ProcessorsAppender) tenantProcessors(meta dcommon.Meta) []common.MapStr {
+	prefix := meta.GetString(dcommon.MetaPrefix)
+	annotations, _ := meta[dcommon.MetaAnnotations].(map[string]string)
+	if prefix == "" || len(annotations) == 0 {
+		return nil
+	}
+
+	keys := []string{prefix + "/" + Processors}
+	if container := meta.GetString(dcommon.MetaContainer); container != "" {
+		keys = append(keys, prefix+"."+container+"/"+Processors)
+	}
+
+	out := []common.MapStr{}
+	for _, key := range keys {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		list, err := ParseProcessors(value, p.tenant.Allowed)
+		if err != nil {
+			appender.ReportError(Processors)
+			logp.Err("Unable to use %s of pod %s due to error: %v", key, meta.GetString(dcommon.MetaPodName), err)
+			continue
+		}
+		debug("Adding %d processors of %s of pod %s", len(list), key, meta.GetString(dcommon.MetaPodName))
+		out = append(out, list...)
+	}
+	return out
+}
+
+// ParseProcessors reads a JSON list of processors and checks that they only use allowed ones
+// and leave the fields set by the builders in place
+func ParseProcessors(value string, allowed []string) ([]common.MapStr, error) {
+	list := []common.MapStr{}
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		return nil, fmt.Errorf("'%s' is not a JSON list of processors", value)
+	}
+
+	for _, p := range list {
+		if err := protectReserved(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkProcessors(list, allowed); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// checkProcessors creates processors to check their configs. Only allowed processors are
+// accepted unless allowed is nil.
+func checkProcessors(list []common.MapStr, allowed []string) error {
+	for _, p := range list {
+		for name := range p {
+			if allowed != nil && !appender.Contains(allowed, name) {
+				return fmt.Errorf("processor %s is not allowed", name)
+			}
+		}
+	}
+
+	cfg, err := common.NewConfigFrom(map[string]interface{}{Processors: list})
+	if err != nil {
+		return err
+	}
+	config := struct {
+		Processors processors.PluginConfig `config:"processors"`
+	}{}
+	if err = cfg.Unpack(&config); err != nil {
+		return err
+	}
+
+	_, err = processors.New(config.Processors)
+	return err
+}
+
+// protectReserved rejects drop_fields and rename processors using the reserved fields and
+// makes include_fields keep them
+func protectReserved(p common.MapStr) error {
+	for name, value := range p {
+		config, _ := value.(map[string]interface{})
+		fields, _ := config["fields"].([]interface{})
+		switch name {
+		case "drop_fields":
+			for _, field := range fields {
+				if s, _ := field.(string); appender.IsReserved(s) {
+					return fmt.Errorf("field %s can not be dropped", s)
+				}
+			}
+		case "rename":
+			for _, field := range fields {
+				names, _ := field.(map[string]interface{})
+				for _, key := range []string{"from", "to"} {
+					if s, _ := names[key].(string); appender.IsReserved(s) {
+						return fmt.Errorf("field %s can not be renamed", s)
+					}
+				}
+			}
+		case "include_fields":
+			if fields == nil {
+				continue
+			}
+			for _, field := range appender.ReservedFields {
+				fields = append(fields, field)
+			}
+			config["fields"] = fields
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package processors
+
+import (
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestProcessorsAppender(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{
+				"modules":    []string{"prometheus"},
+				"namespaces": []string{"payments"},
+				"processors": []map[string]interface{}{{"drop_fields": map[string]interface{}{"fields": []string{"instance"}}}},
+			},
+			{
+				"labels":     map[string]string{"tier": "frontend"},
+				"processors": []map[string]interface{}{{"include_fields": map[string]interface{}{"fields": []string{"message"}}}},
+			},
+			{
+				"builders":   []string{"metrics_secret"},
+				"processors": []map[string]interface{}{{"drop_fields": map[string]interface{}{"fields": []string{"beat"}}}},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	a, err := NewProcessorsAppender(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	holder := &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "prometheus"},
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: "payments",
+			dcommon.MetaLabels:    map[string]string{"tier": "frontend"},
+			dcommon.MetaPrefix:    "io.collectbeat.metrics",
+			dcommon.MetaAnnotations: map[string]string{
+				"io.collectbeat.metrics/processors": `[{"drop_fields": {"fields": ["beat"]}}]`,
+			},
+		},
+	}
+	a.Append(holder)
+	assert.Equal(t, []string{"drop_fields", "drop_fields", "include_fields"}, names(holder.Config["processors"]))
+
+	// Builders are matched by the builder that generated the config
+	holder = &dcommon.ConfigHolder{Config: common.MapStr{"module": "redis"}, Builder: "metrics_secret"}
+	a.Append(holder)
+	assert.Equal(t, []string{"drop_fields"}, names(holder.Config["processors"]))
+
+	// Prospectors are matched by type, container annotations come after the pod's
+	holder = &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"type":       "log",
+			"processors": []interface{}{map[string]interface{}{"rate_limit": map[string]interface{}{"events_per_second": 10}}},
+		},
+		Meta: dcommon.Meta{
+			dcommon.MetaNamespace: "payments",
+			dcommon.MetaContainer: "nginx",
+			dcommon.MetaPrefix:    "io.collectbeat.logs",
+			dcommon.MetaAnnotations: map[string]string{
+				"io.collectbeat.logs/processors":       `[{"drop_event": {"when": {"contains": {"message": "healthz"}}}}]`,
+				"io.collectbeat.logs.nginx/processors": `[{"rename": {"fields": [{"from": "a", "to": "b"}]}}]`,
+				"io.collectbeat.logs.app/processors":   `[{"drop_fields": {"fields": ["beat"]}}]`,
+			},
+		},
+	}
+	a.Append(holder)
+	assert.Equal(t, []string{"rate_limit", "drop_event", "rename"}, names(holder.Config["processors"]))
+
+	// Filebeat modules take the processors in their filesets
+	holder = &dcommon.ConfigHolder{
+		Config: common.MapStr{
+			"module": "nginx",
+			"access": common.MapStr{"enabled": true, "prospector": common.MapStr{}},
+			"error":  common.MapStr{"enabled": false},
+		},
+		Meta: dcommon.Meta{
+			dcommon.MetaRoute:  dcommon.RouteModule,
+			dcommon.MetaLabels: map[string]string{"tier": "frontend"},
+		},
+	}
+	a.Append(holder)
+	assert.Nil(t, holder.Config["processors"])
+	assert.Nil(t, holder.Config["error"].(common.MapStr)["processors"])
+	prospector := holder.Config["access"].(common.MapStr)["prospector"].(common.MapStr)
+	assert.Equal(t, []string{"include_fields"}, names(prospector["processors"]))
+
+	// Nothing matches
+	holder = &dcommon.ConfigHolder{Config: common.MapStr{"module": "mongodb"}}
+	a.Append(holder)
+	assert.Nil(t, holder.Config["processors"])
+}
+
+func TestTenantProcessors(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"tenant": map[string]interface{}{"allowed": []string{"drop_fields"}},
+	})
+	assert.Nil(t, err)
+	a, err := NewProcessorsAppender(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	for _, value := range []string{
+		`[{"drop_event": {}}]`,
+		`[{"drop_fields": {}}]`,
+		`[{"drop_fields": {"fields": ["a"]}, "rename": {}}]`,
+		`{"drop_fields": {"fields": ["a"]}}`,
+		`drop_fields`,
+	} {
+		holder := &dcommon.ConfigHolder{
+			Config: common.MapStr{"module": "prometheus"},
+			Meta: dcommon.Meta{
+				dcommon.MetaPrefix:      "foo",
+				dcommon.MetaAnnotations: map[string]string{"foo/processors": value},
+			},
+		}
+		a.Append(holder)
+		assert.Nil(t, holder.Config["processors"], value)
+	}
+
+	config, err = common.NewConfigFrom(map[string]interface{}{
+		"tenant": map[string]interface{}{"enabled": false},
+	})
+	assert.Nil(t, err)
+	a, err = NewProcessorsAppender(config)
+	assert.Nil(t, err)
+	holder := &dcommon.ConfigHolder{
+		Config: common.MapStr{"module": "prometheus"},
+		Meta: dcommon.Meta{
+			dcommon.MetaPrefix:      "foo",
+			dcommon.MetaAnnotations: map[string]string{"foo/processors": `[{"drop_fields": {"fields": ["a"]}}]`},
+		},
+	}
+	a.Append(holder)
+	assert.Nil(t, holder.Config["processors"])
+
+	// Rules can use any processor but need valid ones
+	for _, rules := range [][]map[string]interface{}{
+		{{"processors": []map[string]interface{}{{"unknown": map[string]interface{}{}}}}},
+		{{"modules": []string{"prometheus"}}},
+	} {
+		config, err = common.NewConfigFrom(map[string]interface{}{"rules": rules})
+		assert.Nil(t, err)
+		_, err = NewProcessorsAppender(config)
+		assert.NotNil(t, err, "%v", rules)
+	}
+}
+
+func TestTenantProcessorsReservedFields(t *testing.T) {
+	for _, value := range []string{
+		`[{"drop_fields": {"fields": ["a", "kubernetes.pod.name"]}}]`,
+		`[{"drop_fields": {"fields": ["namespace"]}}]`,
+		`[{"rename": {"fields": [{"from": "kubernetes", "to": "k8s"}]}}]`,
+		`[{"rename": {"fields": [{"from": "message", "to": "namespace"}]}}]`,
+	} {
+		_, err := ParseProcessors(value, defaultAllowed)
+		assert.NotNil(t, err, value)
+	}
+
+	list, err := ParseProcessors(`[{"include_fields": {"fields": ["message"]}}]`, defaultAllowed)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	fields, err := list[0].GetValue("include_fields.fields")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"message", "namespace", "kubernetes"}, fields)
+
+	_, err = ParseProcessors(`[{"drop_fields": {"fields": ["kubernetes_version"]}}]`, defaultAllowed)
+	assert.Nil(t, err)
+}
+
+// names returns the names of a list of processors
+func names(list interface{}) []string {
+	out := []string{}
+	for _, p := range list.([]common.MapStr) {
+		for name := range p {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/metagen"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -40,6 +41,10 @@ var debug = logp.MakeDebug(GraphiteBuilder)
 
 func init() {
 	registry.BuilderRegistry.AddBuilder(GraphiteBuilder, NewGraphiteAnnotationBuilder)
+	registry.BuilderRegistry.AddBuilderSchema(GraphiteBuilder, schema.Schema{
+		{Name: "prefix", Type: schema.String, Default: graphite_default_prefix, Description: "Prefix of the annotations read from pods"},
+		{Name: "config", Type: schema.Object, Description: "Settings of the graphite server module"},
+	})
 }
 
 type podMap struct {
@@ -116,6 +121,10 @@ func (p *GraphiteAnnotationBuilder) Name() string {
 	return "Graphite Annotation Builder"
 }
 
+func (p *GraphiteAnnotationBuilder) Kind() string {
+	return dcommon.KindMetrics
+}
+
 func (g *GraphiteAnnotationBuilder) AddModuleConfig(obj interface{}) *dcommon.ConfigHolder {
 	holder := g.ModuleConfig()
 	pod, ok := obj.(*kubernetes.Pod)
@@ -271,6 +280,9 @@ func (g *GraphiteAnnotationBuilder) ModuleConfig() *dcommon.ConfigHolder {
 
 	return &dcommon.ConfigHolder{
 		Config: dcommon.GetMapFromConfig(cfg),
+		Meta: dcommon.Meta{
+			dcommon.MetaBuilder: GraphiteBuilder,
+		},
 	}
 }
 
@@ -314,6 +326,52 @@ func (g *GraphiteAnnotationBuilder) getTemplateFromPod(pod *kubernetes.Pod) *ser
 
 }
 
+// Validate checks the graphite annotations of a pod
+func (g *GraphiteAnnotationBuilder) Validate(obj interface{}) []*builder.ValidationError {
+	pod, ok := obj.(*kubernetes.Pod)
+	if !ok || kubecommon.IsNoOp(g.Prefix, pod) {
+		return nil
+	}
+
+	errs := []*builder.ValidationError{}
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, kubecommon.NewValidationError(GraphiteBuilder, g.Prefix+key, pod, format, args...))
+	}
+
+	required := []string{filter, template, namespace}
+	set := 0
+	for _, key := range required {
+		if kubecommon.GetAnnotationWithPrefix(key, g.Prefix, pod) != "" {
+			set++
+		}
+	}
+
+	if set != 0 && set != len(required) {
+		for _, key := range required {
+			if kubecommon.GetAnnotationWithPrefix(key, g.Prefix, pod) == "" {
+				invalid(key, "is required when any of %s%s, %s%s or %s%s is set",
+					g.Prefix, filter, g.Prefix, template, g.Prefix, namespace)
+			}
+		}
+	}
+
+	if f := g.getFilter(pod); f != "" {
+		if _, ok := g.BaseTemplates[f]; ok {
+			invalid(filter, "'%s' is reserved by the collectbeat configuration", f)
+		}
+	}
+
+	if tagStr := kubecommon.GetAnnotationWithPrefix(tags, g.Prefix, pod); tagStr != "" {
+		for _, value := range strings.Split(tagStr, ",") {
+			if keyvalue := strings.Split(value, "="); len(keyvalue) != 2 || keyvalue[0] == "" {
+				invalid(tags, "'%s' is not a key=value pair", value)
+			}
+		}
+	}
+
+	return errs
+}
+
 func (p *GraphiteAnnotationBuilder) getNamespace(pod *kubernetes.Pod) string {
 	return kubecommon.GetAnnotationWithPrefix(namespace, p.Prefix, pod)
 }
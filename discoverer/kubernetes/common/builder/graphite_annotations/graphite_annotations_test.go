@@ -211,3 +211,55 @@ func getBaseConfig(t *testing.T) (*common.Config, error) {
 	}
 	return config, err
 }
+
+func TestGraphiteAnnotationsValidate(t *testing.T) {
+	config, err := getBaseConfig(t)
+	assert.Nil(t, err)
+
+	bRaw, err := NewGraphiteAnnotationBuilder(config, nil, nil)
+	assert.Nil(t, err)
+
+	v, ok := bRaw.(builder.Validator)
+	assert.Equal(t, ok, true)
+
+	tests := []struct {
+		annotations map[string]string
+		keys        []string
+	}{
+		{
+			annotations: map[string]string{
+				"foo/filter":    "app.*",
+				"foo/template":  ".host.shell.measurement*",
+				"foo/namespace": "app",
+				"foo/tags":      "env=prod,team=search",
+			},
+		},
+		{
+			annotations: map[string]string{
+				"foo/filter": "app.*",
+			},
+			keys: []string{"foo/template", "foo/namespace"},
+		},
+		{
+			annotations: map[string]string{
+				"foo/filter":    "app.*",
+				"foo/template":  ".host.shell.measurement*",
+				"foo/namespace": "app",
+				"foo/tags":      "env",
+			},
+			keys: []string{"foo/tags"},
+		},
+	}
+
+	for _, test := range tests {
+		pod := &kubernetes.Pod{}
+		pod.Metadata.Name = "bar"
+		pod.Metadata.Annotations = test.annotations
+
+		var keys []string
+		for _, err := range v.Validate(pod) {
+			keys = append(keys, err.Key)
+		}
+		assert.Equal(t, test.keys, keys, "annotations: %v", test.annotations)
+	}
+}
@@ -8,10 +8,19 @@ type LogPathConfig struct {
 	LogsPath             string        `config:"logs_path"`
 	DefaultNamespace     string        `config:"default_namespace"`
 	CustomPath           CustomPath    `config:"custom_path"`
+	// Modules adds filebeat modules or replaces the filesets of the supported ones
+	Modules map[string][]string `config:"modules"`
+	// MultilinePresets adds or replaces multiline settings pods select by name
+	MultilinePresets map[string]multiline `config:"multiline_presets"`
+	// Limits caps the logs of pods, annotations can only lower them
+	Limits Limits `config:"limits"`
 }
 
 type CustomPath struct {
-	Enabled bool `config:"enabled"`
+	Enabled    bool   `config:"enabled"`
+	KubeletDir string `config:"kubelet_dir"`
+	// HostRoot is where the root filesystem of the host is mounted, hostPath volumes are below it
+	HostRoot string `config:"host_root"`
 }
 
 func DefaultLogPathConfig() LogPathConfig {
@@ -20,7 +29,9 @@ func DefaultLogPathConfig() LogPathConfig {
 		BaseProspectorConfig: defaultBaseProspectorConfig(),
 		LogsPath:             "/var/lib/docker/containers/",
 		CustomPath: CustomPath{
-			Enabled: false,
+			Enabled:    false,
+			KubeletDir: "/var/lib/kubelet",
+			HostRoot:   "/",
 		},
 	}
 }
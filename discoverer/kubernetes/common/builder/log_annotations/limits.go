@@ -0,0 +1,209 @@
+package log_annotations
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/processors/rate_limit"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	rateLimit      = "rate_limit"
+	maxBytes       = "max_bytes"
+	harvesterLimit = "harvester_limit"
+
+	ScopeContainer = "container"
+	ScopePod       = "pod"
+	ScopeNamespace = "namespace"
+)
+
+// Limits caps the logs of pods. The limits of a namespace replace the default limits they set.
+type Limits struct {
+	Default    Limit            `config:"default"`
+	Namespaces map[string]Limit `config:"namespaces"`
+}
+
+// Limit caps the logs of the containers in its scope. Zero values do not limit.
+type Limit struct {
+	EventsPerSecond float64 `config:"events_per_second" validate:"min=0"`
+	Burst           int     `config:"burst" validate:"min=0"`
+	MaxBytes        int     `config:"max_bytes" validate:"min=0"`
+	HarvesterLimit  int     `config:"harvester_limit" validate:"min=0"`
+	// Scope is the set of containers sharing the events per second
+	Scope string `config:"scope"`
+}
+
+func (l *Limit) Validate() error {
+	switch l.Scope {
+	case "", ScopeContainer, ScopePod, ScopeNamespace:
+		return nil
+	}
+	return fmt.Errorf("scope '%s' is not valid, use %s, %s or %s", l.Scope, ScopeContainer, ScopePod, ScopeNamespace)
+}
+
+// merge returns the limit with the values set in another limit replaced
+func (l Limit) merge(other Limit) Limit {
+	if other.EventsPerSecond != 0 {
+		l.EventsPerSecond = other.EventsPerSecond
+	}
+	if other.Burst != 0 {
+		l.Burst = other.Burst
+	}
+	if other.MaxBytes != 0 {
+		l.MaxBytes = other.MaxBytes
+	}
+	if other.HarvesterLimit != 0 {
+		l.HarvesterLimit = other.HarvesterLimit
+	}
+	if other.Scope != "" {
+		l.Scope = other.Scope
+	}
+	return l
+}
+
+// podLimit returns the limit operators set for the namespace of a pod
+func (l *PodLogAnnotationBuilder) podLimit(pod *kubernetes.Pod) Limit {
+	limit := l.limits.Default
+	if ns, ok := l.limits.Namespaces[pod.Metadata.Namespace]; ok {
+		limit = limit.merge(ns)
+	}
+	if limit.Scope == "" {
+		limit.Scope = ScopePod
+	}
+	return limit
+}
+
+// applyLimits caps the lines, harvesters and events of a container. Annotations on the pod
+// and the container can lower the limits of the namespace but not raise them.
+func (l *PodLogAnnotationBuilder) applyLimits(config common.MapStr, pod *kubernetes.Pod, container, cid string) {
+	limit := l.podLimit(pod)
+
+	if value := l.lowerLimit(pod, container, maxBytes, float64(limit.MaxBytes)); value != 0 {
+		config[maxBytes] = int(value)
+	}
+	if value := l.lowerLimit(pod, container, harvesterLimit, float64(limit.HarvesterLimit)); value != 0 {
+		config[harvesterLimit] = int(value)
+	}
+
+	limiters := []common.MapStr{}
+	if limit.EventsPerSecond != 0 {
+		key := ScopePod + "/" + pod.Metadata.UID
+		switch limit.Scope {
+		case ScopeContainer:
+			key = ScopeContainer + "/" + cid
+		case ScopeNamespace:
+			key = ScopeNamespace + "/" + pod.Metadata.Namespace
+		}
+
+		limiter := common.MapStr{"key": key, "events_per_second": limit.EventsPerSecond}
+		if limit.Burst != 0 {
+			limiter["burst"] = limit.Burst
+		}
+		limiters = append(limiters, common.MapStr{rate_limit.Name: limiter})
+	}
+
+	// Lower rates of tenants apply on top of the shared rate. A rate set on the pod is shared
+	// by its containers, a rate set on a container applies to it alone.
+	if value, scope := l.lowestLimit(pod, container, rateLimit, 0); value != 0 && (limit.EventsPerSecond == 0 || value < limit.EventsPerSecond) {
+		key := "annotation/" + ScopeContainer + "/" + cid
+		if scope == ScopePod {
+			key = "annotation/" + ScopePod + "/" + pod.Metadata.UID
+		}
+		limiters = append(limiters, common.MapStr{rate_limit.Name: common.MapStr{
+			"key":               key,
+			"events_per_second": value,
+		}})
+	}
+
+	if len(limiters) != 0 {
+		config["processors"] = appender.AppendProcessors(config["processors"], limiters...)
+	}
+}
+
+// lowerLimit returns the lowest of a limit and the values of an annotation on the pod and
+// container. Values that would raise the limit are ignored.
+func (l *PodLogAnnotationBuilder) lowerLimit(pod *kubernetes.Pod, container, key string, limit float64) float64 {
+	value, _ := l.lowestLimit(pod, container, key, limit)
+	return value
+}
+
+// lowestLimit is lowerLimit that also returns the scope of the annotation the value was taken
+// from, ScopePod or ScopeContainer, or an empty scope when the limit is not lowered
+func (l *PodLogAnnotationBuilder) lowestLimit(pod *kubernetes.Pod, container, key string, limit float64) (float64, string) {
+	scope := ""
+	for _, level := range []string{"", container} {
+		raw := l.getAnnotationWithPrefixForContainer(key, level, pod)
+		if raw == "" {
+			continue
+		}
+
+		value, err := parseLimit(key, raw)
+		if err != nil {
+			logp.Err("Unable to use %s of pod %s due to error: %v", key, pod.Metadata.Name, err)
+			continue
+		}
+		if limit != 0 && value > limit {
+			debug("Ignoring %s %v of pod %s as it is over the limit of %v", key, value, pod.Metadata.Name, limit)
+			continue
+		}
+
+		limit, scope = value, ScopePod
+		if level != "" {
+			scope = ScopeContainer
+		}
+	}
+	return limit, scope
+}
+
+// validateLimits checks that the limits of a pod or container are valid and do not raise the
+// limits of the namespace
+func (l *PodLogAnnotationBuilder) validateLimits(pod *kubernetes.Pod, container string, invalid func(key, format string, args ...interface{})) {
+	limit := l.podLimit(pod)
+	for key, max := range map[string]float64{
+		rateLimit:      limit.EventsPerSecond,
+		maxBytes:       float64(limit.MaxBytes),
+		harvesterLimit: float64(limit.HarvesterLimit),
+	} {
+		raw := l.getAnnotationWithPrefixForContainer(key, container, pod)
+		if raw == "" {
+			continue
+		}
+
+		value, err := parseLimit(key, raw)
+		if err != nil {
+			invalid(key, "%v", err)
+		} else if max != 0 && value > max {
+			invalid(key, "'%s' is over the limit of %v of namespace %s", raw, max, pod.Metadata.Namespace)
+		}
+	}
+}
+
+// parseLimit reads the value of a limit annotation. Sizes may have units such as 64KiB.
+func parseLimit(key, raw string) (float64, error) {
+	var value float64
+	var err error
+	switch key {
+	case maxBytes:
+		var bytes uint64
+		bytes, err = humanize.ParseBytes(raw)
+		value = float64(bytes)
+	case harvesterLimit:
+		var n int
+		n, err = strconv.Atoi(raw)
+		value = float64(n)
+	default:
+		value, err = strconv.ParseFloat(raw, 64)
+	}
+
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("'%s' is not a positive number", raw)
+	}
+	return value, nil
+}
@@ -1,19 +1,26 @@
 package log_annotations
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/metagen"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
 	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+
+	"github.com/ericchiang/k8s"
 )
 
 const (
@@ -34,6 +41,19 @@ var (
 
 func init() {
 	registry.BuilderRegistry.AddBuilder(LogAnnotationsBuilder, NewPodLogAnnotationBuilder)
+	registry.BuilderRegistry.AddBuilderSchema(LogAnnotationsBuilder, schema.Schema{
+		{Name: "prefix", Type: schema.String, Default: default_prefix, Description: "Prefix of the annotations read from pods"},
+		{Name: "base_prospector_config", Type: schema.Object, Description: "Settings every generated prospector starts from"},
+		{Name: "logs_path", Type: schema.String, Default: "/var/lib/docker/containers/", Description: "Directory holding the container logs"},
+		{Name: "default_namespace", Type: schema.String, Description: "Log namespace used when a pod does not set one"},
+		{Name: "custom_path.enabled", Type: schema.Bool, Default: false, Description: "Collect logs from paths inside containers"},
+		{Name: "modules", Type: schema.Object, Description: "Filesets of additional filebeat modules pods can select, by module"},
+		{Name: "multiline_presets", Type: schema.Object, Description: "Multiline settings pods can select by name, replacing the built in ones of the same name"},
+		{Name: "limits.default", Type: schema.Object, Description: "Events per second, max bytes per line and harvester limit of every pod"},
+		{Name: "limits.namespaces", Type: schema.Object, Description: "Limits of pods by namespace, replacing the default limits they set"},
+		{Name: "custom_path.kubelet_dir", Type: schema.String, Default: "/var/lib/kubelet", Description: "Kubelet directory holding the volumes of pods"},
+		{Name: "custom_path.host_root", Type: schema.String, Default: "/", Description: "Where the root filesystem of the host is mounted, hostPath volumes are found below it"},
+	})
 }
 
 // PodLogAnnotationBuilder implements default modules based on pod annotations
@@ -42,11 +62,20 @@ type PodLogAnnotationBuilder struct {
 	logsPath            string
 	defaultNamespace    string
 	enableCustomLogPath bool
+	kubeletDir          string
+	hostRoot            string
+	claims              claimCache
 	baseConfig          common.MapStr
+	modules             map[string][]string
+	multilinePresets    map[string]multiline
+	limits              Limits
 	metadata            metagen.MetaGen
+	specs               kubecommon.PodSpecs
+	client              *k8s.Client
+	ctx                 context.Context
 }
 
-func NewPodLogAnnotationBuilder(cfg *common.Config, _ builder.ClientInfo, meta metagen.MetaGen) (builder.Builder, error) {
+func NewPodLogAnnotationBuilder(cfg *common.Config, clientInfo builder.ClientInfo, meta metagen.MetaGen) (builder.Builder, error) {
 	config := DefaultLogPathConfig()
 
 	err := cfg.Unpack(&config)
@@ -54,12 +83,35 @@ func NewPodLogAnnotationBuilder(cfg *common.Config, _ builder.ClientInfo, meta m
 		return nil, fmt.Errorf("fail to unpack the `logs_annotations` builder configuration: %s", err)
 	}
 
+	modules := defaultModules()
+	for name, filesets := range config.Modules {
+		modules[name] = filesets
+	}
+
+	presets := defaultMultilinePresets()
+	for name, preset := range config.MultilinePresets {
+		presets[name] = preset
+	}
+
+	// Without pod specs or a client custom paths on volumes are left to the log_path appender
+	specs, _ := clientInfo[kubecommon.PodSpecsKey].(kubecommon.PodSpecs)
+	client, _ := clientInfo[kubecommon.ClientKey].(*k8s.Client)
+
 	return &PodLogAnnotationBuilder{
-		prefix:           config.Prefix,
-		baseConfig:       config.BaseProspectorConfig,
-		logsPath:         config.LogsPath,
-		defaultNamespace: config.DefaultNamespace,
-		metadata:         meta,
+		prefix:              config.Prefix,
+		baseConfig:          config.BaseProspectorConfig,
+		logsPath:            config.LogsPath,
+		defaultNamespace:    config.DefaultNamespace,
+		enableCustomLogPath: config.CustomPath.Enabled,
+		kubeletDir:          config.CustomPath.KubeletDir,
+		hostRoot:            config.CustomPath.HostRoot,
+		modules:             modules,
+		multilinePresets:    presets,
+		limits:              config.Limits,
+		metadata:            meta,
+		specs:               specs,
+		client:              client,
+		ctx:                 context.Background(),
 	}, nil
 }
 
@@ -67,6 +119,10 @@ func (l *PodLogAnnotationBuilder) Name() string {
 	return "Log Annotation Builder"
 }
 
+func (l *PodLogAnnotationBuilder) Kind() string {
+	return dcommon.KindLogs
+}
+
 func (l *PodLogAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
 	holders := []*dcommon.ConfigHolder{}
 	pod, ok := obj.(*kubernetes.Pod)
@@ -96,7 +152,8 @@ func (l *PodLogAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon
 			continue
 		}
 
-		meta := dcommon.Meta{}
+		meta := kubecommon.NewPodMeta(LogAnnotationsBuilder, l.prefix, pod)
+		meta[dcommon.MetaContainer] = name
 		containerConfig := l.baseConfig.Clone()
 
 		cid := container.ContainerID
@@ -116,18 +173,19 @@ func (l *PodLogAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon
 			continue
 		}
 
-		var paths []string
-		if l.enableCustomLogPath {
-			paths = l.getPaths(pod, name)
-		}
+		// Modules read the log of the container, custom paths are not used with them
+		moduleName := l.getModule(pod, name)
 
-		containerPattern := l.getPattern(pod, name)
-		if containerPattern != "" {
-			containerMatch := l.getMatch(pod, name)
-			containerNegate := l.getNegate(pod, name)
+		var paths, rootFsPaths []string
+		if l.enableCustomLogPath && moduleName == "" {
+			paths, rootFsPaths = l.resolvePaths(pod, name, l.getPaths(pod, name))
+		}
 
-			setMultilineConfig(containerConfig, containerPattern, containerNegate, containerMatch)
+		if m := l.getMultiline(pod, name); m != nil {
+			setMultilineConfig(containerConfig, *m)
 		}
+		l.applyOverrides(containerConfig, pod, name)
+		l.applyLimits(containerConfig, pod, name, cid)
 
 		if len(paths) == 0 {
 			// Set json only when listening to stdout
@@ -135,13 +193,22 @@ func (l *PodLogAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon
 			containerConfig["paths"] = []string{path}
 		} else if len(paths) != 0 {
 			containerConfig["paths"] = paths
-			meta[cid] = paths
+			if len(rootFsPaths) != 0 {
+				meta[cid] = rootFsPaths
+			}
 		}
 		setNamespace(ns, containerConfig)
 		if cmeta != nil {
 			kubecommon.SetKubeMetadata(cmeta, containerConfig)
 		}
 
+		if moduleName != "" {
+			var ok bool
+			if containerConfig, ok = l.buildModuleConfig(pod, name, moduleName, containerConfig); ok {
+				meta[dcommon.MetaRoute] = dcommon.RouteModule
+			}
+		}
+
 		holder := &dcommon.ConfigHolder{
 			Config: containerConfig,
 			Meta:   meta,
@@ -153,33 +220,100 @@ func (l *PodLogAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon
 	return holders
 }
 
-func (l *PodLogAnnotationBuilder) getNamespace(pod *kubernetes.Pod) string {
-	ns := kubecommon.GetAnnotationWithPrefix(namespace, l.prefix, pod)
-	if ns == "" {
-		return l.defaultNamespace
+// Validate checks the log annotations of a pod and all of its containers
+func (l *PodLogAnnotationBuilder) Validate(obj interface{}) []*builder.ValidationError {
+	pod, ok := obj.(*kubernetes.Pod)
+	if !ok || kubecommon.IsNoOp(l.prefix+"/", pod) {
+		return nil
 	}
 
-	return ns
-}
+	errs := []*builder.ValidationError{}
+	for _, container := range append([]string{""}, containerNames(pod)...) {
+		prefix := l.prefix + "/"
+		if container != "" {
+			prefix = l.prefix + "." + container + "/"
+		}
 
-func (l *PodLogAnnotationBuilder) getPattern(pod *kubernetes.Pod, container string) string {
-	return l.getAnnotationWithPrefixForContainer(pattern, container, pod)
-}
+		invalid := func(key, format string, args ...interface{}) {
+			errs = append(errs, kubecommon.NewValidationError(LogAnnotationsBuilder, prefix+key, pod, format, args...))
+		}
 
-func (l *PodLogAnnotationBuilder) getNegate(pod *kubernetes.Pod, container string) bool {
-	negateStr := l.getAnnotationWithPrefixForContainer(negate, container, pod)
-	negateBool, _ := strconv.ParseBool(negateStr)
+		if value := l.getAnnotationWithPrefixForContainer(pattern, container, pod); value != "" {
+			if _, err := regexp.Compile(value); err != nil {
+				invalid(pattern, "'%s' is not a valid regular expression: %v", value, err)
+			}
+		}
 
-	return negateBool
+		if value := l.getAnnotationWithPrefixForContainer(negate, container, pod); value != "" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				invalid(negate, "'%s' is not a boolean", value)
+			}
+		}
+
+		if value := l.getAnnotationWithPrefixForContainer(match, container, pod); value != "" && value != "after" && value != "before" {
+			invalid(match, "'%s' is not valid, use after or before", value)
+		}
+
+		l.validateMultilinePreset(pod, container, invalid)
+		l.validateOverrides(pod, container, invalid)
+		l.validateLimits(pod, container, invalid)
+
+		if container != "" {
+			for _, path := range l.getPaths(pod, container) {
+				if !filepath.IsAbs(path) {
+					invalid(paths, "'%s' is not an absolute path", path)
+				}
+			}
+
+			name := l.getModule(pod, container)
+			filesets, known := l.modules[name]
+			switch {
+			case name != "" && !known:
+				invalid(module, "'%s' is not a supported module, use one of %s", name, strings.Join(l.moduleNames(), ", "))
+			case name == "" && len(l.getFilesets(pod, container)) != 0:
+				invalid(fileset, "a fileset needs a module")
+			case name != "":
+				for _, fs := range l.getFilesets(pod, container) {
+					if !appender.Contains(filesets, fs) {
+						invalid(fileset, "'%s' is not a fileset of module %s, use one of %s", fs, name, strings.Join(filesets, ", "))
+					}
+				}
+				if len(l.getPaths(pod, container)) != 0 {
+					invalid(paths, "paths are not used with module %s, it reads the container log", name)
+				}
+			}
+		}
+	}
+
+	return errs
 }
 
-func (l *PodLogAnnotationBuilder) getMatch(pod *kubernetes.Pod, container string) string {
-	matchStr := l.getAnnotationWithPrefixForContainer(match, container, pod)
-	if matchStr == "" {
-		return "after"
+// containerNames returns the names of all containers of a pod
+func containerNames(pod *kubernetes.Pod) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, container := range pod.Spec.Containers {
+		if !seen[container.Name] {
+			seen[container.Name] = true
+			names = append(names, container.Name)
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if !seen[status.Name] {
+			seen[status.Name] = true
+			names = append(names, status.Name)
+		}
+	}
+	return names
+}
+
+func (l *PodLogAnnotationBuilder) getNamespace(pod *kubernetes.Pod) string {
+	ns := kubecommon.GetAnnotationWithPrefix(namespace, l.prefix, pod)
+	if ns == "" {
+		return l.defaultNamespace
 	}
 
-	return matchStr
+	return ns
 }
 
 func (l *PodLogAnnotationBuilder) getPaths(pod *kubernetes.Pod, container string) []string {
@@ -230,13 +364,6 @@ func setNamespace(ns string, config common.MapStr) {
 	}
 }
 
-func setMultilineConfig(config common.MapStr, pattern string, negate bool, match string) {
-	config["multiline"] = common.MapStr{
-		"pattern": pattern,
-		"negate":  negate,
-		"match":   match,
-	}
-}
 func setJsonLog(containerConfig common.MapStr) {
 	containerConfig["json"] = common.MapStr{
 		"message_key":     "log",
@@ -4,7 +4,12 @@ import (
 	"encoding/json"
 	"testing"
 
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/api/v1"
 
 	"github.com/elastic/beats/libbeat/common"
 	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
@@ -131,13 +136,564 @@ func TestProspectorConfig(t *testing.T) {
 	}
 
 	multilineCfg := common.MapStr{}
-	setMultilineConfig(multilineCfg, "abc", false, "after")
+	setMultilineConfig(multilineCfg, multiline{Pattern: "abc", Match: "after"})
 
 	assert.Equal(t, confs[0].Config["paths"], []string{"/var/123/*.log"})
 	assert.Equal(t, confs[0].Config["multiline"], multilineCfg["multiline"])
 
-	setMultilineConfig(multilineCfg, "cde", false, "after")
+	setMultilineConfig(multilineCfg, multiline{Pattern: "cde", Match: "after"})
 	assert.Equal(t, confs[1].Config["paths"], []string{"/var/456/*.log"})
 	assert.Equal(t, confs[1].Config["multiline"], multilineCfg["multiline"])
 
 }
+
+func TestLogAnnotationsValidate(t *testing.T) {
+	b, ok := getLogAnnotationBuilder(t)
+	assert.Equal(t, ok, true)
+
+	v, ok := b.(builder.Validator)
+	assert.Equal(t, ok, true)
+
+	tests := []struct {
+		annotations map[string]string
+		keys        []string
+	}{
+		{
+			annotations: map[string]string{
+				"foo/pattern":       "^[0-9]{4}-",
+				"foo.nginx/after":   "before",
+				"foo.nginx/paths":   "/var/log/nginx/access.log",
+				"foo.apache/negate": "true",
+			},
+		},
+		{
+			annotations: map[string]string{
+				"foo/pattern":       "^[",
+				"foo.nginx/after":   "later",
+				"foo.nginx/paths":   "logs/access.log",
+				"foo.apache/negate": "yes",
+			},
+			keys: []string{"foo/pattern", "foo.nginx/after", "foo.nginx/paths", "foo.apache/negate"},
+		},
+	}
+
+	for _, test := range tests {
+		pod := &kubernetes.Pod{}
+		pod.Metadata.Name = "bar"
+		pod.Metadata.Annotations = test.annotations
+		pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+			{Name: "nginx", ContainerID: "docker://123"},
+			{Name: "apache", ContainerID: "docker://456"},
+		}
+
+		var keys []string
+		for _, err := range v.Validate(pod) {
+			keys = append(keys, err.Key)
+		}
+		assert.Equal(t, test.keys, keys, "annotations: %v", test.annotations)
+	}
+}
+
+func TestCustomPaths(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+	server.AddClaim(kubetest.NewClaim("default", "data", "pv-1"))
+	server.AddClaim(kubetest.NewClaim("default", "pending", ""))
+
+	po := kubetest.NewPod("default", "web", "node-1")
+	po.Metadata.Annotations = map[string]string{
+		"foo.web/paths": "/var/log/app/*.log, /var/log/app/nested/app.log, /srv/app.log, /data/app.log, /host/app.log, /sub/app.log, /pending/app.log",
+	}
+	po.Spec.Volumes = []*corev1.Volume{
+		newVolume("logs", &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}),
+		newVolume("nested", &corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}),
+		newVolume("host", &corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: k8s.String("/srv/logs")}}),
+		newVolume("data", &corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: k8s.String("data")}}),
+		newVolume("pending", &corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: k8s.String("pending")}}),
+		newVolume("config", &corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}),
+	}
+	po.Spec.Containers[0].VolumeMounts = []*corev1.VolumeMount{
+		{Name: k8s.String("logs"), MountPath: k8s.String("/var/log/app/")},
+		{Name: k8s.String("nested"), MountPath: k8s.String("/var/log/app/nested")},
+		{Name: k8s.String("host"), MountPath: k8s.String("/host")},
+		{Name: k8s.String("data"), MountPath: k8s.String("/data")},
+		{Name: k8s.String("logs"), MountPath: k8s.String("/sub"), SubPath: k8s.String("web")},
+		{Name: k8s.String("pending"), MountPath: k8s.String("/pending")},
+		{Name: k8s.String("config"), MountPath: k8s.String("/srv")},
+	}
+	pod := kubernetes.GetPodMeta(po)
+
+	clientInfo := builder.ClientInfo{
+		kubecommon.ClientKey:   server.Client(),
+		kubecommon.PodSpecsKey: podSpecs{pod.Metadata.UID: po.Spec},
+	}
+
+	volumes := "/kubelet/pods/default-web/volumes/"
+	tests := []struct {
+		enabled bool
+		specs   bool
+		paths   []string
+		rootFs  []string
+	}{
+		{
+			enabled: false,
+			paths:   []string{"/var/lib/docker/containers/default-web/*.log"},
+		},
+		{
+			enabled: true,
+			specs:   true,
+			paths: []string{
+				volumes + "kubernetes.io~empty-dir/logs/*.log",
+				volumes + "kubernetes.io~empty-dir/nested/app.log",
+				"/srv/app.log",
+				volumes + "*/pv-1/app.log",
+				"/hostfs/srv/logs/app.log",
+				volumes + "kubernetes.io~empty-dir/logs/web/app.log",
+				"/pending/app.log",
+			},
+			rootFs: []string{"/srv/app.log", "/pending/app.log"},
+		},
+		{
+			// Without the pod spec all paths are on the root filesystem
+			enabled: true,
+			paths:   []string{"/var/log/app/*.log", "/var/log/app/nested/app.log", "/srv/app.log", "/data/app.log", "/host/app.log", "/sub/app.log", "/pending/app.log"},
+			rootFs:  []string{"/var/log/app/*.log", "/var/log/app/nested/app.log", "/srv/app.log", "/data/app.log", "/host/app.log", "/sub/app.log", "/pending/app.log"},
+		},
+	}
+
+	for _, test := range tests {
+		info := builder.ClientInfo{kubecommon.ClientKey: server.Client()}
+		if test.specs {
+			info = clientInfo
+		}
+
+		config, err := common.NewConfigFrom(map[string]interface{}{
+			"prefix":                  "foo",
+			"custom_path.enabled":     test.enabled,
+			"custom_path.kubelet_dir": "/kubelet",
+			"custom_path.host_root":   "/hostfs",
+		})
+		assert.Nil(t, err)
+		b, err := NewPodLogAnnotationBuilder(config, info, nil)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		holders := b.(builder.PollerBuilder).BuildModuleConfigs(pod)
+		if !assert.Len(t, holders, 1) {
+			continue
+		}
+		assert.Equal(t, test.paths, holders[0].Config["paths"])
+		if test.rootFs == nil {
+			assert.Nil(t, holders[0].Meta["default-web"])
+		} else {
+			assert.Equal(t, test.rootFs, holders[0].Meta["default-web"])
+		}
+
+		if test.specs {
+			// Bound claims are looked up once per pod, unbound ones again
+			requests := server.Requests("GET", kubetest.Claims)
+			holders = b.(builder.PollerBuilder).BuildModuleConfigs(pod)
+			assert.Equal(t, test.paths, holders[0].Config["paths"])
+			assert.Equal(t, requests+1, server.Requests("GET", kubetest.Claims))
+		}
+	}
+}
+
+type podSpecs map[string]*corev1.PodSpec
+
+func (p podSpecs) GetPodSpec(uid string) *corev1.PodSpec {
+	return p[uid]
+}
+
+func newVolume(name string, source *corev1.VolumeSource) *corev1.Volume {
+	return &corev1.Volume{Name: k8s.String(name), VolumeSource: source}
+}
+
+func TestModuleConfig(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix":    "foo",
+		"logs_path": "/var/",
+		"modules":   map[string]interface{}{"myapp": []string{"audit"}},
+	})
+	assert.Nil(t, err)
+	bRaw, err := NewPodLogAnnotationBuilder(config, nil, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	b := bRaw.(builder.PollerBuilder)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Namespace = "foo"
+	pod.Metadata.Annotations = map[string]string{
+		"foo.nginx/module":   "nginx",
+		"foo.nginx/pattern":  "^[0-9]",
+		"foo.mysql/module":   "mysql",
+		"foo.mysql/fileset":  "slowlog, error",
+		"foo.myapp/module":   "myapp",
+		"foo.apache/module":  "apache",
+		"foo.apache/fileset": "access",
+	}
+	pod.Status.PodIP = "4.5.6.7"
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+		{Name: "nginx", ContainerID: "docker://123"},
+		{Name: "mysql", ContainerID: "docker://456"},
+		{Name: "myapp", ContainerID: "docker://789"},
+		{Name: "apache", ContainerID: "docker://abc"},
+	}
+
+	holders := b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 4) {
+		t.FailNow()
+	}
+
+	// The first fileset is used by default, the others are disabled
+	nginx := holders[0]
+	assert.Equal(t, dcommon.RouteModule, nginx.Meta.GetString(dcommon.MetaRoute))
+	assert.Equal(t, "nginx", nginx.Config["module"])
+	assert.Equal(t, common.MapStr{"enabled": false}, nginx.Config["error"])
+
+	access := nginx.Config["access"].(common.MapStr)
+	assert.Equal(t, true, access["enabled"])
+	assert.Equal(t, common.MapStr{"paths": []string{"/var/123/*.log"}}, access["var"])
+
+	prospector := access["prospector"].(common.MapStr)
+	assert.NotContains(t, prospector, "paths")
+	assert.NotContains(t, prospector, "type")
+	assert.Equal(t, "log", prospector["json"].(common.MapStr)["message_key"])
+	assert.Equal(t, "^[0-9]", prospector["multiline"].(common.MapStr)["pattern"])
+	assert.Equal(t, []common.MapStr{{"rename": common.MapStr{
+		"fields": []common.MapStr{{"from": "log", "to": "message"}},
+	}}}, prospector["processors"])
+
+	mysql := holders[1]
+	assert.Equal(t, true, mysql.Config["error"].(common.MapStr)["enabled"])
+	assert.Equal(t, true, mysql.Config["slowlog"].(common.MapStr)["enabled"])
+
+	myapp := holders[2]
+	assert.Equal(t, "myapp", myapp.Config["module"])
+	assert.Equal(t, true, myapp.Config["audit"].(common.MapStr)["enabled"])
+
+	// Unknown modules keep the prospector
+	apache := holders[3]
+	assert.Equal(t, "", apache.Meta.GetString(dcommon.MetaRoute))
+	assert.Equal(t, []string{"/var/abc/*.log"}, apache.Config["paths"])
+	assert.Nil(t, apache.Config["module"])
+}
+
+func TestModuleValidate(t *testing.T) {
+	b, ok := getLogAnnotationBuilder(t)
+	assert.Equal(t, ok, true)
+	v := b.(builder.Validator)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Annotations = map[string]string{
+		"foo.nginx/module":   "nginx",
+		"foo.nginx/fileset":  "access,slowlog",
+		"foo.nginx/paths":    "/var/log/nginx/access.log",
+		"foo.apache/module":  "apache",
+		"foo.mysql/fileset":  "error",
+		"foo.redis/module":   "redis",
+		"foo.redis/fileset":  "slowlog",
+		"foo.mongodb/module": "mongodb",
+	}
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+		{Name: "nginx"}, {Name: "apache"}, {Name: "mysql"}, {Name: "redis"}, {Name: "mongodb"},
+	}
+
+	var keys []string
+	for _, err := range v.Validate(pod) {
+		keys = append(keys, err.Key)
+	}
+	assert.Equal(t, []string{"foo.nginx/fileset", "foo.nginx/paths", "foo.apache/module", "foo.mysql/fileset"}, keys)
+}
+
+func TestOverrides(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix":            "foo",
+		"default_namespace": "abc",
+		"base_prospector_config": map[string]interface{}{
+			"type":          "log",
+			"exclude_lines": []string{"^DEBUG"},
+			"fields":        map[string]interface{}{"env": "prod", "team": "platform"},
+			"tags":          []string{"k8s"},
+		},
+	})
+	assert.Nil(t, err)
+	bRaw, err := NewPodLogAnnotationBuilder(config, nil, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	b := bRaw.(builder.PollerBuilder)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Annotations = map[string]string{
+		"foo/exclude_lines":             `["GET /healthz", "GET /ready"]`,
+		"foo/fields.team":               "payments",
+		"foo/fields.service.name":       "checkout",
+		"foo/fields.namespace":          "other",
+		"foo/tags":                      "web, k8s",
+		"foo.nginx/exclude_lines":       "[0-9]+ GET /ping",
+		"foo.nginx/include_lines":       "^ERR",
+		"foo.nginx/fields.service.name": "checkout-nginx",
+		"foo.nginx/tags":                "nginx",
+	}
+	pod.Status.PodIP = "4.5.6.7"
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+		{Name: "nginx", ContainerID: "docker://123"},
+		{Name: "app", ContainerID: "docker://456"},
+	}
+
+	holders := b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 2) {
+		t.FailNow()
+	}
+
+	nginx, app := holders[0].Config, holders[1].Config
+	assert.Equal(t, []string{"[0-9]+ GET /ping"}, nginx["exclude_lines"])
+	assert.Equal(t, []string{"^ERR"}, nginx["include_lines"])
+	assert.Equal(t, []string{"k8s", "web", "nginx"}, nginx["tags"])
+	assert.Equal(t, common.MapStr{
+		"env":       "prod",
+		"team":      "payments",
+		"service":   common.MapStr{"name": "checkout-nginx"},
+		"namespace": "abc",
+	}, nginx["fields"])
+
+	assert.Equal(t, []string{"GET /healthz", "GET /ready"}, app["exclude_lines"])
+	assert.Nil(t, app["include_lines"])
+	assert.Equal(t, []string{"k8s", "web"}, app["tags"])
+	assert.Equal(t, common.MapStr{"name": "checkout"}, app["fields"].(common.MapStr)["service"])
+}
+
+func TestOverridesValidate(t *testing.T) {
+	b, ok := getLogAnnotationBuilder(t)
+	assert.Equal(t, ok, true)
+	v := b.(builder.Validator)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Annotations = map[string]string{
+		"foo/exclude_lines":         `["GET /healthz", "(unclosed"]`,
+		"foo/include_lines":         "[0-9]{4}",
+		"foo/fields.kubernetes.pod": "web",
+		"foo.nginx/include_lines":   "^[",
+		"foo.nginx/fields.team":     "payments",
+		"foo.nginx/fields.":         "empty",
+		"foo.nginx/tags":            "web",
+		"foo.nginx/exclude_lines":   `["^DEBUG"]`,
+	}
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{{Name: "nginx"}}
+
+	keys := map[string]bool{}
+	for _, err := range v.Validate(pod) {
+		keys[err.Key] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"foo/exclude_lines":         true,
+		"foo/fields.kubernetes.pod": true,
+		"foo.nginx/include_lines":   true,
+		"foo.nginx/fields.":         true,
+	}, keys)
+}
+
+func TestMultilinePresets(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix":            "foo",
+		"default_namespace": "abc",
+		"multiline_presets": map[string]interface{}{
+			"python": map[string]interface{}{"pattern": "^Traceback", "negate": true, "match": "after"},
+			"log4j":  map[string]interface{}{"pattern": "^[0-9]{4}-", "negate": true, "match": "after", "timeout": "10s"},
+		},
+	})
+	assert.Nil(t, err)
+	bRaw, err := NewPodLogAnnotationBuilder(config, nil, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	b := bRaw.(builder.PollerBuilder)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Annotations = map[string]string{
+		"foo/multiline":       "java",
+		"foo.api/multiline":   "python",
+		"foo.api/after":       "before",
+		"foo.batch/multiline": "log4j",
+		"foo.web/pattern":     "^[[:space:]]",
+		"foo.bad/multiline":   "cobol",
+	}
+	pod.Status.PodIP = "4.5.6.7"
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+		{Name: "app", ContainerID: "docker://1"},
+		{Name: "api", ContainerID: "docker://2"},
+		{Name: "batch", ContainerID: "docker://3"},
+		{Name: "web", ContainerID: "docker://4"},
+		{Name: "bad", ContainerID: "docker://5"},
+	}
+
+	holders := b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 5) {
+		t.FailNow()
+	}
+
+	java := defaultMultilinePresets()["java"]
+	assert.Equal(t, common.MapStr{
+		"pattern":   java.Pattern,
+		"negate":    false,
+		"match":     "after",
+		"max_lines": 1000,
+	}, holders[0].Config["multiline"])
+	assert.Equal(t, common.MapStr{"pattern": "^Traceback", "negate": true, "match": "before"}, holders[1].Config["multiline"])
+	assert.Equal(t, common.MapStr{"pattern": "^[0-9]{4}-", "negate": true, "match": "after", "timeout": "10s"}, holders[2].Config["multiline"])
+	assert.Equal(t, common.MapStr{"pattern": "^[[:space:]]", "negate": false, "match": "after"}, holders[3].Config["multiline"])
+	assert.Nil(t, holders[4].Config["multiline"])
+
+	v := bRaw.(builder.Validator)
+	keys := []string{}
+	for _, err := range v.Validate(pod) {
+		keys = append(keys, err.Key)
+	}
+	assert.Equal(t, []string{"foo.bad/multiline"}, keys)
+
+	config, err = common.NewConfigFrom(map[string]interface{}{
+		"multiline_presets": map[string]interface{}{"broken": map[string]interface{}{"pattern": "(", "match": "after"}},
+	})
+	assert.Nil(t, err)
+	_, err = NewPodLogAnnotationBuilder(config, nil, nil)
+	assert.NotNil(t, err)
+}
+
+func TestLimits(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix": "foo",
+		"base_prospector_config": map[string]interface{}{
+			"type":       "log",
+			"processors": []map[string]interface{}{{"drop_fields": map[string]interface{}{"fields": []string{"beat"}}}},
+		},
+		"limits": map[string]interface{}{
+			"default": map[string]interface{}{"events_per_second": 500, "max_bytes": 1048576},
+			"namespaces": map[string]interface{}{
+				"batch": map[string]interface{}{"events_per_second": 100, "burst": 200, "scope": "namespace"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	bRaw, err := NewPodLogAnnotationBuilder(config, nil, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	b := bRaw.(builder.PollerBuilder)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Namespace = "web"
+	pod.Metadata.UID = "uid"
+	pod.Metadata.Annotations = map[string]string{
+		"foo/max_bytes":             "64KiB",
+		"foo/rate_limit":            "1000",
+		"foo.nginx/rate_limit":      "50",
+		"foo.nginx/max_bytes":       "10MiB",
+		"foo.nginx/harvester_limit": "2",
+	}
+	pod.Status.PodIP = "4.5.6.7"
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{
+		{Name: "nginx", ContainerID: "docker://123"},
+		{Name: "app", ContainerID: "docker://456"},
+	}
+
+	holders := b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 2) {
+		t.FailNow()
+	}
+
+	nginx, app := holders[0].Config, holders[1].Config
+	assert.Equal(t, 65536, nginx["max_bytes"])
+	assert.Equal(t, 2, nginx["harvester_limit"])
+	assert.Equal(t, []common.MapStr{
+		{"drop_fields": map[string]interface{}{"fields": []interface{}{"beat"}}},
+		{"rate_limit": common.MapStr{"key": "pod/uid", "events_per_second": float64(500)}},
+		{"rate_limit": common.MapStr{"key": "annotation/container/123", "events_per_second": float64(50)}},
+	}, nginx["processors"])
+
+	assert.Equal(t, 65536, app["max_bytes"])
+	assert.Nil(t, app["harvester_limit"])
+	assert.Equal(t, []common.MapStr{
+		{"drop_fields": map[string]interface{}{"fields": []interface{}{"beat"}}},
+		{"rate_limit": common.MapStr{"key": "pod/uid", "events_per_second": float64(500)}},
+	}, app["processors"])
+
+	// Namespaces replace the default limits they set
+	pod.Metadata.Namespace = "batch"
+	pod.Metadata.Annotations = map[string]string{}
+	holders = b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, 1048576, holders[1].Config["max_bytes"])
+	assert.Equal(t, common.MapStr{"rate_limit": common.MapStr{
+		"key":               "namespace/batch",
+		"events_per_second": float64(100),
+		"burst":             200,
+	}}, holders[1].Config["processors"].([]common.MapStr)[1])
+
+	// A rate set on the pod is shared by its containers
+	pod.Metadata.Namespace = "web"
+	pod.Metadata.Annotations = map[string]string{"foo/rate_limit": "200"}
+	holders = b.BuildModuleConfigs(pod)
+	if !assert.Len(t, holders, 2) {
+		t.FailNow()
+	}
+	for _, holder := range holders {
+		assert.Equal(t, common.MapStr{"rate_limit": common.MapStr{
+			"key":               "annotation/pod/uid",
+			"events_per_second": float64(200),
+		}}, holder.Config["processors"].([]common.MapStr)[2])
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix": "foo",
+		"limits": map[string]interface{}{
+			"default": map[string]interface{}{"events_per_second": 500, "max_bytes": 1048576},
+		},
+	})
+	assert.Nil(t, err)
+	b, err := NewPodLogAnnotationBuilder(config, nil, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	v := b.(builder.Validator)
+
+	pod := &kubernetes.Pod{}
+	pod.Metadata.Name = "bar"
+	pod.Metadata.Annotations = map[string]string{
+		"foo/rate_limit":            "1000",
+		"foo/max_bytes":             "64KiB",
+		"foo.nginx/max_bytes":       "lots",
+		"foo.nginx/harvester_limit": "0",
+		"foo.nginx/rate_limit":      "0.5",
+	}
+	pod.Status.ContainerStatuses = []kubernetes.PodContainerStatus{{Name: "nginx"}}
+
+	keys := map[string]bool{}
+	for _, err := range v.Validate(pod) {
+		keys[err.Key] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"foo/rate_limit":            true,
+		"foo.nginx/max_bytes":       true,
+		"foo.nginx/harvester_limit": true,
+	}, keys)
+
+	config, err = common.NewConfigFrom(map[string]interface{}{
+		"limits": map[string]interface{}{"default": map[string]interface{}{"scope": "node"}},
+	})
+	assert.Nil(t, err)
+	_, err = NewPodLogAnnotationBuilder(config, nil, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+package log_annotations
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/processors/rename"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	module  = "module"
+	fileset = "fileset"
+)
+
+// defaultModules lists the filesets of the filebeat modules that read log files. The first
+// fileset is used when a pod does not select one. Filesets that are not selected are
+// disabled as filebeat would otherwise read their default paths on the host.
+func defaultModules() map[string][]string {
+	return map[string][]string{
+		"apache2":    {"access", "error"},
+		"kafka":      {"log"},
+		"logstash":   {"log", "slowlog"},
+		"mongodb":    {"log"},
+		"mysql":      {"error", "slowlog"},
+		"nginx":      {"access", "error"},
+		"postgresql": {"log"},
+		"redis":      {"log", "slowlog"},
+		"traefik":    {"access"},
+	}
+}
+
+func (l *PodLogAnnotationBuilder) getModule(pod *kubernetes.Pod, container string) string {
+	return strings.TrimSpace(l.getAnnotationWithPrefixForContainer(module, container, pod))
+}
+
+func (l *PodLogAnnotationBuilder) getFilesets(pod *kubernetes.Pod, container string) []string {
+	value := l.getAnnotationWithPrefixForContainer(fileset, container, pod)
+
+	filesets := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			filesets = append(filesets, name)
+		}
+	}
+	return filesets
+}
+
+// moduleNames returns the names of all supported modules, sorted
+func (l *PodLogAnnotationBuilder) moduleNames() []string {
+	names := make([]string, 0, len(l.modules))
+	for name := range l.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moduleConfig turns a prospector config into the config of a filebeat module reading the
+// same paths. It returns nil if the module is not supported.
+func (l *PodLogAnnotationBuilder) moduleConfig(name string, selected []string, prospector common.MapStr) common.MapStr {
+	filesets, ok := l.modules[name]
+	if !ok {
+		return nil
+	}
+
+	enabled := map[string]bool{}
+	for _, fs := range selected {
+		if appender.Contains(filesets, fs) {
+			enabled[fs] = true
+		}
+	}
+	if len(enabled) == 0 && len(filesets) != 0 {
+		enabled[filesets[0]] = true
+	}
+
+	// The module decides the prospector type, the rest of the prospector config is kept
+	overrides := prospector.Clone()
+	paths := overrides["paths"]
+	delete(overrides, "paths")
+	delete(overrides, "type")
+	delete(overrides, "enabled")
+
+	// Module pipelines parse the message, which the Docker JSON decoding puts into log
+	if _, ok := overrides["json"]; ok {
+		overrides["processors"] = appender.AppendProcessors(overrides["processors"], common.MapStr{
+			rename.Name: common.MapStr{
+				"fields": []common.MapStr{{"from": "log", "to": "message"}},
+			},
+		})
+	}
+
+	config := common.MapStr{module: name}
+	for _, fs := range filesets {
+		if !enabled[fs] {
+			config[fs] = common.MapStr{"enabled": false}
+			continue
+		}
+
+		config[fs] = common.MapStr{
+			"enabled":    true,
+			"var":        common.MapStr{"paths": paths},
+			"prospector": overrides.Clone(),
+		}
+	}
+	return config
+}
+
+// buildModuleConfig replaces a prospector config with the module selected for a container.
+// Containers with unknown modules keep their prospector.
+func (l *PodLogAnnotationBuilder) buildModuleConfig(pod *kubernetes.Pod, container, name string, prospector common.MapStr) (common.MapStr, bool) {
+	config := l.moduleConfig(name, l.getFilesets(pod, container), prospector)
+	if config == nil {
+		logp.Err("Unable to use module %s for pod %s, container %s as it is not supported", name, pod.Metadata.Name, container)
+		return prospector, false
+	}
+	return config, true
+}
@@ -0,0 +1,154 @@
+package log_annotations
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const multilinePreset = "multiline"
+
+// multiline holds the multiline settings of a prospector
+type multiline struct {
+	Pattern  string `config:"pattern" validate:"required"`
+	Negate   bool   `config:"negate"`
+	Match    string `config:"match"`
+	MaxLines int    `config:"max_lines"`
+	Timeout  string `config:"timeout"`
+}
+
+func (m *multiline) Validate() error {
+	if _, err := regexp.Compile(m.Pattern); err != nil {
+		return fmt.Errorf("'%s' is not a valid regular expression: %v", m.Pattern, err)
+	}
+	if m.Match != "" && m.Match != "after" && m.Match != "before" {
+		return fmt.Errorf("match '%s' is not valid, use after or before", m.Match)
+	}
+	if m.Timeout != "" {
+		if _, err := time.ParseDuration(m.Timeout); err != nil {
+			return fmt.Errorf("timeout '%s' is not a valid duration", m.Timeout)
+		}
+	}
+	return nil
+}
+
+// defaultMultilinePresets returns the multiline settings of common stack trace formats. All
+// of them append the lines of a trace to the log line before it.
+func defaultMultilinePresets() map[string]multiline {
+	return map[string]multiline{
+		// Frames, elided frames and causes of exceptions
+		"java": {
+			Pattern:  `^[[:space:]]+(at|\.{3})[[:space:]]|^Caused by:`,
+			Match:    "after",
+			MaxLines: 1000,
+		},
+		// Frames and source lines of a traceback and the exception that ends it
+		"python": {
+			Pattern: `^[[:space:]]|^[A-Za-z_][A-Za-z0-9_.]*(Error|Exception|Warning)(:|$)`,
+			Match:   "after",
+		},
+		// Goroutine dumps of panics
+		"go": {
+			Pattern:  `^[[:space:]]|^goroutine [0-9]+ \[|^[^[:space:]]+\(.*\)$|^created by |^$`,
+			Match:    "after",
+			MaxLines: 1000,
+		},
+		// Backtrace lines, as printed by the interpreter and by loggers
+		"ruby": {
+			Pattern: `^[[:space:]]+(from[[:space:]]|[^[:space:]]+:[0-9]+:in[[:space:]])`,
+			Match:   "after",
+		},
+		// Frames, inner exceptions and async boundaries
+		"dotnet": {
+			Pattern:  `^[[:space:]]+at[[:space:]]|^[[:space:]]*--->|^---[[:space:]]End of`,
+			Match:    "after",
+			MaxLines: 1000,
+		},
+		// Frames of errors
+		"nodejs": {
+			Pattern: `^[[:space:]]+at[[:space:]]`,
+			Match:   "after",
+		},
+	}
+}
+
+// getMultiline returns the multiline settings of a container. Container annotations take
+// precedence over pod annotations. On each level a pattern annotation overrides the pattern
+// of a preset, negate and match annotations override its other settings.
+func (l *PodLogAnnotationBuilder) getMultiline(pod *kubernetes.Pod, container string) *multiline {
+	for _, level := range []string{container, ""} {
+		preset := l.getAnnotationWithPrefixForContainer(multilinePreset, level, pod)
+		levelPattern := l.getAnnotationWithPrefixForContainer(pattern, level, pod)
+		if preset == "" && levelPattern == "" {
+			continue
+		}
+
+		m := multiline{}
+		if preset != "" {
+			settings, ok := l.multilinePresets[preset]
+			if ok {
+				m = settings
+			} else {
+				logp.Err("Unable to use multiline preset %s of pod %s as it is not defined", preset, pod.Metadata.Name)
+				if levelPattern == "" {
+					return nil
+				}
+			}
+		}
+
+		if levelPattern != "" {
+			m.Pattern = levelPattern
+		}
+		if value := l.getAnnotationWithPrefixForContainer(negate, level, pod); value != "" {
+			m.Negate, _ = strconv.ParseBool(value)
+		}
+		if value := l.getAnnotationWithPrefixForContainer(match, level, pod); value != "" {
+			m.Match = value
+		}
+		if m.Match == "" {
+			m.Match = "after"
+		}
+		return &m
+	}
+	return nil
+}
+
+// multilinePresetNames returns the names of all presets, sorted
+func (l *PodLogAnnotationBuilder) multilinePresetNames() []string {
+	names := make([]string, 0, len(l.multilinePresets))
+	for name := range l.multilinePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func setMultilineConfig(config common.MapStr, m multiline) {
+	settings := common.MapStr{
+		"pattern": m.Pattern,
+		"negate":  m.Negate,
+		"match":   m.Match,
+	}
+	if m.MaxLines != 0 {
+		settings["max_lines"] = m.MaxLines
+	}
+	if m.Timeout != "" {
+		settings["timeout"] = m.Timeout
+	}
+	config["multiline"] = settings
+}
+
+// validateMultilinePreset checks that the preset of a pod or container is defined
+func (l *PodLogAnnotationBuilder) validateMultilinePreset(pod *kubernetes.Pod, container string, invalid func(key, format string, args ...interface{})) {
+	preset := l.getAnnotationWithPrefixForContainer(multilinePreset, container, pod)
+	if _, ok := l.multilinePresets[preset]; preset != "" && !ok {
+		invalid(multilinePreset, "'%s' is not a multiline preset, use one of %s", preset, strings.Join(l.multilinePresetNames(), ", "))
+	}
+}
@@ -0,0 +1,143 @@
+package log_annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	includeLines = "include_lines"
+	excludeLines = "exclude_lines"
+	fields       = "fields"
+	tags         = "tags"
+)
+
+// applyOverrides merges the line filters, fields and tags of a pod and container into a
+// prospector config. Container annotations take precedence over pod annotations, which take
+// precedence over base_prospector_config:
+//   - include_lines and exclude_lines of the most specific level replace the others
+//   - fields are merged by key
+//   - tags of all levels are combined
+func (l *PodLogAnnotationBuilder) applyOverrides(config common.MapStr, pod *kubernetes.Pod, container string) {
+	for _, level := range []string{"", container} {
+		for _, key := range []string{includeLines, excludeLines} {
+			value := l.getAnnotationWithPrefixForContainer(key, level, pod)
+			if value == "" {
+				continue
+			}
+
+			patterns, err := parseLines(value)
+			if err != nil {
+				logp.Err("Unable to use %s of pod %s due to error: %v", key, pod.Metadata.Name, err)
+				continue
+			}
+			config[key] = patterns
+		}
+
+		for key, value := range l.getFields(pod, level) {
+			if appender.IsReserved(key) {
+				continue
+			}
+			if _, ok := config[fields]; !ok {
+				config[fields] = common.MapStr{}
+			}
+			config[fields].(common.MapStr).Put(key, value)
+		}
+
+		if levelTags := l.getTags(pod, level); len(levelTags) != 0 {
+			config[tags] = appendTags(config[tags], levelTags)
+		}
+	}
+}
+
+// validateOverrides checks the line filters and fields of a pod or container
+func (l *PodLogAnnotationBuilder) validateOverrides(pod *kubernetes.Pod, container string, invalid func(key, format string, args ...interface{})) {
+	for _, key := range []string{includeLines, excludeLines} {
+		value := l.getAnnotationWithPrefixForContainer(key, container, pod)
+		if value == "" {
+			continue
+		}
+
+		if _, err := parseLines(value); err != nil {
+			invalid(key, "%v", err)
+		}
+	}
+
+	for key := range l.getFields(pod, container) {
+		if key == "" || appender.IsReserved(key) {
+			invalid(fields+"."+key, "'%s' can not be used as a field name", key)
+		}
+	}
+}
+
+// getFields returns the fields.<key> annotations of a pod or container by key
+func (l *PodLogAnnotationBuilder) getFields(pod *kubernetes.Pod, container string) map[string]string {
+	prefix := l.prefix + "/" + fields + "."
+	if container != "" {
+		prefix = l.prefix + "." + container + "/" + fields + "."
+	}
+
+	out := map[string]string{}
+	for key, value := range kubecommon.GetAnnotationsWithPrefix(prefix, pod) {
+		out[strings.TrimPrefix(key, prefix)] = value
+	}
+	return out
+}
+
+func (l *PodLogAnnotationBuilder) getTags(pod *kubernetes.Pod, container string) []string {
+	value := l.getAnnotationWithPrefixForContainer(tags, container, pod)
+
+	out := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// parseLines reads a JSON list of regular expressions or a single one. Values that are not
+// a JSON list are a single expression, which may start with a character class.
+func parseLines(value string) ([]string, error) {
+	var patterns []string
+	if err := json.Unmarshal([]byte(value), &patterns); err != nil || len(patterns) == 0 {
+		patterns = []string{value}
+	}
+
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid regular expression: %v", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// appendTags adds tags to the tags of a config that are not there yet
+func appendTags(existing interface{}, add []string) []string {
+	out := []string{}
+	switch list := existing.(type) {
+	case []string:
+		out = append(out, list...)
+	case []interface{}:
+		for _, tag := range list {
+			out = append(out, fmt.Sprint(tag))
+		}
+	}
+
+	for _, tag := range add {
+		if !appender.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package log_annotations
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "github.com/ericchiang/k8s/api/v1"
+
+	"github.com/elastic/beats/libbeat/logp"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	emptyDirPlugin = "kubernetes.io~empty-dir"
+	claimTimeout   = 5 * time.Second
+)
+
+// claimCache keeps the persistent volumes of the claims of pods. The volume bound to a claim
+// does not change, so it is looked up once per pod.
+type claimCache struct {
+	sync.Mutex
+	// volumes maps pod UIDs to the volume of each of their claims
+	volumes map[string]map[string]string
+}
+
+// resolvePaths maps the custom paths of a container to the host. Paths on a volume of the pod
+// are resolved to the volume's directory on the host. All other paths are also returned as
+// rootFs paths, which the log_path appender resolves against the container's root filesystem.
+func (l *PodLogAnnotationBuilder) resolvePaths(pod *kubernetes.Pod, container string, paths []string) ([]string, []string) {
+	var spec *corev1.PodSpec
+	if l.specs != nil {
+		spec = l.specs.GetPodSpec(pod.Metadata.UID)
+	}
+
+	resolved := []string{}
+	rootFs := []string{}
+	for _, path := range paths {
+		if spec != nil {
+			hostPath, err := l.volumePath(pod, spec, container, path)
+			if err != nil {
+				logp.Err("Unable to resolve path %s of pod %s due to error: %v", path, pod.Metadata.Name, err)
+			} else if hostPath != "" {
+				debug("Path %s of pod %s, container %s is on a volume at %s", path, pod.Metadata.Name, container, hostPath)
+				resolved = append(resolved, hostPath)
+				continue
+			}
+		}
+
+		resolved = append(resolved, path)
+		rootFs = append(rootFs, path)
+	}
+	return resolved, rootFs
+}
+
+// volumePath returns the host path of a path inside a container or an empty string when the
+// path is not on an emptyDir, hostPath or persistent volume claim volume.
+func (l *PodLogAnnotationBuilder) volumePath(pod *kubernetes.Pod, spec *corev1.PodSpec, container, path string) (string, error) {
+	path = filepath.Clean(path)
+	mount := volumeMount(spec, container, path)
+	if mount == nil {
+		return "", nil
+	}
+
+	var volume *corev1.Volume
+	for _, v := range spec.GetVolumes() {
+		if v.GetName() == mount.GetName() {
+			volume = v
+			break
+		}
+	}
+	if volume == nil {
+		return "", nil
+	}
+
+	var dir string
+	source := volume.GetVolumeSource()
+	volumes := filepath.Join(l.kubeletDir, "pods", pod.Metadata.UID, "volumes")
+	switch {
+	case source.GetEmptyDir() != nil:
+		dir = filepath.Join(volumes, emptyDirPlugin, volume.GetName())
+
+	case source.GetHostPath() != nil:
+		dir = filepath.Join(l.hostRoot, source.GetHostPath().GetPath())
+
+	case source.GetPersistentVolumeClaim() != nil:
+		pv, err := l.claimVolume(pod, source.GetPersistentVolumeClaim().GetClaimName())
+		if err != nil {
+			return "", err
+		}
+		// The directory of the volume plugin depends on the type of the persistent volume
+		dir = filepath.Join(volumes, "*", pv)
+
+	default:
+		return "", nil
+	}
+
+	rel := strings.TrimPrefix(path, filepath.Clean(mount.GetMountPath()))
+	return filepath.Join(dir, mount.GetSubPath(), rel), nil
+}
+
+// claimVolume returns the name of the persistent volume bound to a claim of a pod
+func (l *PodLogAnnotationBuilder) claimVolume(pod *kubernetes.Pod, name string) (string, error) {
+	uid := pod.Metadata.UID
+	l.claims.Lock()
+	defer l.claims.Unlock()
+
+	if volume, ok := l.claims.volumes[uid][name]; ok {
+		return volume, nil
+	}
+
+	if l.client == nil {
+		return "", fmt.Errorf("no kubernetes client to look up claim %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(l.ctx, claimTimeout)
+	defer cancel()
+	claim, err := l.client.CoreV1().GetPersistentVolumeClaim(ctx, name, pod.Metadata.Namespace)
+	if err != nil {
+		return "", err
+	}
+
+	volume := claim.GetSpec().GetVolumeName()
+	if volume == "" {
+		return "", fmt.Errorf("claim %s is not bound", name)
+	}
+
+	// Pods that are gone are forgotten whenever a claim is looked up
+	if l.claims.volumes == nil {
+		l.claims.volumes = map[string]map[string]string{}
+	}
+	for cached := range l.claims.volumes {
+		if l.specs != nil && l.specs.GetPodSpec(cached) == nil {
+			delete(l.claims.volumes, cached)
+		}
+	}
+	if l.claims.volumes[uid] == nil {
+		l.claims.volumes[uid] = map[string]string{}
+	}
+	l.claims.volumes[uid][name] = volume
+	return volume, nil
+}
+
+// volumeMount returns the mount of a container holding a path. Nested mounts win over the
+// mounts they are in.
+func volumeMount(spec *corev1.PodSpec, container, path string) *corev1.VolumeMount {
+	var found *corev1.VolumeMount
+	for _, c := range spec.GetContainers() {
+		if c.GetName() != container {
+			continue
+		}
+
+		for _, mount := range c.GetVolumeMounts() {
+			mountPath := filepath.Clean(mount.GetMountPath())
+			if path != mountPath && !strings.HasPrefix(path, strings.TrimSuffix(mountPath, "/")+"/") {
+				continue
+			}
+			if found == nil || len(mountPath) > len(filepath.Clean(found.GetMountPath())) {
+				found = mount
+			}
+		}
+	}
+	return found
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/metagen"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -36,10 +39,16 @@ const (
 
 var (
 	debug = logp.MakeDebug(AnnotationsBuilder)
+
+	// schemes that can be used to build endpoint URLs
+	validSchemes = []string{"http", "https", "tcp", "udp", "unix"}
 )
 
 func init() {
 	registry.BuilderRegistry.AddBuilder(AnnotationsBuilder, NewPodAnnotationBuilder)
+	registry.BuilderRegistry.AddBuilderSchema(AnnotationsBuilder, schema.Schema{
+		{Name: "prefix", Type: schema.String, Default: default_prefix, Description: "Prefix of the annotations read from pods"},
+	})
 }
 
 // PodAnnotationBuilder implements default modules based on pod annotations
@@ -72,6 +81,10 @@ func (p *PodAnnotationBuilder) Name() string {
 	return "Annotation Builder"
 }
 
+func (p *PodAnnotationBuilder) Kind() string {
+	return dcommon.KindMetrics
+}
+
 func (p *PodAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
 	holders := []*dcommon.ConfigHolder{}
 
@@ -147,6 +160,7 @@ func (p *PodAnnotationBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.Co
 
 	holder := &dcommon.ConfigHolder{
 		Config: moduleConfig,
+		Meta:   kubecommon.NewPodMeta(AnnotationsBuilder, p.Prefix, pod),
 	}
 	holders = append(holders, holder)
 	return holders
@@ -235,3 +249,68 @@ func (p *PodAnnotationBuilder) getInsecureSkipVerify(pod *kubernetes.Pod) bool {
 	verify, _ := strconv.ParseBool(verifyStr)
 	return verify
 }
+
+// Validate checks the metrics annotations of a pod
+func (p *PodAnnotationBuilder) Validate(obj interface{}) []*builder.ValidationError {
+	pod, ok := obj.(*kubernetes.Pod)
+	if !ok || kubecommon.IsNoOp(p.Prefix, pod) {
+		return nil
+	}
+
+	errs := []*builder.ValidationError{}
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, kubecommon.NewValidationError(AnnotationsBuilder, p.Prefix+key, pod, format, args...))
+	}
+
+	mtype := p.getMetricType(pod)
+	if mtype == "" {
+		for _, key := range []string{endpoints, metricsets, namespace, interval, timeout, scheme} {
+			if kubecommon.GetAnnotationWithPrefix(key, p.Prefix, pod) != "" {
+				invalid(metrictype, "is required when %s%s is set", p.Prefix, key)
+				break
+			}
+		}
+		return errs
+	}
+
+	registered := mb.Registry.MetricSets(mtype)
+	if len(registered) == 0 {
+		invalid(metrictype, "metrics type '%s' is unknown", mtype)
+	} else if kubecommon.GetAnnotationWithPrefix(metricsets, p.Prefix, pod) != "" {
+		for _, mset := range p.getMetricSets(mtype, pod) {
+			if !appender.Contains(registered, mset) {
+				invalid(metricsets, "metricset '%s' is unknown for metrics type '%s', available metricsets are: %s",
+					mset, mtype, strings.Join(registered, ", "))
+			}
+		}
+	}
+
+	// The IP is only used to check that endpoints are set
+	if len(p.getEndpoints("0.0.0.0", pod)) == 0 {
+		invalid(endpoints, "at least one endpoint is required")
+	}
+
+	if p.isNamespaceRequired(mtype) && p.getNamespace(pod) == "" {
+		invalid(namespace, "is required for metrics type '%s'", mtype)
+	}
+
+	for _, key := range []string{interval, timeout} {
+		if value := kubecommon.GetAnnotationWithPrefix(key, p.Prefix, pod); value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				invalid(key, "'%s' is not a valid duration, use a value such as 10s or 1m", value)
+			}
+		}
+	}
+
+	if s := p.getScheme(pod); s != "" && !appender.Contains(validSchemes, s) {
+		invalid(scheme, "'%s' is not supported, use one of: %s", s, strings.Join(validSchemes, ", "))
+	}
+
+	if value := kubecommon.GetAnnotationWithPrefix(insecure_skip_verify, p.Prefix, pod); value != "" {
+		if _, err := strconv.ParseBool(value); err != nil {
+			invalid(insecure_skip_verify, "'%s' is not a boolean", value)
+		}
+	}
+
+	return errs
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+	_ "github.com/elastic/beats/metricbeat/module/prometheus/collector"
 )
 
 func TestMetricsAnnotations(t *testing.T) {
@@ -104,3 +105,70 @@ func TestMetricsAnnotations(t *testing.T) {
 		assert.Equal(t, len(confs), test.length)
 	}
 }
+
+func TestMetricsAnnotationsValidate(t *testing.T) {
+	config, err := common.NewConfigFrom(map[string]interface{}{
+		"prefix": "foo",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bRaw, err := NewPodAnnotationBuilder(config, nil, nil)
+	assert.Nil(t, err)
+
+	v, ok := bRaw.(builder.Validator)
+	assert.Equal(t, ok, true)
+
+	tests := []struct {
+		annotations map[string]string
+		keys        []string
+	}{
+		{
+			annotations: map[string]string{},
+		},
+		{
+			annotations: map[string]string{
+				"foo/type":      "prometheus",
+				"foo/namespace": "abc",
+				"foo/endpoints": ":8080",
+				"foo/interval":  "30s",
+			},
+		},
+		{
+			annotations: map[string]string{
+				"foo/endpoints": ":8080",
+			},
+			keys: []string{"foo/type"},
+		},
+		{
+			annotations: map[string]string{
+				"foo/type":      "promethus",
+				"foo/endpoints": ":8080",
+			},
+			keys: []string{"foo/type"},
+		},
+		{
+			annotations: map[string]string{
+				"foo/type":       "prometheus",
+				"foo/metricsets": "collector,stat",
+				"foo/namespace":  "abc",
+				"foo/timeout":    "10",
+				"foo/scheme":     "htp",
+			},
+			keys: []string{"foo/metricsets", "foo/endpoints", "foo/timeout", "foo/scheme"},
+		},
+	}
+
+	for _, test := range tests {
+		pod := &kubernetes.Pod{}
+		pod.Metadata.Name = "bar"
+		pod.Metadata.Annotations = test.annotations
+
+		var keys []string
+		for _, err := range v.Validate(pod) {
+			keys = append(keys, err.Key)
+		}
+		assert.Equal(t, test.keys, keys, "annotations: %v", test.annotations)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	dcommon "github.com/ebay/collectbeat/discoverer/common"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -38,6 +40,9 @@ var (
 
 func init() {
 	registry.BuilderRegistry.AddBuilder(SecretsBuilder, NewSecretBuilder)
+	registry.BuilderRegistry.AddBuilderSchema(SecretsBuilder, schema.Schema{
+		{Name: "prefix", Type: schema.String, Default: default_prefix, Description: "Prefix of the annotation naming the secret of a pod"},
+	})
 }
 
 // PodAnnotationBuilder implements default modules based on pod annotations
@@ -46,6 +51,13 @@ type SecretBuilder struct {
 	client *k8s.Client
 	ctx    context.Context
 	meta   metagen.MetaGen
+
+	// last holds the problems found by the last build until the pod is validated
+	last struct {
+		sync.Mutex
+		uid, version string
+		errs         []*builder.ValidationError
+	}
 }
 
 func NewSecretBuilder(cfg *common.Config, clientInfo builder.ClientInfo, meta metagen.MetaGen) (builder.Builder, error) {
@@ -84,6 +96,10 @@ func (s *SecretBuilder) Name() string {
 	return "Secret Builder"
 }
 
+func (s *SecretBuilder) Kind() string {
+	return dcommon.KindMetrics
+}
+
 func (s *SecretBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
 	holders := []*dcommon.ConfigHolder{}
 
@@ -103,31 +119,8 @@ func (s *SecretBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHol
 		return holders
 	}
 
-	secretName := kubecommon.GetAnnotation(fmt.Sprintf("%s%s", s.Prefix, secret_name), pod)
-	if secretName == "" {
-		return holders
-	}
-
-	secret, err := s.client.CoreV1().GetSecret(s.ctx, secretName, pod.Metadata.Namespace)
-	if err != nil {
-		logp.Err("Unable to get secret %s from namespace %s due to error %v", secretName,
-			pod.Metadata.Namespace, err)
-		return holders
-	}
-
-	data := secret.GetData()
-	modulesYaml, ok := data["modules"]
-	if !ok {
-		return holders
-	}
-
-	modulesCfg, err := common.NewConfigWithYAML(modulesYaml, "")
-	if err != nil {
-		return holders
-	}
-
-	modules := []*common.Config{}
-	modulesCfg.Unpack(&modules)
+	modules, errs := s.readModules(pod)
+	s.remember(pod, errs)
 
 	for _, module := range modules {
 		mCfg := &mb.ModuleConfig{}
@@ -142,7 +135,7 @@ func (s *SecretBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHol
 		rawModule := map[string]interface{}{}
 		err := module.Unpack(rawModule)
 		if err != nil {
-			logp.Err("Unable to parse config object due to error: ", err)
+			logp.Err("Unable to parse config object due to error: %v", err)
 			continue
 		}
 
@@ -154,12 +147,101 @@ func (s *SecretBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHol
 		module := common.MapStr(rawModule)
 		holder := &dcommon.ConfigHolder{
 			Config: module,
+			Meta:   kubecommon.NewPodMeta(SecretsBuilder, s.Prefix, pod),
 		}
 		holders = append(holders, holder)
 	}
 	return holders
 }
 
+// Validate checks that the secret referenced by a pod can be read and holds known modules.
+// The problems found by the last build of the same pod are reused so that validating a pod
+// right after building it does not read the secret again.
+func (s *SecretBuilder) Validate(obj interface{}) []*builder.ValidationError {
+	pod, ok := obj.(*kubernetes.Pod)
+	if !ok || kubecommon.IsNoOp(s.Prefix, pod) {
+		return nil
+	}
+
+	if errs, ok := s.recall(pod); ok {
+		return errs
+	}
+
+	_, errs := s.readModules(pod)
+	return errs
+}
+
+// readModules reads the modules of the secret referenced by pod. Modules that can be read
+// are returned together with the problems found in the secret.
+func (s *SecretBuilder) readModules(pod *kubernetes.Pod) ([]*common.Config, []*builder.ValidationError) {
+	key := fmt.Sprintf("%s%s", s.Prefix, secret_name)
+	secretName := kubecommon.GetAnnotation(key, pod)
+	if secretName == "" {
+		return nil, nil
+	}
+
+	invalid := func(format string, args ...interface{}) []*builder.ValidationError {
+		return []*builder.ValidationError{kubecommon.NewValidationError(SecretsBuilder, key, pod, format, args...)}
+	}
+
+	secret, err := s.client.CoreV1().GetSecret(s.ctx, secretName, pod.Metadata.Namespace)
+	if err != nil {
+		logp.Err("Unable to get secret %s from namespace %s due to error %v", secretName,
+			pod.Metadata.Namespace, err)
+		return nil, invalid("unable to read secret '%s': %v", secretName, err)
+	}
+
+	modulesYaml, ok := secret.GetData()["modules"]
+	if !ok {
+		return nil, invalid("secret '%s' has no 'modules' key", secretName)
+	}
+
+	modulesCfg, err := common.NewConfigWithYAML(modulesYaml, "")
+	if err != nil {
+		return nil, invalid("'modules' of secret '%s' is not valid YAML: %v", secretName, err)
+	}
+
+	modules := []*common.Config{}
+	if err = modulesCfg.Unpack(&modules); err != nil {
+		return nil, invalid("'modules' of secret '%s' must be a list of modules: %v", secretName, err)
+	}
+
+	errs := []*builder.ValidationError{}
+	for _, module := range modules {
+		mCfg := &mb.ModuleConfig{}
+		if err = module.Unpack(mCfg); err != nil {
+			errs = append(errs, invalid("invalid module in secret '%s': %v", secretName, err)...)
+			continue
+		}
+
+		if len(mb.Registry.MetricSets(mCfg.Module)) == 0 {
+			errs = append(errs, invalid("metrics type '%s' in secret '%s' is unknown", mCfg.Module, secretName)...)
+		}
+	}
+	return modules, errs
+}
+
+// remember keeps the problems found while building pod until it is validated
+func (s *SecretBuilder) remember(pod *kubernetes.Pod, errs []*builder.ValidationError) {
+	s.last.Lock()
+	defer s.last.Unlock()
+
+	s.last.uid, s.last.version, s.last.errs = pod.Metadata.UID, pod.Metadata.ResourceVersion, errs
+}
+
+// recall returns the problems remembered for the same version of pod
+func (s *SecretBuilder) recall(pod *kubernetes.Pod) ([]*builder.ValidationError, bool) {
+	s.last.Lock()
+	defer s.last.Unlock()
+
+	if s.last.uid == "" || s.last.uid != pod.Metadata.UID || s.last.version != pod.Metadata.ResourceVersion {
+		return nil, false
+	}
+
+	errs := s.last.errs
+	s.last.uid, s.last.version, s.last.errs = "", "", nil
+	return errs, true
+}
 func (s *SecretBuilder) applyHostIps(ip string, module *mb.ModuleConfig) {
 	for i := 0; i < len(module.Hosts); i++ {
 		module.Hosts[i] = strings.Replace(module.Hosts[i], host, ip, 1)
@@ -0,0 +1,123 @@
+package metrics_secret
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+	_ "github.com/elastic/beats/metricbeat/module/prometheus/collector"
+)
+
+const modules = `
+- module: prometheus
+  metricsets: ["collector"]
+  hosts: ["$HOST:9090"]
+- module: prometheus
+  metricsets: ["collector"]
+  hosts: ["$HOST:9091"]
+  period: 10s
+`
+
+func TestSecretBuilder(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+	server.AddSecret(kubetest.NewSecret("default", "metrics", map[string]string{"modules": modules}))
+
+	b := newTestSecretBuilder(t, server)
+
+	pod := newSecretPod("metrics")
+	configs := b.BuildModuleConfigs(pod)
+	if assert.Len(t, configs, 2) {
+		ip := pod.Status.PodIP
+		assert.Equal(t, []interface{}{ip + ":9090"}, configs[0].Config["hosts"])
+		assert.Equal(t, "1m0s", configs[0].Config["period"])
+		assert.Equal(t, "3s", configs[0].Config["timeout"])
+		assert.Equal(t, []interface{}{ip + ":9091"}, configs[1].Config["hosts"])
+		assert.Equal(t, "10s", configs[1].Config["period"])
+	}
+
+	// Pods without a secret or with a missing secret get no configs
+	assert.Len(t, b.BuildModuleConfigs(newSecretPod("")), 0)
+	assert.Len(t, b.BuildModuleConfigs(newSecretPod("missing")), 0)
+
+	server.Fail(kubetest.Secrets, http.StatusForbidden)
+	assert.Len(t, b.BuildModuleConfigs(pod), 0)
+}
+
+func TestSecretBuilderValidate(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+	server.AddSecret(kubetest.NewSecret("default", "valid", map[string]string{"modules": modules}))
+	server.AddSecret(kubetest.NewSecret("default", "empty", map[string]string{}))
+	server.AddSecret(kubetest.NewSecret("default", "unknown", map[string]string{"modules": "- module: promethus\n  metricsets: [\"collector\"]"}))
+
+	v := newTestSecretBuilder(t, server).(builder.Validator)
+
+	tests := []struct {
+		secret  string
+		message string
+	}{
+		{secret: "valid"},
+		{secret: "empty", message: "secret 'empty' has no 'modules' key"},
+		{secret: "unknown", message: "metrics type 'promethus' in secret 'unknown' is unknown"},
+		{secret: "missing", message: "unable to read secret 'missing'"},
+	}
+
+	for _, test := range tests {
+		errs := v.Validate(newSecretPod(test.secret))
+		if test.message == "" {
+			assert.Empty(t, errs, test.secret)
+			continue
+		}
+		if assert.Len(t, errs, 1, test.secret) {
+			assert.Contains(t, errs[0].Message, test.message)
+			assert.Equal(t, "io.collectbeat.metrics/config", errs[0].Key)
+			assert.Equal(t, test.secret, errs[0].Value)
+		}
+	}
+}
+
+func TestSecretBuilderValidateAfterBuild(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+	server.AddSecret(kubetest.NewSecret("default", "unknown", map[string]string{"modules": "- module: promethus\n  metricsets: [\"collector\"]"}))
+
+	b := newTestSecretBuilder(t, server)
+	pod := newSecretPod("unknown")
+	b.BuildModuleConfigs(pod)
+	assert.Equal(t, 1, server.Requests("GET", kubetest.Secrets))
+
+	// Validating the pod that was just built does not read the secret again
+	errs := b.(builder.Validator).Validate(pod)
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].Message, "metrics type 'promethus' in secret 'unknown' is unknown")
+	}
+	assert.Equal(t, 1, server.Requests("GET", kubetest.Secrets))
+
+	// Later validations read the secret
+	b.(builder.Validator).Validate(pod)
+	assert.Equal(t, 2, server.Requests("GET", kubetest.Secrets))
+}
+
+func newTestSecretBuilder(t *testing.T, server *kubetest.Server) builder.PollerBuilder {
+	b, err := NewSecretBuilder(common.NewConfig(), builder.ClientInfo{kubecommon.ClientKey: server.Client()}, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return b.(builder.PollerBuilder)
+}
+
+// newSecretPod returns a pod that references a secret unless it is empty
+func newSecretPod(secret string) *kubernetes.Pod {
+	pod := kubetest.NewPod("default", "web", "node-1")
+	if secret != "" {
+		pod.Metadata.Annotations["io.collectbeat.metrics/config"] = secret
+	}
+	return kubernetes.GetPodMeta(pod)
+}
@@ -1,5 +1,8 @@
 package common
 
 const (
-	ClientKey = "k8s-client"
+	ClientKey   = "k8s-client"
+	PodSpecsKey = "pod-specs"
+	// HostKey is the name of the node pods are discovered on
+	HostKey = "host"
 )
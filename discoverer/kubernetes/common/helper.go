@@ -2,13 +2,24 @@ package common
 
 import (
 	"fmt"
-
 	"strconv"
+	"strings"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+
+	corev1 "github.com/ericchiang/k8s/api/v1"
 
 	"github.com/elastic/beats/libbeat/common"
 	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
 )
 
+// PodSpecs gives builders the full spec of pods, which the pods they build configs from
+// leave parts of out. It is passed in the client info under PodSpecsKey.
+type PodSpecs interface {
+	GetPodSpec(uid string) *corev1.PodSpec
+}
+
 func GetAnnotation(key string, pod *kubernetes.Pod) string {
 	annotations := pod.Metadata.Annotations
 
@@ -56,3 +67,38 @@ func SetKubeMetadata(kubemeta, config common.MapStr) {
 		config["fields_under_root"] = true
 	}
 }
+
+// GetAnnotationsWithPrefix returns all annotations of a pod that start with the given prefix
+func GetAnnotationsWithPrefix(prefix string, pod *kubernetes.Pod) map[string]string {
+	out := map[string]string{}
+	for key, value := range pod.Metadata.Annotations {
+		if strings.HasPrefix(key, prefix) {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// NewPodMeta returns config holder meta identifying the pod and builder a config was generated from
+// along with the builder annotations that were used
+func NewPodMeta(builder, prefix string, pod *kubernetes.Pod) dcommon.Meta {
+	return dcommon.Meta{
+		dcommon.MetaBuilder:     builder,
+		dcommon.MetaPodUID:      pod.Metadata.UID,
+		dcommon.MetaPodName:     pod.Metadata.Name,
+		dcommon.MetaNamespace:   pod.Metadata.Namespace,
+		dcommon.MetaAnnotations: GetAnnotationsWithPrefix(prefix, pod),
+		dcommon.MetaLabels:      pod.Metadata.Labels,
+		dcommon.MetaPrefix:      strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// NewValidationError returns a validation error for an invalid annotation read by a builder
+func NewValidationError(builderName, annotation string, pod *kubernetes.Pod, format string, args ...interface{}) *builder.ValidationError {
+	return &builder.ValidationError{
+		Builder: builderName,
+		Key:     annotation,
+		Value:   GetAnnotation(annotation, pod),
+		Message: fmt.Sprintf(format, args...),
+	}
+}
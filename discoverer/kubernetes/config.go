@@ -14,6 +14,7 @@ type kubeDiscovererConfig struct {
 	Host               string                  `config:"host"`
 	Namespace          string                  `config:"namespace"`
 	SyncPeriod         time.Duration           `config:"sync_period"`
+	RefreshPeriod      time.Duration           `config:"refresh_period"`
 	Builders           PluginConfig            `config:"builders"`
 	DefaultBuilders    Enabled                 `config:"default_builders"`
 	Appenders          PluginConfig            `config:"appenders"`
@@ -23,6 +24,7 @@ type kubeDiscovererConfig struct {
 	IncludeLabels      []string                `config:"include_labels"`
 	ExcludeLabels      []string                `config:"exclude_labels"`
 	IncludeAnnotations []string                `config:"include_annotations"`
+	Events             eventsConfig            `config:"events"`
 }
 
 type Enabled struct {
@@ -35,10 +37,12 @@ func defaultKuberentesDiscovererConfig() kubeDiscovererConfig {
 	return kubeDiscovererConfig{
 		InCluster:        true,
 		SyncPeriod:       1 * time.Second,
+		RefreshPeriod:    1 * time.Minute,
 		Namespace:        "kube-system",
 		DefaultBuilders:  Enabled{true},
 		DefaultAppenders: Enabled{true},
 		DefaultIndexers:  Enabled{true},
+		Events:           defaultEventsConfig(),
 	}
 }
 
@@ -0,0 +1,207 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	eventComponent = "collectbeat"
+	eventReason    = "InvalidDiscoveryConfig"
+	eventTimeout   = 5 * time.Second
+)
+
+var (
+	eventsSent   = monitoring.NewInt(kubernetesMetrics, "events.sent")
+	eventsFailed = monitoring.NewInt(kubernetesMetrics, "events.failures")
+)
+
+// eventsConfig controls how discovery errors are reported on the offending pods
+type eventsConfig struct {
+	Enabled bool `config:"enabled"`
+	// Interval is the minimum time between two events with the same message for a pod
+	Interval time.Duration `config:"interval"`
+	// StatusAnnotation is set on pods to the current discovery errors when not empty
+	StatusAnnotation string `config:"status_annotation"`
+}
+
+func defaultEventsConfig() eventsConfig {
+	return eventsConfig{
+		Interval: 10 * time.Minute,
+	}
+}
+
+// eventReporter emits Kubernetes events for the validation errors of pods and optionally
+// keeps a status annotation on them up to date
+type eventReporter struct {
+	sync.Mutex
+	config eventsConfig
+	host   string
+	// sent holds the time an event was last sent for a pod UID and message
+	sent map[string]map[string]time.Time
+	now  func() time.Time
+
+	createEvent func(ctx context.Context, event *corev1.Event) error
+	annotate    func(ctx context.Context, pod *kubernetes.Pod, key, value string) error
+}
+
+func newEventReporter(client *k8s.Client, config eventsConfig, host string) *eventReporter {
+	return &eventReporter{
+		config: config,
+		host:   host,
+		sent:   map[string]map[string]time.Time{},
+		now:    time.Now,
+		createEvent: func(ctx context.Context, event *corev1.Event) error {
+			_, err := client.CoreV1().CreateEvent(ctx, event)
+			return err
+		},
+		annotate: func(ctx context.Context, pod *kubernetes.Pod, key, value string) error {
+			return annotatePod(ctx, client, pod, key, value)
+		},
+	}
+}
+
+// Report sends an event for every error that was not reported for the pod within the
+// configured interval and updates the status annotation
+func (e *eventReporter) Report(ctx context.Context, pod *kubernetes.Pod, errs []*builder.ValidationError) {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", eventComponent, err.Error()))
+	}
+
+	for _, message := range e.due(pod.Metadata.UID, messages) {
+		reqCtx, cancel := context.WithTimeout(ctx, eventTimeout)
+		err := e.createEvent(reqCtx, e.newEvent(pod, message))
+		cancel()
+
+		if err != nil {
+			eventsFailed.Inc()
+			logp.Warn("kubernetes: Unable to create event for pod %s/%s due to error: %v",
+				pod.Metadata.Namespace, pod.Metadata.Name, err)
+			continue
+		}
+		eventsSent.Inc()
+		debug("Reported '%s' on pod %s/%s", message, pod.Metadata.Namespace, pod.Metadata.Name)
+	}
+
+	if key := e.config.StatusAnnotation; key != "" {
+		status := strings.Join(messages, "; ")
+		if pod.Metadata.Annotations[key] == status {
+			return
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, eventTimeout)
+		defer cancel()
+		if err := e.annotate(reqCtx, pod, key, status); err != nil {
+			logp.Warn("kubernetes: Unable to set %s on pod %s/%s due to error: %v", key,
+				pod.Metadata.Namespace, pod.Metadata.Name, err)
+		}
+	}
+}
+
+// Forget drops the rate limiting state of a deleted pod
+func (e *eventReporter) Forget(uid string) {
+	e.Lock()
+	defer e.Unlock()
+
+	delete(e.sent, uid)
+}
+
+// due returns the messages that may be sent for a pod now and records them as sent.
+// Messages that are no longer reported are dropped so that they are sent again as soon as
+// they reappear.
+func (e *eventReporter) due(uid string, messages []string) []string {
+	e.Lock()
+	defer e.Unlock()
+
+	now := e.now()
+	last := e.sent[uid]
+	current := make(map[string]time.Time, len(messages))
+	due := []string{}
+	for _, message := range messages {
+		if sent, ok := last[message]; ok && now.Sub(sent) < e.config.Interval {
+			current[message] = sent
+			continue
+		}
+		current[message] = now
+		due = append(due, message)
+	}
+
+	if len(current) == 0 {
+		delete(e.sent, uid)
+	} else {
+		e.sent[uid] = current
+	}
+	return due
+}
+
+func (e *eventReporter) newEvent(pod *kubernetes.Pod, message string) *corev1.Event {
+	now := e.now()
+	seconds := now.Unix()
+	timestamp := &metav1.Time{Seconds: &seconds}
+	count := int32(1)
+
+	return &corev1.Event{
+		Metadata: &metav1.ObjectMeta{
+			Name:      k8s.String(pod.Metadata.Name + "." + strconv.FormatInt(now.UnixNano(), 16)),
+			Namespace: k8s.String(pod.Metadata.Namespace),
+		},
+		InvolvedObject: &corev1.ObjectReference{
+			Kind:            k8s.String("Pod"),
+			ApiVersion:      k8s.String("v1"),
+			Namespace:       k8s.String(pod.Metadata.Namespace),
+			Name:            k8s.String(pod.Metadata.Name),
+			Uid:             k8s.String(pod.Metadata.UID),
+			ResourceVersion: k8s.String(pod.Metadata.ResourceVersion),
+		},
+		Reason:  k8s.String(eventReason),
+		Message: k8s.String(message),
+		Source: &corev1.EventSource{
+			Component: k8s.String(eventComponent),
+			Host:      k8s.String(e.host),
+		},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          &count,
+		Type:           k8s.String("Warning"),
+	}
+}
+
+// annotatePod sets an annotation on the latest version of a pod or removes it if value is empty
+func annotatePod(ctx context.Context, client *k8s.Client, pod *kubernetes.Pod, key, value string) error {
+	current, err := client.CoreV1().GetPod(ctx, pod.Metadata.Name, pod.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+
+	annotations := current.GetMetadata().GetAnnotations()
+	if annotations[key] == value {
+		return nil
+	}
+
+	if value == "" {
+		delete(annotations, key)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	current.Metadata.Annotations = annotations
+
+	_, err = client.CoreV1().UpdatePod(ctx, current)
+	return err
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/api/v1"
+	"github.com/stretchr/testify/assert"
+
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+func TestEventReporterRateLimit(t *testing.T) {
+	reporter, events, _ := newTestEventReporter(eventsConfig{Interval: time.Minute})
+	now := time.Unix(1000, 0)
+	reporter.now = func() time.Time { return now }
+
+	pod := kubernetes.GetPodMeta(newTestPod(1))
+	unknown := &builder.ValidationError{Key: "io.collectbeat.metrics/type", Message: "metrics type 'promethus' is unknown"}
+	duration := &builder.ValidationError{Key: "io.collectbeat.metrics/interval", Message: "'1x' is not a valid duration"}
+
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{unknown})
+	if assert.Len(t, *events, 1) {
+		event := (*events)[0]
+		assert.Equal(t, "collectbeat: io.collectbeat.metrics/type: metrics type 'promethus' is unknown", event.GetMessage())
+		assert.Equal(t, "Warning", event.GetType())
+		assert.Equal(t, "Pod", event.GetInvolvedObject().GetKind())
+		assert.Equal(t, "pod-1", event.GetInvolvedObject().GetName())
+		assert.Equal(t, "default", event.GetMetadata().GetNamespace())
+	}
+
+	// The same error is not reported again within the interval, a new one is
+	now = now.Add(30 * time.Second)
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{unknown, duration})
+	assert.Len(t, *events, 2)
+
+	now = now.Add(45 * time.Second)
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{unknown, duration})
+	assert.Len(t, *events, 3)
+
+	// Errors that were fixed are reported again as soon as they come back
+	reporter.Report(context.Background(), pod, nil)
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{unknown})
+	assert.Len(t, *events, 4)
+
+	reporter.Forget(pod.Metadata.UID)
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{unknown})
+	assert.Len(t, *events, 5)
+}
+
+func TestEventReporterStatusAnnotation(t *testing.T) {
+	reporter, _, annotations := newTestEventReporter(eventsConfig{
+		Interval:         time.Minute,
+		StatusAnnotation: "io.collectbeat/status",
+	})
+	reporter.createEvent = func(context.Context, *corev1.Event) error {
+		return errors.New("events are forbidden")
+	}
+
+	pod := kubernetes.GetPodMeta(newTestPod(1))
+	invalid := &builder.ValidationError{Key: "io.collectbeat.logs/pattern", Message: "invalid regex"}
+
+	// The annotation is set even if events can not be created
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{invalid})
+	assert.Equal(t, "collectbeat: io.collectbeat.logs/pattern: invalid regex", annotations["pod-1"])
+
+	// Nothing is updated if the pod already carries the status
+	pod.Metadata.Annotations = map[string]string{"io.collectbeat/status": annotations["pod-1"]}
+	delete(annotations, "pod-1")
+	reporter.Report(context.Background(), pod, []*builder.ValidationError{invalid})
+	_, updated := annotations["pod-1"]
+	assert.False(t, updated)
+
+	// The annotation is cleared once the pod is fixed
+	reporter.Report(context.Background(), pod, nil)
+	value, updated := annotations["pod-1"]
+	assert.True(t, updated)
+	assert.Equal(t, "", value)
+
+	// Pods without problems and without annotation are left alone
+	other := kubernetes.GetPodMeta(newTestPod(2))
+	reporter.Report(context.Background(), other, nil)
+	_, updated = annotations["pod-2"]
+	assert.False(t, updated)
+}
+
+func TestPodWatcherReportsValidationErrors(t *testing.T) {
+	watcher, _ := newTestPodWatcher()
+	watcher.builders.AddBuilder(&invalidBuilder{})
+
+	reporter, events, _ := newTestEventReporter(defaultEventsConfig())
+	watcher.events = reporter
+
+	pod := newTestPod(1)
+	watcher.process(pod)
+	assert.Len(t, *events, 1)
+	assert.Contains(t, reporter.sent, "pod-1")
+
+	// Pod updates do not reset rate limiting, deleting the pod does
+	updated := newTestPod(1)
+	updated.Metadata.ResourceVersion = k8s.String("2")
+	watcher.process(updated)
+	assert.Len(t, *events, 1)
+
+	watcher.process(deletedPod(kubernetes.GetPodMeta(updated)))
+	assert.NotContains(t, reporter.sent, "pod-1")
+}
+
+func newTestEventReporter(config eventsConfig) (*eventReporter, *[]*corev1.Event, map[string]string) {
+	events := &[]*corev1.Event{}
+	annotations := map[string]string{}
+
+	reporter := &eventReporter{
+		config: config,
+		host:   "node-1",
+		sent:   map[string]map[string]time.Time{},
+		now:    time.Now,
+		createEvent: func(_ context.Context, event *corev1.Event) error {
+			*events = append(*events, event)
+			return nil
+		},
+		annotate: func(_ context.Context, pod *kubernetes.Pod, key, value string) error {
+			annotations[pod.Metadata.Name] = value
+			return nil
+		},
+	}
+	return reporter, events, annotations
+}
+
+// invalidBuilder reports every pod as invalid
+type invalidBuilder struct{}
+
+func (b *invalidBuilder) Name() string { return "invalid" }
+
+func (b *invalidBuilder) Validate(obj interface{}) []*builder.ValidationError {
+	return []*builder.ValidationError{{Builder: "invalid", Message: "always invalid"}}
+}
@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/ebay/collectbeat/discoverer"
 	"github.com/ebay/collectbeat/discoverer/common/appender"
 	"github.com/ebay/collectbeat/discoverer/common/builder"
 	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
 	kubecommon "github.com/ebay/collectbeat/discoverer/kubernetes/common"
 
 	"github.com/elastic/beats/libbeat/common"
@@ -20,6 +23,7 @@ import (
 
 	"github.com/ericchiang/k8s"
 	"github.com/ghodss/yaml"
+	"github.com/mitchellh/hashstructure"
 )
 
 const (
@@ -32,13 +36,40 @@ var (
 )
 
 type kubernetesDiscoverer struct {
+	sync.Mutex
 	podWatcher *PodWatcher
 	builders   []builder.Builder
 	appenders  []appender.Appender
+	// names holds the registry names of builders
+	names      map[builder.Builder]string
+	clientInfo builder.ClientInfo
+	// plugins holds the builders and appenders by name and config so that reloads keep the
+	// ones whose config did not change
+	plugins map[string]interface{}
 }
 
 func init() {
 	discoverer.RegisterDiscovererPlugin("kubernetes", newKubernetesDiscoverer)
+	discoverer.RegisterDiscovererSchema("kubernetes", schema.Schema{
+		{Name: "in_cluster", Type: schema.Bool, Default: true, Description: "Use the service account of the pod to connect to the API server"},
+		{Name: "kube_config", Type: schema.String, Description: "Path to the kubeconfig used when not running in cluster"},
+		{Name: "host", Type: schema.String, Description: "Node to discover pods on, defaults to the node of the collectbeat pod"},
+		{Name: "namespace", Type: schema.String, Default: "kube-system", Description: "Namespace of the collectbeat pod"},
+		{Name: "sync_period", Type: schema.Duration, Default: "1s", Description: "Period of full pod resyncs"},
+		{Name: "refresh_period", Type: schema.Duration, Default: "1m", Description: "Period of checks for appenders whose output changed, such as rotated credentials"},
+		{Name: "builders", Type: schema.List, Description: "Builders to run, by name and config"},
+		{Name: "default_builders.enabled", Type: schema.Bool, Default: true, Description: "Run the builders enabled by default"},
+		{Name: "appenders", Type: schema.List, Description: "Appenders to run, by name and config"},
+		{Name: "default_appenders.enabled", Type: schema.Bool, Default: true, Description: "Run the appenders enabled by default"},
+		{Name: "indexers", Type: schema.List, Description: "Indexers used for pod metadata"},
+		{Name: "default_indexers.enabled", Type: schema.Bool, Default: true, Description: "Run the indexers enabled by default"},
+		{Name: "include_labels", Type: schema.List, Description: "Pod labels added to the metadata"},
+		{Name: "exclude_labels", Type: schema.List, Description: "Pod labels left out of the metadata"},
+		{Name: "include_annotations", Type: schema.List, Description: "Pod annotations added to the metadata"},
+		{Name: "events.enabled", Type: schema.Bool, Default: false, Description: "Report invalid discovery settings as events on pods"},
+		{Name: "events.interval", Type: schema.Duration, Default: "10m", Description: "Minimum time between two identical events for a pod"},
+		{Name: "events.status_annotation", Type: schema.String, Description: "Annotation kept up to date with the discovery errors of a pod"},
+	})
 }
 
 func newKubernetesDiscoverer(cfg *common.Config) (discoverer.Discoverer, error) {
@@ -49,26 +80,7 @@ func newKubernetesDiscoverer(cfg *common.Config) (discoverer.Discoverer, error)
 		return nil, fmt.Errorf("fail to unpack the kubernetes configuration: %s", err)
 	}
 
-	//Load default builder configs
-	if config.DefaultBuilders.Enabled == true {
-		registry.BuilderRegistry.RLock()
-		for key, cfg := range registry.BuilderRegistry.GetDefaultBuilderConfigs() {
-			config.Builders = append(config.Builders, map[string]*common.Config{key: &cfg})
-		}
-		registry.BuilderRegistry.RUnlock()
-	}
-
-	//Load default builder configs
-	if config.DefaultAppenders.Enabled == true {
-		registry.BuilderRegistry.RLock()
-		for key, cfg := range registry.BuilderRegistry.GetDefaultAppenderConfigs() {
-			config.Appenders = append(config.Appenders, map[string]*common.Config{key: &cfg})
-		}
-		registry.BuilderRegistry.RUnlock()
-	}
-
-	builders := []builder.Builder{}
-	appenders := []appender.Appender{}
+	loadDefaultPlugins(&config)
 
 	var client *k8s.Client
 	if config.InCluster == true {
@@ -102,7 +114,7 @@ func newKubernetesDiscoverer(cfg *common.Config) (discoverer.Discoverer, error)
 		} else {
 			pod, error := client.CoreV1().GetPod(ctx, podName, config.Namespace)
 			if error != nil {
-				logp.Err("Querying for pod failed with error: ", error.Error())
+				logp.Err("Querying for pod failed with error: %v", error.Error())
 				logp.Info("Unable to find pod, setting host to localhost")
 				config.Host = "localhost"
 			} else {
@@ -128,64 +140,174 @@ func newKubernetesDiscoverer(cfg *common.Config) (discoverer.Discoverer, error)
 	debug("kubernetes", "Initializing watcher")
 	if client != nil {
 		watcher := NewPodWatcher(client, indexers, config.SyncPeriod, config.Host)
+		watcher.refreshPeriod = config.RefreshPeriod
+		if config.Events.Enabled {
+			watcher.events = newEventReporter(client, config.Events, config.Host)
+		}
 
-		clientInfo := builder.ClientInfo{
-			kubecommon.ClientKey: client,
+		k := &kubernetesDiscoverer{
+			podWatcher: watcher,
+			clientInfo: builder.ClientInfo{
+				kubecommon.ClientKey:   client,
+				kubecommon.PodSpecsKey: watcher,
+				kubecommon.HostKey:     config.Host,
+			},
+			plugins: map[string]interface{}{},
 		}
-		//Create all configured builders
-		for _, pluginConfigs := range config.Builders {
-			for name, pluginConfig := range pluginConfigs {
-				indexFunc := registry.BuilderRegistry.GetBuilder(name)
-				if indexFunc == nil {
-					logp.Warn("Unable to find builder plugin %s", name)
-					continue
-				}
 
-				builder, err := indexFunc(pluginConfig, clientInfo, watcher)
-				if err != nil {
-					logp.Warn("Unable to initialize indexing plugin %s due to error %v", name, err)
-					continue
-				}
+		k.builders, k.names, k.appenders, k.plugins = k.createPlugins(config)
+		if len(k.builders) == 0 {
+			return nil, fmt.Errorf("Can not initialize kubernetes plugin with zero builder plugins")
+		}
 
-				if builder != nil {
-					builders = append(builders, builder)
-				}
+		return k, nil
+	}
 
-			}
+	return nil, fatalError
+}
+
+// loadDefaultPlugins adds the default builder and appender configs if they are enabled. They
+// are added by name after the configured ones so that appenders of the same order always run
+// in the same order.
+func loadDefaultPlugins(config *kubeDiscovererConfig) {
+	if config.DefaultBuilders.Enabled == true {
+		registry.BuilderRegistry.RLock()
+		config.Builders = append(config.Builders, sortedPlugins(registry.BuilderRegistry.GetDefaultBuilderConfigs())...)
+		registry.BuilderRegistry.RUnlock()
+	}
+
+	if config.DefaultAppenders.Enabled == true {
+		registry.BuilderRegistry.RLock()
+		config.Appenders = append(config.Appenders, sortedPlugins(registry.BuilderRegistry.GetDefaultAppenderConfigs())...)
+		registry.BuilderRegistry.RUnlock()
+	}
+}
+
+// sortedPlugins returns plugin configs sorted by name
+func sortedPlugins(configs map[string]common.Config) PluginConfig {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	plugins := PluginConfig{}
+	for _, name := range names {
+		cfg := configs[name]
+		plugins = append(plugins, map[string]*common.Config{name: &cfg})
+	}
+	return plugins
+}
+
+// createPlugins creates the configured builders, with their registry names, and appenders.
+// Plugins that were created before with the same name and config are reused.
+func (k *kubernetesDiscoverer) createPlugins(config kubeDiscovererConfig) ([]builder.Builder, map[builder.Builder]string, []appender.Appender, map[string]interface{}) {
+	builders := []builder.Builder{}
+	names := map[builder.Builder]string{}
+	appenders := []appender.Appender{}
+	plugins := map[string]interface{}{}
+
+	// reuse returns an existing plugin for a config unless it was already used in this round
+	reuse := func(kind, name string, cfg *common.Config) (string, interface{}) {
+		key := pluginKey(kind, name, cfg)
+		if _, used := plugins[key]; used || key == "" {
+			return "", nil
 		}
+		return key, k.plugins[key]
+	}
 
-		//Create all configured appenders
-		for _, pluginConfigs := range config.Appenders {
-			for name, pluginConfig := range pluginConfigs {
-				indexFunc := registry.BuilderRegistry.GetAppender(name)
-				if indexFunc == nil {
-					logp.Warn("Unable to find appender plugin %s", name)
-					continue
-				}
+	//Create all configured builders
+	for _, pluginConfigs := range config.Builders {
+		for name, pluginConfig := range pluginConfigs {
+			key, existing := reuse("builder", name, pluginConfig)
+			if existing != nil {
+				builders = append(builders, existing.(builder.Builder))
+				names[existing.(builder.Builder)] = name
+				plugins[key] = existing
+				continue
+			}
 
-				appender, err := indexFunc(pluginConfig)
-				if err != nil {
-					logp.Warn("Unable to initialize appender plugin %s due to error %v", name, err)
-				}
+			indexFunc := registry.BuilderRegistry.GetBuilder(name)
+			if indexFunc == nil {
+				logp.Warn("Unable to find builder plugin %s", name)
+				continue
+			}
 
-				appenders = append(appenders, appender)
+			builder, err := indexFunc(pluginConfig, k.clientInfo, k.podWatcher)
+			if err != nil {
+				logp.Warn("Unable to initialize indexing plugin %s due to error %v", name, err)
+				continue
+			}
 
+			if builder != nil {
+				builders = append(builders, builder)
+				names[builder] = name
+				if key != "" {
+					plugins[key] = builder
+				}
 			}
 		}
+	}
 
-		if len(builders) == 0 {
-			return nil, fmt.Errorf("Can not initialize kubernetes plugin with zero builder plugins")
+	//Create all configured appenders
+	for _, pluginConfigs := range config.Appenders {
+		for name, pluginConfig := range pluginConfigs {
+			key, existing := reuse("appender", name, pluginConfig)
+			if existing != nil {
+				appenders = append(appenders, existing.(appender.Appender))
+				plugins[key] = existing
+				continue
+			}
+
+			indexFunc := registry.BuilderRegistry.GetClientAppender(name)
+			if indexFunc == nil {
+				logp.Warn("Unable to find appender plugin %s", name)
+				continue
+			}
+
+			a, err := indexFunc(pluginConfig, k.clientInfo)
+			if err == nil {
+				a, err = appender.Configure(a, pluginConfig)
+			}
+			if err != nil {
+				logp.Warn("Unable to initialize appender plugin %s due to error %v", name, err)
+				continue
+			}
+
+			appenders = append(appenders, a)
+			if key != "" {
+				plugins[key] = a
+			}
 		}
+	}
 
-		return &kubernetesDiscoverer{podWatcher: watcher, builders: builders, appenders: appenders}, nil
+	return builders, names, appenders, plugins
+}
+
+// pluginKey identifies a plugin by its name and config. It is empty if the config can not be
+// hashed.
+func pluginKey(kind, name string, cfg *common.Config) string {
+	raw := map[string]interface{}{}
+	if cfg != nil {
+		if err := cfg.Unpack(&raw); err != nil {
+			return ""
+		}
 	}
 
-	return nil, fatalError
+	hash, err := hashstructure.Hash(raw, nil)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s/%d", kind, name, hash)
 }
 
-func (k *kubernetesDiscoverer) Start(builders *discoverer.Builders) {
+func (k *kubernetesDiscoverer) Start(ctx context.Context, builders *discoverer.Builders) {
+	k.Lock()
+	defer k.Unlock()
+
 	for _, builder := range k.builders {
 		builders.AddBuilder(builder)
+		builders.SetName(builder, k.names[builder])
 	}
 
 	for _, appender := range k.appenders {
@@ -193,11 +315,45 @@ func (k *kubernetesDiscoverer) Start(builders *discoverer.Builders) {
 	}
 
 	k.podWatcher.builders = builders
-	k.podWatcher.Run()
+	k.podWatcher.Run(ctx)
+}
+
+// Reload replaces the builders and appenders with the ones of cfg. Only configs generated by
+// builders or appenders that changed are restarted. Other settings need a restart of the beat.
+func (k *kubernetesDiscoverer) Reload(cfg *common.Config) error {
+	config := defaultKuberentesDiscovererConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return fmt.Errorf("fail to unpack the kubernetes configuration: %s", err)
+	}
+	loadDefaultPlugins(&config)
+
+	k.Lock()
+	defer k.Unlock()
+
+	builders, names, appenders, plugins := k.createPlugins(config)
+	if len(builders) == 0 {
+		return fmt.Errorf("Can not reload kubernetes plugin with zero builder plugins")
+	}
+
+	k.builders, k.names, k.appenders, k.plugins = builders, names, appenders, plugins
+	k.podWatcher.reload(builders, names, appenders)
+	return nil
 }
 
 func (k *kubernetesDiscoverer) Stop() {
 	k.podWatcher.Stop()
 }
 
+// Status lists the pods known to the discoverer
+func (k *kubernetesDiscoverer) Status() interface{} {
+	return common.MapStr{
+		"host": k.podWatcher.host,
+		"pods": k.podWatcher.Status(),
+	}
+}
+
+func (k *kubernetesDiscoverer) Resync() error {
+	return k.podWatcher.Resync()
+}
+
 func (k *kubernetesDiscoverer) String() string { return "kubernetes" }
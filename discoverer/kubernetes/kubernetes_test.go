@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ebay/collectbeat/discoverer"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/factory/dryrun"
+	"github.com/ebay/collectbeat/discoverer/common/metagen"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func init() {
+	registry.BuilderRegistry.AddBuilder("pod_builder", func(*common.Config, builder.ClientInfo, metagen.MetaGen) (builder.Builder, error) {
+		return &podBuilder{}, nil
+	})
+}
+
+func TestNewKubernetesDiscoverer(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	server.AddPod(kubetest.NewPod("kube-system", "collectbeat-1", "node-1"))
+	server.AddPod(kubetest.NewPod("default", "web", "node-1"))
+	server.AddPod(kubetest.NewPod("default", "db", "node-2"))
+
+	dir, err := ioutil.TempDir("", "kubernetes")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	kubeConfig := filepath.Join(dir, "kubeconfig")
+	assert.Nil(t, server.WriteKubeConfig(kubeConfig))
+
+	hostname := os.Getenv("HOSTNAME")
+	defer os.Setenv("HOSTNAME", hostname)
+
+	// The node is looked up from the pod collectbeat runs in
+	os.Setenv("HOSTNAME", "collectbeat-1")
+	d, err := newKubernetesDiscoverer(discovererConfig(t, kubeConfig))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	k := d.(*kubernetesDiscoverer)
+	assert.Equal(t, "node-1", k.podWatcher.host)
+
+	recorder := dryrun.New()
+	builders := discoverer.NewBuilder(nil, nil)
+	builders.SetFactory(recorder)
+
+	d.Start(context.Background(), builders)
+	waitFor(t, func() bool { return len(podNames(recorder)) == 2 })
+	assert.Equal(t, []string{"collectbeat-1", "web"}, podNames(recorder))
+
+	status := k.Status().(common.MapStr)
+	assert.Equal(t, "node-1", status["host"])
+	assert.Len(t, status["pods"], 2)
+	d.Stop()
+
+	// Pods that can not be found fall back to localhost
+	os.Setenv("HOSTNAME", "collectbeat-2")
+	d, err = newKubernetesDiscoverer(discovererConfig(t, kubeConfig))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "localhost", d.(*kubernetesDiscoverer).podWatcher.host)
+	}
+}
+
+func TestKubernetesDiscovererReload(t *testing.T) {
+	watcher, _ := newTestPodWatcher()
+	k := &kubernetesDiscoverer{podWatcher: watcher, plugins: map[string]interface{}{}}
+
+	config := reloadConfig(t, map[string]interface{}{
+		"builders": []map[string]interface{}{
+			{"log_annotations": map[string]interface{}{}},
+			{"graphite_annotations": map[string]interface{}{"config": map[string]interface{}{}}},
+		},
+	})
+
+	assert.Nil(t, k.Reload(config))
+	assert.Len(t, k.builders, 2)
+	logs, graphite := k.builders[0], k.builders[1]
+
+	// Unchanged builders are kept, changed ones are created again
+	config = reloadConfig(t, map[string]interface{}{
+		"builders": []map[string]interface{}{
+			{"log_annotations": map[string]interface{}{}},
+			{"graphite_annotations": map[string]interface{}{"config": map[string]interface{}{"protocol": "udp"}}},
+		},
+	})
+	assert.Nil(t, k.Reload(config))
+	if assert.Len(t, k.builders, 2) {
+		assert.True(t, logs == k.builders[0])
+		assert.False(t, graphite == k.builders[1])
+	}
+
+	// A config without builders is rejected and keeps the current ones
+	current := k.builders
+	assert.NotNil(t, k.Reload(reloadConfig(t, map[string]interface{}{})))
+	assert.Equal(t, current, k.builders)
+
+	// Once started, configs of removed builders are stopped
+	recorder := dryrun.New()
+	builders := discoverer.NewBuilder(k.builders, k.appenders)
+	builders.SetFactory(recorder)
+	watcher.builders = builders
+	watcher.process(newTestPod(1))
+	assert.Equal(t, 1, recorder.Len())
+
+	config = reloadConfig(t, map[string]interface{}{
+		"builders": []map[string]interface{}{
+			{"log_annotations": map[string]interface{}{}},
+		},
+	})
+	assert.Nil(t, k.Reload(config))
+	assert.Equal(t, 0, recorder.Len())
+}
+
+// discovererConfig returns a config using the kubeconfig of a fake API server and the test builder
+func discovererConfig(t *testing.T, kubeConfig string) *common.Config {
+	return reloadConfig(t, map[string]interface{}{
+		"in_cluster":  false,
+		"kube_config": kubeConfig,
+		"builders": []map[string]interface{}{
+			{"pod_builder": map[string]interface{}{}},
+		},
+	})
+}
+
+func reloadConfig(t *testing.T, raw map[string]interface{}) *common.Config {
+	raw["default_builders.enabled"] = false
+	raw["default_appenders.enabled"] = false
+
+	cfg, err := common.NewConfigFrom(raw)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return cfg
+}
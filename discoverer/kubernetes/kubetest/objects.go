@@ -0,0 +1,111 @@
+package kubetest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// NewPod returns a running pod scheduled on node with one container named after the pod
+func NewPod(namespace, name, node string) *corev1.Pod {
+	return &corev1.Pod{
+		Metadata: &metav1.ObjectMeta{
+			Name:        k8s.String(name),
+			Namespace:   k8s.String(namespace),
+			Uid:         k8s.String(namespace + "-" + name),
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: &corev1.PodSpec{
+			NodeName: k8s.String(node),
+			Containers: []*corev1.Container{
+				{Name: k8s.String(name)},
+			},
+		},
+		Status: &corev1.PodStatus{
+			Phase: k8s.String("Running"),
+			PodIP: k8s.String(podIP(namespace + "/" + name)),
+			ContainerStatuses: []*corev1.ContainerStatus{
+				{
+					Name:        k8s.String(name),
+					Ready:       k8s.Bool(true),
+					ContainerID: k8s.String("docker://" + namespace + "-" + name),
+				},
+			},
+		},
+	}
+}
+
+// NewSecret returns an opaque secret holding data
+func NewSecret(namespace, name string, data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{
+		Metadata: &metav1.ObjectMeta{
+			Name:      k8s.String(name),
+			Namespace: k8s.String(namespace),
+		},
+		Type: k8s.String("Opaque"),
+		Data: map[string][]byte{},
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+// NewNode returns a node
+func NewNode(name string) *corev1.Node {
+	return &corev1.Node{
+		Metadata: &metav1.ObjectMeta{
+			Name:   k8s.String(name),
+			Labels: map[string]string{"kubernetes.io/hostname": name},
+		},
+	}
+}
+
+// NewNamespace returns a namespace
+func NewNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		Metadata: &metav1.ObjectMeta{
+			Name: k8s.String(name),
+		},
+	}
+}
+
+// NewClaim returns a persistent volume claim bound to volume. An empty volume leaves the
+// claim pending.
+func NewClaim(namespace, name, volume string) *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		Metadata: &metav1.ObjectMeta{
+			Name:      k8s.String(name),
+			Namespace: k8s.String(namespace),
+		},
+		Spec:   &corev1.PersistentVolumeClaimSpec{},
+		Status: &corev1.PersistentVolumeClaimStatus{Phase: k8s.String("Pending")},
+	}
+	if volume != "" {
+		claim.Spec.VolumeName = k8s.String(volume)
+		claim.Status.Phase = k8s.String("Bound")
+	}
+	return claim
+}
+
+// podIP derives a stable pod IP from the pod's key
+func podIP(key string) string {
+	sum := 0
+	for _, c := range key {
+		sum = (sum*31 + int(c)) % 65024
+	}
+	return fmt.Sprintf("10.%d.%d.%d", 244, sum/254, sum%254+1)
+}
+
+func sortByVersion(objs []object) {
+	sort.Slice(objs, func(i, j int) bool {
+		vi, _ := strconv.Atoi(objs[i].GetMetadata().GetResourceVersion())
+		vj, _ := strconv.Atoi(objs[j].GetMetadata().GetResourceVersion())
+		return vi < vj
+	})
+}
@@ -0,0 +1,796 @@
+// Package kubetest provides an in-process fake of the Kubernetes API server for tests. It
+// speaks the protobuf wire format of github.com/ericchiang/k8s for pods, secrets, nodes,
+// namespaces and events and lets tests script object lifecycles and watch failures.
+package kubetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	"github.com/ericchiang/k8s/api/unversioned"
+	corev1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/ericchiang/k8s/runtime"
+	"github.com/ericchiang/k8s/watch/versioned"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	Pods       = "pods"
+	Secrets    = "secrets"
+	Nodes      = "nodes"
+	Namespaces = "namespaces"
+	Events     = "events"
+	Claims     = "persistentvolumeclaims"
+
+	// watchBuffer is the number of events a watch can fall behind before it is dropped
+	watchBuffer = 1000
+)
+
+var magicBytes = []byte{0x6b, 0x38, 0x73, 0x00}
+
+// object is implemented by all Kubernetes API objects
+type object interface {
+	proto.Message
+	GetMetadata() *metav1.ObjectMeta
+}
+
+// resource describes how objects of an API resource are stored and listed
+type resource struct {
+	namespaced bool
+	new        func() object
+	list       func(items []object, meta *metav1.ListMeta) proto.Message
+}
+
+var resources = map[string]resource{
+	Pods: {
+		namespaced: true,
+		new:        func() object { return &corev1.Pod{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.PodList{Metadata: meta, Items: []*corev1.Pod{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.Pod))
+			}
+			return list
+		},
+	},
+	Secrets: {
+		namespaced: true,
+		new:        func() object { return &corev1.Secret{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.SecretList{Metadata: meta, Items: []*corev1.Secret{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.Secret))
+			}
+			return list
+		},
+	},
+	Nodes: {
+		new: func() object { return &corev1.Node{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.NodeList{Metadata: meta, Items: []*corev1.Node{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.Node))
+			}
+			return list
+		},
+	},
+	Namespaces: {
+		new: func() object { return &corev1.Namespace{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.NamespaceList{Metadata: meta, Items: []*corev1.Namespace{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.Namespace))
+			}
+			return list
+		},
+	},
+	Events: {
+		namespaced: true,
+		new:        func() object { return &corev1.Event{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.EventList{Metadata: meta, Items: []*corev1.Event{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.Event))
+			}
+			return list
+		},
+	},
+	Claims: {
+		namespaced: true,
+		new:        func() object { return &corev1.PersistentVolumeClaim{} },
+		list: func(items []object, meta *metav1.ListMeta) proto.Message {
+			list := &corev1.PersistentVolumeClaimList{Metadata: meta, Items: []*corev1.PersistentVolumeClaim{}}
+			for _, item := range items {
+				list.Items = append(list.Items, item.(*corev1.PersistentVolumeClaim))
+			}
+			return list
+		},
+	},
+}
+
+// Server is a fake Kubernetes API server. Objects are kept in memory, every change gets a
+// new resource version and is sent to the matching watches.
+type Server struct {
+	sync.Mutex
+	server   *httptest.Server
+	version  int
+	uids     int
+	objects  map[string]map[string]object // resource -> namespace/name -> object
+	watches  map[*watch]struct{}
+	failures map[string]int
+	requests map[string]int
+	changed  *sync.Cond
+}
+
+// watch is an open watch request
+type watch struct {
+	resource  string
+	namespace string
+	selector  selector
+	events    chan *versioned.Event
+	drop      chan struct{}
+}
+
+// NewServer starts a fake API server without any objects
+func NewServer() *Server {
+	s := &Server{
+		objects:  map[string]map[string]object{},
+		watches:  map[*watch]struct{}{},
+		failures: map[string]int{},
+		requests: map[string]int{},
+	}
+	for name := range resources {
+		s.objects[name] = map[string]object{}
+	}
+	s.changed = sync.NewCond(&s.Mutex)
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close drops all watches and shuts the server down
+func (s *Server) Close() {
+	s.DropWatches()
+	s.server.Close()
+}
+
+// URL is the endpoint of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Client returns a client talking to the server
+func (s *Server) Client() *k8s.Client {
+	return &k8s.Client{
+		Endpoint:  s.server.URL,
+		Namespace: "default",
+		Client:    &http.Client{},
+	}
+}
+
+// KubeConfig returns a kubeconfig pointing to the server
+func (s *Server) KubeConfig() []byte {
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+users:
+- name: fake
+  user:
+    token: fake
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: fake
+current-context: fake
+`, s.server.URL))
+}
+
+// WriteKubeConfig writes the kubeconfig of the server to path
+func (s *Server) WriteKubeConfig(path string) error {
+	return ioutil.WriteFile(path, s.KubeConfig(), 0600)
+}
+
+// AddPod creates or replaces a pod and notifies watches
+func (s *Server) AddPod(pod *corev1.Pod) *corev1.Pod {
+	return s.put(Pods, pod, true).(*corev1.Pod)
+}
+
+// UpdatePod replaces a pod and notifies watches. It is the same as AddPod and reads better
+// when scripting a pod lifecycle.
+func (s *Server) UpdatePod(pod *corev1.Pod) *corev1.Pod {
+	return s.AddPod(pod)
+}
+
+// DeletePod removes a pod and notifies watches
+func (s *Server) DeletePod(namespace, name string) bool {
+	return s.remove(Pods, namespace, name, true)
+}
+
+// ForgetPod removes a pod without notifying watches, as if the delete event was missed
+func (s *Server) ForgetPod(namespace, name string) bool {
+	return s.remove(Pods, namespace, name, false)
+}
+
+// Pod returns a copy of a stored pod or nil
+func (s *Server) Pod(namespace, name string) *corev1.Pod {
+	if obj := s.get(Pods, namespace, name); obj != nil {
+		return obj.(*corev1.Pod)
+	}
+	return nil
+}
+
+// AddSecret creates or replaces a secret
+func (s *Server) AddSecret(secret *corev1.Secret) *corev1.Secret {
+	return s.put(Secrets, secret, true).(*corev1.Secret)
+}
+
+// AddNode creates or replaces a node
+func (s *Server) AddNode(node *corev1.Node) *corev1.Node {
+	return s.put(Nodes, node, true).(*corev1.Node)
+}
+
+// AddNamespace creates or replaces a namespace
+func (s *Server) AddNamespace(namespace *corev1.Namespace) *corev1.Namespace {
+	return s.put(Namespaces, namespace, true).(*corev1.Namespace)
+}
+
+// AddClaim creates or replaces a persistent volume claim
+func (s *Server) AddClaim(claim *corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
+	return s.put(Claims, claim, true).(*corev1.PersistentVolumeClaim)
+}
+
+// Events returns the events created through the API, oldest first
+func (s *Server) Events() []*corev1.Event {
+	s.Lock()
+	defer s.Unlock()
+
+	events := []*corev1.Event{}
+	for _, obj := range s.sorted(Events) {
+		events = append(events, obj.(*corev1.Event))
+	}
+	return events
+}
+
+// Fail makes all requests for a resource fail with the given HTTP status code. Open watches
+// of the resource are dropped. A code of 0 lets requests succeed again.
+func (s *Server) Fail(resource string, code int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if code == 0 {
+		delete(s.failures, resource)
+		return
+	}
+	s.failures[resource] = code
+	for w := range s.watches {
+		if w.resource == resource {
+			s.dropWatch(w)
+		}
+	}
+}
+
+// Requests returns how many requests were made with method for a resource. Watches count
+// as WATCH requests.
+func (s *Server) Requests(method, resource string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.requests[method+" "+resource]
+}
+
+// DropWatches closes all open watches like an API server restart or a broken connection
+func (s *Server) DropWatches() {
+	s.Lock()
+	defer s.Unlock()
+
+	for w := range s.watches {
+		s.dropWatch(w)
+	}
+}
+
+// Watches returns the number of open watches of a resource
+func (s *Server) Watches(resource string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.countWatches(resource)
+}
+
+// WaitForWatches waits until at least n watches of a resource are open
+func (s *Server) WaitForWatches(resource string, n int, timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		s.Lock()
+		s.changed.Broadcast()
+		s.Unlock()
+	})
+	defer timer.Stop()
+
+	deadline := time.Now().Add(timeout)
+	s.Lock()
+	defer s.Unlock()
+	for s.countWatches(resource) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		s.changed.Wait()
+	}
+	return true
+}
+
+func (s *Server) countWatches(resource string) int {
+	count := 0
+	for w := range s.watches {
+		if w.resource == resource {
+			count++
+		}
+	}
+	return count
+}
+
+// put stores a copy of obj with a new resource version
+func (s *Server) put(resource string, obj object, notify bool) object {
+	s.Lock()
+	defer s.Unlock()
+
+	stored := proto.Clone(obj).(object)
+	meta := stored.GetMetadata()
+	key := objectKey(meta.GetNamespace(), meta.GetName())
+
+	eventType := "ADDED"
+	if existing, ok := s.objects[resource][key]; ok {
+		eventType = "MODIFIED"
+		if meta.Uid == nil {
+			meta.Uid = existing.GetMetadata().Uid
+		}
+	}
+	if meta.Uid == nil {
+		s.uids++
+		meta.Uid = k8s.String(fmt.Sprintf("uid-%d", s.uids))
+	}
+	if meta.CreationTimestamp == nil {
+		meta.CreationTimestamp = now()
+	}
+
+	s.version++
+	meta.ResourceVersion = k8s.String(strconv.Itoa(s.version))
+	s.objects[resource][key] = stored
+
+	if notify {
+		s.notify(resource, eventType, stored)
+	}
+	return proto.Clone(stored).(object)
+}
+
+// remove deletes an object, watches see it with a deletion timestamp
+func (s *Server) remove(resource, namespace, name string, notify bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	key := objectKey(namespace, name)
+	existing, ok := s.objects[resource][key]
+	if !ok {
+		return false
+	}
+	delete(s.objects[resource], key)
+
+	deleted := proto.Clone(existing).(object)
+	s.version++
+	deleted.GetMetadata().ResourceVersion = k8s.String(strconv.Itoa(s.version))
+	deleted.GetMetadata().DeletionTimestamp = now()
+
+	if notify {
+		s.notify(resource, "DELETED", deleted)
+	}
+	return true
+}
+
+func (s *Server) get(resource, namespace, name string) object {
+	s.Lock()
+	defer s.Unlock()
+
+	if obj, ok := s.objects[resource][objectKey(namespace, name)]; ok {
+		return proto.Clone(obj).(object)
+	}
+	return nil
+}
+
+// sorted returns the objects of a resource in the order they were last changed
+func (s *Server) sorted(resource string) []object {
+	objs := make([]object, 0, len(s.objects[resource]))
+	for _, obj := range s.objects[resource] {
+		objs = append(objs, proto.Clone(obj).(object))
+	}
+	sortByVersion(objs)
+	return objs
+}
+
+// notify sends an event to all matching watches. Watches that fell too far behind are dropped.
+func (s *Server) notify(resource, eventType string, obj object) {
+	for w := range s.watches {
+		if w.resource != resource || !w.matches(obj) {
+			continue
+		}
+
+		event, err := newEvent(eventType, obj)
+		if err != nil {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			s.dropWatch(w)
+		}
+	}
+}
+
+func (s *Server) dropWatch(w *watch) {
+	if _, ok := s.watches[w]; !ok {
+		return
+	}
+	delete(s.watches, w)
+	close(w.drop)
+	s.changed.Broadcast()
+}
+
+func (w *watch) matches(obj object) bool {
+	if w.namespace != "" && obj.GetMetadata().GetNamespace() != w.namespace {
+		return false
+	}
+	return w.selector.matches(obj)
+}
+
+func (s *Server) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	segments := strings.Split(path, "/")
+
+	var namespace, name, kind string
+	switch {
+	case segments[0] == Namespaces && len(segments) >= 3:
+		namespace, kind = segments[1], segments[2]
+		if len(segments) == 4 {
+			name = segments[3]
+		}
+	case len(segments) <= 2:
+		kind = segments[0]
+		if len(segments) == 2 {
+			name = segments[1]
+		}
+	}
+
+	res, ok := resources[kind]
+	if !ok || (name != "" && res.namespaced && namespace == "") {
+		writeStatus(rw, http.StatusNotFound, "NotFound", fmt.Sprintf("the server could not find %s", r.URL.Path))
+		return
+	}
+
+	method := r.Method
+	if r.URL.Query().Get("watch") == "true" {
+		method = "WATCH"
+	}
+
+	s.Lock()
+	s.requests[method+" "+kind]++
+	code := s.failures[kind]
+	s.Unlock()
+	if code != 0 {
+		writeStatus(rw, code, http.StatusText(code), fmt.Sprintf("%s requests fail", kind))
+		return
+	}
+
+	sel, err := parseSelector(r.URL.Query())
+	if err != nil {
+		writeStatus(rw, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	switch {
+	case method == "WATCH" && name == "":
+		s.serveWatch(rw, r, kind, namespace, sel)
+	case method == http.MethodGet && name == "":
+		s.serveList(rw, res, kind, namespace, sel)
+	case method == http.MethodGet:
+		s.serveGet(rw, kind, namespace, name)
+	case method == http.MethodPost && name == "":
+		s.serveWrite(rw, r, res, kind, namespace, "", false)
+	case method == http.MethodPut && name != "":
+		s.serveWrite(rw, r, res, kind, namespace, name, true)
+	case method == http.MethodDelete && name != "":
+		if !s.remove(kind, namespace, name, true) {
+			writeStatus(rw, http.StatusNotFound, "NotFound", fmt.Sprintf("%s \"%s\" not found", kind, name))
+			return
+		}
+		writeStatus(rw, http.StatusOK, "", "")
+	default:
+		writeStatus(rw, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported")
+	}
+}
+
+func (s *Server) serveList(rw http.ResponseWriter, res resource, kind, namespace string, sel selector) {
+	s.Lock()
+	items := []object{}
+	for _, obj := range s.sorted(kind) {
+		if namespace != "" && obj.GetMetadata().GetNamespace() != namespace {
+			continue
+		}
+		if sel.matches(obj) {
+			items = append(items, obj)
+		}
+	}
+	version := strconv.Itoa(s.version)
+	s.Unlock()
+
+	writeObject(rw, http.StatusOK, res.list(items, &metav1.ListMeta{ResourceVersion: k8s.String(version)}))
+}
+
+func (s *Server) serveGet(rw http.ResponseWriter, kind, namespace, name string) {
+	obj := s.get(kind, namespace, name)
+	if obj == nil {
+		writeStatus(rw, http.StatusNotFound, "NotFound", fmt.Sprintf("%s \"%s\" not found", kind, name))
+		return
+	}
+	writeObject(rw, http.StatusOK, obj)
+}
+
+// serveWrite creates or updates an object. Updates of objects with a stale resource version
+// are rejected like the API server does.
+func (s *Server) serveWrite(rw http.ResponseWriter, r *http.Request, res resource, kind, namespace, name string, update bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeStatus(rw, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	obj := res.new()
+	if err = decode(body, obj); err != nil {
+		writeStatus(rw, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	meta := obj.GetMetadata()
+	if meta == nil || meta.GetName() == "" || (name != "" && meta.GetName() != name) {
+		writeStatus(rw, http.StatusBadRequest, "BadRequest", "object name is missing or does not match")
+		return
+	}
+	if res.namespaced {
+		meta.Namespace = k8s.String(namespace)
+	}
+
+	existing := s.get(kind, namespace, meta.GetName())
+	switch {
+	case update && existing == nil:
+		writeStatus(rw, http.StatusNotFound, "NotFound", fmt.Sprintf("%s \"%s\" not found", kind, meta.GetName()))
+		return
+	case !update && existing != nil:
+		writeStatus(rw, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%s \"%s\" already exists", kind, meta.GetName()))
+		return
+	case update && meta.GetResourceVersion() != "" && meta.GetResourceVersion() != existing.GetMetadata().GetResourceVersion():
+		writeStatus(rw, http.StatusConflict, "Conflict", fmt.Sprintf("%s \"%s\" was modified", kind, meta.GetName()))
+		return
+	}
+
+	status := http.StatusCreated
+	if update {
+		status = http.StatusOK
+	}
+	writeObject(rw, status, s.put(kind, obj, true))
+}
+
+// serveWatch streams events of a resource. Watches without a resource version start with
+// the current objects like the API server does.
+func (s *Server) serveWatch(rw http.ResponseWriter, r *http.Request, kind, namespace string, sel selector) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		writeStatus(rw, http.StatusInternalServerError, "InternalError", "streaming is not supported")
+		return
+	}
+
+	s.Lock()
+	w := &watch{
+		resource:  kind,
+		namespace: namespace,
+		selector:  sel,
+		events:    make(chan *versioned.Event, watchBuffer+len(s.objects[kind])),
+		drop:      make(chan struct{}),
+	}
+	if version := r.URL.Query().Get("resourceVersion"); version == "" || version == "0" {
+		for _, obj := range s.sorted(kind) {
+			if w.matches(obj) {
+				if event, err := newEvent("ADDED", obj); err == nil {
+					w.events <- event
+				}
+			}
+		}
+	}
+	s.watches[w] = struct{}{}
+	s.changed.Broadcast()
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		s.dropWatch(w)
+		s.Unlock()
+	}()
+
+	rw.Header().Set("Content-Type", "application/vnd.kubernetes.protobuf;stream=watch")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-w.events:
+			if err := writeFrame(rw, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-w.drop:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// selector filters objects by field and label selectors. Only equality is supported.
+type selector struct {
+	fields map[string]string
+	labels map[string]string
+}
+
+func parseSelector(query map[string][]string) (selector, error) {
+	sel := selector{fields: map[string]string{}, labels: map[string]string{}}
+	for param, into := range map[string]map[string]string{"fieldSelector": sel.fields, "labelSelector": sel.labels} {
+		values := query[param]
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		for _, term := range strings.Split(values[0], ",") {
+			parts := strings.SplitN(term, "=", 2)
+			if len(parts) != 2 {
+				return sel, fmt.Errorf("unsupported %s '%s'", param, term)
+			}
+			into[parts[0]] = parts[1]
+		}
+	}
+
+	for field := range sel.fields {
+		switch field {
+		case "metadata.name", "metadata.namespace", "spec.nodeName":
+		default:
+			return sel, fmt.Errorf("field '%s' can not be selected", field)
+		}
+	}
+	return sel, nil
+}
+
+func (s selector) matches(obj object) bool {
+	meta := obj.GetMetadata()
+	for field, value := range s.fields {
+		var actual string
+		switch field {
+		case "metadata.name":
+			actual = meta.GetName()
+		case "metadata.namespace":
+			actual = meta.GetNamespace()
+		case "spec.nodeName":
+			if pod, ok := obj.(*corev1.Pod); ok {
+				actual = pod.GetSpec().GetNodeName()
+			}
+		}
+		if actual != value {
+			return false
+		}
+	}
+
+	for label, value := range s.labels {
+		if meta.GetLabels()[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// encode wraps an object in the Kubernetes protobuf envelope
+func encode(obj proto.Message) ([]byte, error) {
+	raw, err := proto.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	body, err := (&runtime.Unknown{Raw: raw}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, magicBytes...), body...), nil
+}
+
+// decode unwraps an object from the Kubernetes protobuf envelope
+func decode(data []byte, obj proto.Message) error {
+	if !bytes.HasPrefix(data, magicBytes) {
+		return fmt.Errorf("payload is not a kubernetes protobuf object")
+	}
+
+	unknown := &runtime.Unknown{}
+	if err := unknown.Unmarshal(data[len(magicBytes):]); err != nil {
+		return err
+	}
+	return proto.Unmarshal(unknown.Raw, obj)
+}
+
+func newEvent(eventType string, obj object) (*versioned.Event, error) {
+	raw, err := encode(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &versioned.Event{
+		Type:   k8s.String(eventType),
+		Object: &runtime.RawExtension{Raw: raw},
+	}, nil
+}
+
+// writeFrame writes an event in the length prefixed watch stream format
+func writeFrame(rw http.ResponseWriter, event *versioned.Event) error {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+	if _, err = rw.Write(length); err != nil {
+		return err
+	}
+	_, err = rw.Write(data)
+	return err
+}
+
+func writeObject(rw http.ResponseWriter, code int, obj proto.Message) {
+	data, err := encode(obj)
+	if err != nil {
+		writeStatus(rw, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/vnd.kubernetes.protobuf")
+	rw.WriteHeader(code)
+	rw.Write(data)
+}
+
+func writeStatus(rw http.ResponseWriter, code int, reason, message string) {
+	result := "Failure"
+	if code/100 == 2 {
+		result = "Success"
+	}
+
+	status := &unversioned.Status{
+		Status:  k8s.String(result),
+		Reason:  k8s.String(reason),
+		Message: k8s.String(message),
+		Code:    proto.Int32(int32(code)),
+	}
+	data, _ := encode(status)
+
+	rw.Header().Set("Content-Type", "application/vnd.kubernetes.protobuf")
+	rw.WriteHeader(code)
+	rw.Write(data)
+}
+
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func now() *metav1.Time {
+	seconds := time.Now().Unix()
+	return &metav1.Time{Seconds: &seconds}
+}
@@ -0,0 +1,119 @@
+package kubetest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerPods(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+	ctx := context.Background()
+
+	server.AddPod(NewPod("default", "web", "node-1"))
+	server.AddPod(NewPod("default", "db", "node-2"))
+
+	pods, err := client.CoreV1().ListPods(ctx, "", k8s.QueryParam("fieldSelector", "spec.nodeName=node-1"))
+	if assert.Nil(t, err) && assert.Len(t, pods.Items, 1) {
+		assert.Equal(t, "web", pods.Items[0].GetMetadata().GetName())
+		assert.Equal(t, "2", pods.GetMetadata().GetResourceVersion())
+	}
+
+	pod, err := client.CoreV1().GetPod(ctx, "db", "default")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "node-2", pod.GetSpec().GetNodeName())
+		assert.NotEmpty(t, pod.GetStatus().GetPodIP())
+	}
+
+	_, err = client.CoreV1().GetPod(ctx, "cache", "default")
+	if apiErr, ok := err.(*k8s.APIError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusNotFound, apiErr.Code)
+	}
+
+	// Updates with a stale resource version are rejected
+	pod.Metadata.Annotations = map[string]string{"foo": "bar"}
+	updated, err := client.CoreV1().UpdatePod(ctx, pod)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "bar", server.Pod("default", "db").GetMetadata().GetAnnotations()["foo"])
+		assert.NotEqual(t, pod.GetMetadata().GetResourceVersion(), updated.GetMetadata().GetResourceVersion())
+	}
+	_, err = client.CoreV1().UpdatePod(ctx, pod)
+	if apiErr, ok := err.(*k8s.APIError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusConflict, apiErr.Code)
+	}
+}
+
+func TestServerWatch(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server.AddPod(NewPod("default", "web", "node-1"))
+
+	watcher, err := client.CoreV1().WatchPods(ctx, "", k8s.QueryParam("fieldSelector", "spec.nodeName=node-1"))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer watcher.Close()
+
+	// Watches start with the current pods
+	event, pod, err := watcher.Next()
+	if assert.Nil(t, err) {
+		assert.Equal(t, "ADDED", event.GetType())
+		assert.Equal(t, "web", pod.GetMetadata().GetName())
+	}
+
+	// Pods of other nodes are filtered
+	server.AddPod(NewPod("default", "db", "node-2"))
+	web := server.Pod("default", "web")
+	web.Metadata.Labels = map[string]string{"version": "2"}
+	server.UpdatePod(web)
+	server.DeletePod("default", "web")
+
+	for _, expected := range []string{"MODIFIED", "DELETED"} {
+		event, pod, err = watcher.Next()
+		if assert.Nil(t, err) {
+			assert.Equal(t, expected, event.GetType())
+			assert.Equal(t, "web", pod.GetMetadata().GetName())
+		}
+	}
+	assert.NotNil(t, pod.GetMetadata().GetDeletionTimestamp())
+
+	// Dropped watches end the stream
+	assert.True(t, server.WaitForWatches(Pods, 1, time.Second))
+	server.DropWatches()
+	_, _, err = watcher.Next()
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, server.Watches(Pods))
+	assert.Equal(t, 1, server.Requests("WATCH", Pods))
+}
+
+func TestServerFailures(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	server.AddSecret(NewSecret("default", "config", map[string]string{"modules": "- module: redis"}))
+
+	server.Fail(Secrets, http.StatusForbidden)
+	_, err := client.CoreV1().GetSecret(context.Background(), "config", "default")
+	if apiErr, ok := err.(*k8s.APIError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusForbidden, apiErr.Code)
+	}
+
+	server.Fail(Secrets, 0)
+	secret, err := client.CoreV1().GetSecret(context.Background(), "config", "default")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "- module: redis", string(secret.GetData()["modules"]))
+	}
+	assert.Equal(t, 2, server.Requests(http.MethodGet, Secrets))
+}
@@ -0,0 +1,255 @@
+package lint
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ghodss/yaml"
+
+	"github.com/elastic/beats/libbeat/common"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+const (
+	// SyntheticIP is assigned to pods built from templates so that builders generate configs
+	SyntheticIP = "10.0.0.1"
+
+	defaultNamespace = "default"
+)
+
+// DefaultBuilders are the builders that can be checked without access to a cluster
+var DefaultBuilders = []string{"metrics_annotations", "log_annotations", "graphite_annotations"}
+
+// builderConfigs holds the settings required to create builders outside of a cluster
+var builderConfigs = map[string]map[string]interface{}{
+	"graphite_annotations": {
+		"config": map[string]interface{}{},
+	},
+}
+
+// Result is the outcome of linting a single workload
+type Result struct {
+	Source   string
+	Object   string
+	Problems []*builder.ValidationError
+	// Configs is the number of configs generated per builder
+	Configs map[string]int
+}
+
+// Linter runs builders against the pod templates of Kubernetes manifests
+type Linter struct {
+	builders []builder.Builder
+	names    []string
+}
+
+// New creates a linter using the named builders from the builder registry
+func New(names []string) (*Linter, error) {
+	l := &Linter{}
+	for _, name := range names {
+		constructor := registry.BuilderRegistry.GetBuilder(name)
+		if constructor == nil {
+			return nil, fmt.Errorf("builder %s is not available", name)
+		}
+
+		cfg, err := common.NewConfigFrom(builderConfigs[name])
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := constructor(cfg, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create builder %s: %v", name, err)
+		}
+
+		l.builders = append(l.builders, b)
+		l.names = append(l.names, name)
+	}
+	return l, nil
+}
+
+// Lint checks every workload found in the YAML documents read from r
+func (l *Linter) Lint(source string, r io.Reader) ([]*Result, error) {
+	docs, err := splitDocuments(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+
+	results := []*Result{}
+	for _, doc := range docs {
+		pods, err := podsFromManifest(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+
+		for object, pod := range pods {
+			result := l.LintPod(pod)
+			result.Source = source
+			result.Object = object
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// LintPod validates a pod with all builders and runs them to count the generated configs
+func (l *Linter) LintPod(pod *kubernetes.Pod) *Result {
+	result := &Result{Configs: map[string]int{}}
+	for i, b := range l.builders {
+		if validator, ok := b.(builder.Validator); ok {
+			result.Problems = append(result.Problems, validator.Validate(pod)...)
+		}
+
+		var holders []*dcommon.ConfigHolder
+		switch bType := b.(type) {
+		case builder.PollerBuilder:
+			holders = bType.BuildModuleConfigs(pod)
+		case builder.PushBuilder:
+			// Push builders share one module config for all pods, so only the pod's own
+			// template is checked by validation
+		}
+		result.Configs[l.names[i]] = len(holders)
+	}
+	return result
+}
+
+// splitDocuments splits a YAML stream into its documents
+func splitDocuments(r io.Reader) ([][]byte, error) {
+	docs := [][]byte{}
+	buf := &bytes.Buffer{}
+
+	flush := func() {
+		if len(bytes.TrimSpace(buf.Bytes())) != 0 {
+			docs = append(docs, append([]byte{}, buf.Bytes()...))
+		}
+		buf.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") && strings.TrimSpace(strings.TrimLeft(line, "-")) == "" {
+			flush()
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	return docs, nil
+}
+
+type object struct {
+	Kind     string                `json:"kind"`
+	Metadata kubernetes.ObjectMeta `json:"metadata"`
+	Spec     json.RawMessage       `json:"spec"`
+	Items    []json.RawMessage     `json:"items"`
+}
+
+type podTemplate struct {
+	Metadata kubernetes.ObjectMeta `json:"metadata"`
+	Spec     kubernetes.PodSpec    `json:"spec"`
+}
+
+type workloadSpec struct {
+	Template    *podTemplate `json:"template"`
+	JobTemplate *struct {
+		Spec workloadSpec `json:"spec"`
+	} `json:"jobTemplate"`
+}
+
+// podsFromManifest returns synthetic pods for every workload in a manifest keyed by a
+// description of the workload. Kinds without a pod template are ignored.
+func podsFromManifest(doc []byte) (map[string]*kubernetes.Pod, error) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := object{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	pods := map[string]*kubernetes.Pod{}
+	if obj.Kind == "List" {
+		for _, item := range obj.Items {
+			itemPods, err := podsFromManifest(item)
+			if err != nil {
+				return nil, err
+			}
+			for name, pod := range itemPods {
+				pods[name] = pod
+			}
+		}
+		return pods, nil
+	}
+
+	var template *podTemplate
+	switch obj.Kind {
+	case "Pod":
+		template = &podTemplate{Metadata: obj.Metadata}
+		if err = json.Unmarshal(obj.Spec, &template.Spec); err != nil {
+			return nil, err
+		}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job", "CronJob":
+		spec := workloadSpec{}
+		if err = json.Unmarshal(obj.Spec, &spec); err != nil {
+			return nil, err
+		}
+		if spec.JobTemplate != nil {
+			spec = spec.JobTemplate.Spec
+		}
+		template = spec.Template
+	}
+
+	if template == nil {
+		return pods, nil
+	}
+
+	namespace := obj.Metadata.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	pods[fmt.Sprintf("%s %s/%s", obj.Kind, namespace, obj.Metadata.Name)] = newPod(obj.Metadata, template, namespace)
+	return pods, nil
+}
+
+// newPod creates a running pod with a synthetic IP and container IDs from a pod template
+func newPod(owner kubernetes.ObjectMeta, template *podTemplate, namespace string) *kubernetes.Pod {
+	pod := &kubernetes.Pod{
+		Kind:     "Pod",
+		Metadata: template.Metadata,
+		Spec:     template.Spec,
+	}
+
+	if pod.Metadata.Name == "" {
+		pod.Metadata.Name = owner.Name
+	}
+	pod.Metadata.Namespace = namespace
+	pod.Metadata.UID = "lint-" + pod.Metadata.Name
+
+	pod.Status.Phase = "Running"
+	pod.Status.PodIP = SyntheticIP
+	for _, container := range pod.Spec.Containers {
+		status := kubernetes.PodContainerStatus{
+			Name:        container.Name,
+			ContainerID: "docker://" + container.Name,
+			Ready:       true,
+		}
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, status)
+	}
+
+	return pod
+}
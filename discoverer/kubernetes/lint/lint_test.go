@@ -0,0 +1,113 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/graphite_annotations"
+	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/log_annotations"
+	_ "github.com/ebay/collectbeat/discoverer/kubernetes/common/builder/metrics_annotations"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/elastic/beats/metricbeat/module/prometheus/collector"
+)
+
+const manifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  template:
+    metadata:
+      annotations:
+        io.collectbeat.metrics/type: prometheus
+        io.collectbeat.metrics/endpoints: ":9090"
+        io.collectbeat.metrics/namespace: shop
+        io.collectbeat.logs/pattern: "^\\["
+    spec:
+      containers:
+      - name: web
+        image: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: db
+spec:
+  containers:
+  - name: db
+    image: db
+  - name: sidecar
+    image: sidecar
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          annotations:
+            io.collectbeat.metrics/type: promethus
+            io.collectbeat.metrics/endpoints: ":9090"
+            io.collectbeat.metrics/interval: "10"
+        spec:
+          containers:
+          - name: report
+            image: report
+`
+
+func TestLint(t *testing.T) {
+	l, err := New(DefaultBuilders)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	results, err := l.Lint("manifests.yml", strings.NewReader(manifests))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 3, len(results))
+
+	web := results[0]
+	assert.Equal(t, "manifests.yml", web.Source)
+	assert.Equal(t, "Deployment shop/web", web.Object)
+	assert.Equal(t, 0, len(web.Problems))
+	assert.Equal(t, 1, web.Configs["metrics_annotations"])
+	assert.Equal(t, 1, web.Configs["log_annotations"])
+
+	db := results[1]
+	assert.Equal(t, "Pod default/db", db.Object)
+	assert.Equal(t, 0, len(db.Problems))
+	assert.Equal(t, 2, db.Configs["log_annotations"])
+
+	report := results[2]
+	assert.Equal(t, "CronJob default/report", report.Object)
+	keys := []string{}
+	for _, problem := range report.Problems {
+		keys = append(keys, problem.Key)
+	}
+	assert.Equal(t, []string{"io.collectbeat.metrics/type", "io.collectbeat.metrics/interval"}, keys)
+}
+
+func TestLintInvalidYAML(t *testing.T) {
+	l, err := New(DefaultBuilders)
+	assert.Nil(t, err)
+
+	_, err = l.Lint("broken.yml", strings.NewReader("kind: Pod\nspec: [\n"))
+	assert.NotNil(t, err)
+}
+
+func TestLintUnknownBuilder(t *testing.T) {
+	_, err := New([]string{"unknown"})
+	assert.NotNil(t, err)
+}
@@ -2,29 +2,58 @@ package kubernetes
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ebay/collectbeat/discoverer"
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
 	"github.com/ericchiang/k8s"
 	corev1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
 	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
 )
 
+var (
+	kubernetesMetrics = metrics.Registry.NewRegistry("kubernetes")
+
+	podsWatched      = monitoring.NewInt(kubernetesMetrics, "pods.watched")
+	podEventsAdded   = monitoring.NewInt(kubernetesMetrics, "pods.events.add")
+	podEventsUpdated = monitoring.NewInt(kubernetesMetrics, "pods.events.update")
+	podEventsDeleted = monitoring.NewInt(kubernetesMetrics, "pods.events.delete")
+	watchReconnects  = monitoring.NewInt(kubernetesMetrics, "watch.reconnects")
+	watchErrors      = monitoring.NewInt(kubernetesMetrics, "watch.errors")
+	syncErrors       = monitoring.NewInt(kubernetesMetrics, "sync.errors")
+	queueDepth       = monitoring.NewInt(kubernetesMetrics, "queue.depth")
+)
+
 // PodWatcher is a controller that synchronizes Pods.
 type PodWatcher struct {
 	kubeClient          *k8s.Client
 	syncPeriod          time.Duration
+	refreshPeriod       time.Duration // how often appenders are asked for changes, 0 disables it
 	podQueue            chan *corev1.Pod
 	nodeFilter          k8s.Option
+	host                string
 	lastResourceVersion string
 	ctx                 context.Context
 	stop                context.CancelFunc
+	stopOnce            sync.Once
+	runLock             sync.Mutex     // keeps goroutines from being added once stopping
+	producers           sync.WaitGroup // goroutines sending to podQueue
+	workers             sync.WaitGroup // goroutine processing podQueue
 	pods                podMeta
 	builders            *discoverer.Builders
+	events              *eventReporter
+	processing          sync.Mutex // serializes pod events with reloads of the builders
 	indexers            *kubernetes.Indexers
 }
 
@@ -32,12 +61,17 @@ type podMeta struct {
 	sync.RWMutex
 	pods        map[string]*kubernetes.Pod
 	annotations map[string]common.MapStr
+	// specs holds the full spec of pods as the converted pods leave out their volumes
+	specs map[string]*corev1.PodSpec
 }
 
 func (p *podMeta) AddPod(name string, pod *kubernetes.Pod) {
 	p.Lock()
 	defer p.Unlock()
 
+	if _, ok := p.pods[name]; !ok {
+		podsWatched.Inc()
+	}
 	p.pods[name] = pod
 }
 
@@ -53,9 +87,24 @@ func (p *podMeta) DeletePod(name string) {
 	p.Lock()
 	defer p.Unlock()
 
+	if _, ok := p.pods[name]; ok {
+		podsWatched.Dec()
+	}
 	delete(p.pods, name)
 }
 
+// Pods returns all known pods
+func (p *podMeta) Pods() []*kubernetes.Pod {
+	p.RLock()
+	defer p.RUnlock()
+
+	pods := make([]*kubernetes.Pod, 0, len(p.pods))
+	for _, pod := range p.pods {
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
 func (p *podMeta) AddPodAnnotations(name string, meta common.MapStr) {
 	p.Lock()
 	defer p.Unlock()
@@ -78,6 +127,27 @@ func (p *podMeta) DeletePodAnnotations(name string) {
 	delete(p.annotations, name)
 }
 
+func (p *podMeta) AddPodSpec(name string, spec *corev1.PodSpec) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.specs[name] = spec
+}
+
+func (p *podMeta) GetPodSpec(name string) *corev1.PodSpec {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.specs[name]
+}
+
+func (p *podMeta) DeletePodSpec(name string) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.specs, name)
+}
+
 type NodeOption struct{}
 
 // NewPodWatcher initializes the watcher factory to provide a local state of
@@ -90,6 +160,7 @@ func NewPodWatcher(kubeClient *k8s.Client, indexers *kubernetes.Indexers, syncPe
 		syncPeriod:          syncPeriod,
 		podQueue:            make(chan *corev1.Pod, 10),
 		nodeFilter:          k8s.QueryParam("fieldSelector", "spec.nodeName="+host),
+		host:                host,
 		lastResourceVersion: "0",
 		ctx:                 ctx,
 		stop:                cancel,
@@ -97,10 +168,35 @@ func NewPodWatcher(kubeClient *k8s.Client, indexers *kubernetes.Indexers, syncPe
 		pods: podMeta{
 			pods:        make(map[string]*kubernetes.Pod),
 			annotations: make(map[string]common.MapStr),
+			specs:       make(map[string]*corev1.PodSpec),
 		},
 	}
 }
 
+// enqueue sends a pod to the worker. It returns false once the watcher is stopping.
+func (p *PodWatcher) enqueue(pod *corev1.Pod) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+
+	queueDepth.Inc()
+	select {
+	case p.podQueue <- pod:
+		return true
+	case <-p.ctx.Done():
+		queueDepth.Dec()
+		return false
+	}
+}
+
+// wait pauses for d or until the watcher is stopping
+func (p *PodWatcher) wait(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-p.ctx.Done():
+	}
+}
+
 func (p *PodWatcher) syncPods() error {
 	logp.Info("kubernetes: %s", "Performing a pod sync")
 	pods, err := p.kubeClient.CoreV1().ListPods(
@@ -114,7 +210,9 @@ func (p *PodWatcher) syncPods() error {
 	}
 
 	for _, pod := range pods.Items {
-		p.podQueue <- pod
+		if !p.enqueue(pod) {
+			return p.ctx.Err()
+		}
 	}
 
 	// Store last version
@@ -124,54 +222,210 @@ func (p *PodWatcher) syncPods() error {
 	return nil
 }
 
+// Resync lists all pods of the node again. Pods that are missing are started and pods that
+// no longer exist are stopped.
+func (p *PodWatcher) Resync() error {
+	done := make(chan error, 1)
+	if !p.spawn(&p.producers, func() { done <- p.resync() }) {
+		return errors.New("pod watcher is stopped")
+	}
+	return <-done
+}
+
+func (p *PodWatcher) resync() error {
+	logp.Info("kubernetes: %s", "Performing a forced pod resync")
+	pods, err := p.kubeClient.CoreV1().ListPods(p.ctx, "", p.nodeFilter)
+	if err != nil {
+		syncErrors.Inc()
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, pod := range pods.Items {
+		listed[pod.GetMetadata().GetUid()] = true
+		if !p.enqueue(pod) {
+			return p.ctx.Err()
+		}
+	}
+
+	for _, known := range p.pods.Pods() {
+		if listed[known.Metadata.UID] {
+			continue
+		}
+
+		debug("Pod %s/%s is gone, stopping it", known.Metadata.Namespace, known.Metadata.Name)
+		if !p.enqueue(deletedPod(known)) {
+			return p.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deletedPod returns a pod event that marks a known pod as deleted
+func deletedPod(pod *kubernetes.Pod) *corev1.Pod {
+	seconds := time.Now().Unix()
+	return &corev1.Pod{
+		Metadata: &metav1.ObjectMeta{
+			Name:              k8s.String(pod.Metadata.Name),
+			Namespace:         k8s.String(pod.Metadata.Namespace),
+			Uid:               k8s.String(pod.Metadata.UID),
+			ResourceVersion:   k8s.String(pod.Metadata.ResourceVersion),
+			DeletionTimestamp: &metav1.Time{Seconds: &seconds},
+		},
+	}
+}
+
+// podStatus summarizes a known pod
+type podStatus struct {
+	UID             string            `json:"uid"`
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	IP              string            `json:"ip,omitempty"`
+	Phase           string            `json:"phase,omitempty"`
+	ResourceVersion string            `json:"resource_version"`
+	Containers      []containerStatus `json:"containers"`
+}
+
+type containerStatus struct {
+	Name  string `json:"name"`
+	ID    string `json:"id,omitempty"`
+	Ready bool   `json:"ready"`
+}
+
+// Status lists the known pods sorted by namespace and name
+func (p *PodWatcher) Status() []podStatus {
+	pods := []podStatus{}
+	for _, pod := range p.pods.Pods() {
+		status := podStatus{
+			UID:             pod.Metadata.UID,
+			Name:            pod.Metadata.Name,
+			Namespace:       pod.Metadata.Namespace,
+			IP:              pod.Status.PodIP,
+			Phase:           pod.Status.Phase,
+			ResourceVersion: pod.Metadata.ResourceVersion,
+			Containers:      []containerStatus{},
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			status.Containers = append(status.Containers, containerStatus{
+				Name:  container.Name,
+				ID:    container.ContainerID,
+				Ready: container.Ready,
+			})
+		}
+		pods = append(pods, status)
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	return pods
+}
+
 func (p *PodWatcher) watchPods() {
-	for {
+	for first := true; p.ctx.Err() == nil; first = false {
+		if !first {
+			watchReconnects.Inc()
+		}
+
 		logp.Info("kubernetes: %s", "Watching API for pod events")
 		watcher, err := p.kubeClient.CoreV1().WatchPods(p.ctx, "", p.nodeFilter)
 		if err != nil {
 			//watch pod failures should be logged and gracefully failed over as metadata retrieval
 			//should never stop.
+			watchErrors.Inc()
 			logp.Err("kubernetes: Watching API eror %v", err)
-			time.Sleep(time.Second)
+			p.wait(time.Second)
 			continue
 		}
 		for {
 			_, pod, err := watcher.Next()
 			if err != nil {
-				logp.Err("kubernetes: Watching API eror %v", err)
-				time.Sleep(time.Second)
+				if p.ctx.Err() == nil {
+					watchErrors.Inc()
+					logp.Err("kubernetes: Watching API eror %v", err)
+					p.wait(time.Second)
+				}
 				break
 			}
 
-			p.podQueue <- pod
+			if !p.enqueue(pod) {
+				break
+			}
 		}
+		watcher.Close()
 	}
-
+	debug("Stopped watching API for pod events")
 }
 
-func (p *PodWatcher) Run() bool {
+// Run syncs the pods of the node and starts watching for changes. Cancelling ctx stops the
+// watcher like Stop does.
+func (p *PodWatcher) Run(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		p.Stop()
+		return false
+	}
+
+	// Propagate cancellation of the caller's context
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.stop()
+		case <-p.ctx.Done():
+		}
+	}()
+
 	// Start pod processing worker:
-	go p.worker()
+	if !p.spawn(&p.workers, p.worker) {
+		return false
+	}
 
 	// Make sure that events don't flow into the annotator before informer is fully set up
 	// Sync initial state:
 	synced := make(chan struct{})
-	go func() {
-		p.syncPods()
+	p.spawn(&p.producers, func() {
+		if err := p.syncPods(); err != nil {
+			syncErrors.Inc()
+			logp.Err("kubernetes: Pod sync failed with error: %v", err)
+		}
 		close(synced)
-	}()
+	})
 
 	select {
 	case <-time.After(ready_timeout):
 		p.Stop()
 		return false
+	case <-p.ctx.Done():
+		return false
 	case <-synced:
+		if p.refreshPeriod > 0 && !p.spawn(&p.producers, p.refreshAppenders) {
+			return false
+		}
+
 		// Watch for new changes
-		go p.watchPods()
-		return true
+		return p.spawn(&p.producers, p.watchPods)
 	}
 }
 
+// spawn runs f in a goroutine tracked by wg unless the watcher is stopping
+func (p *PodWatcher) spawn(wg *sync.WaitGroup, f func()) bool {
+	p.runLock.Lock()
+	defer p.runLock.Unlock()
+
+	if p.ctx.Err() != nil {
+		return false
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+	return true
+}
+
 func (p *PodWatcher) onPodAdd(pod *kubernetes.Pod) {
 	for _, m := range p.indexers.GetMetadata(pod) {
 		p.pods.AddPodAnnotations(m.Index, m.Data)
@@ -180,15 +434,48 @@ func (p *PodWatcher) onPodAdd(pod *kubernetes.Pod) {
 	p.pods.AddPod(pod.Metadata.UID, pod)
 	p.builders.StartModuleRunners(pod)
 
+	// Events are not sent while stopping as pods are only drained from the queue then
+	if p.events != nil && p.ctx.Err() == nil {
+		p.events.Report(p.ctx, pod, p.builders.Validate(pod))
+	}
+
 }
 
 func (p *PodWatcher) onPodUpdate(pod *kubernetes.Pod) {
 	oldPod := p.GetPod(pod.Metadata.UID)
-	if oldPod.Metadata.ResourceVersion != pod.Metadata.ResourceVersion {
-		// Process the new pod changes
-		p.onPodDelete(oldPod)
-		p.onPodAdd(pod)
+	if oldPod.Metadata.ResourceVersion == pod.Metadata.ResourceVersion {
+		return
+	}
+
+	// Setting the status annotation updates the pod, its configs keep running. The pod they were
+	// generated from is kept so that they are found and stopped with it.
+	if p.events != nil && onlyAnnotationChanged(oldPod, pod, p.events.config.StatusAnnotation) {
+		return
+	}
+
+	// Process the new pod changes
+	p.onPodDelete(oldPod)
+	p.onPodAdd(pod)
+}
+
+// onlyAnnotationChanged returns whether two versions of a pod differ in annotation key only
+func onlyAnnotationChanged(old, new *kubernetes.Pod, key string) bool {
+	if key == "" {
+		return false
+	}
+
+	strip := func(pod *kubernetes.Pod) kubernetes.Pod {
+		out := *pod
+		out.Metadata.ResourceVersion = ""
+		out.Metadata.Annotations = map[string]string{}
+		for k, v := range pod.Metadata.Annotations {
+			if k != key {
+				out.Metadata.Annotations[k] = v
+			}
+		}
+		return out
 	}
+	return reflect.DeepEqual(strip(old), strip(new))
 }
 
 func (p *PodWatcher) onPodDelete(pod *kubernetes.Pod) {
@@ -205,20 +492,101 @@ func (p *PodWatcher) onPodDelete(pod *kubernetes.Pod) {
 }
 
 func (p *PodWatcher) worker() {
-	for po := range p.podQueue {
-		pod := kubernetes.GetPodMeta(po)
-		if pod.Metadata.DeletionTimestamp != "" {
-			p.onPodDelete(pod)
+	for {
+		select {
+		case po := <-p.podQueue:
+			queueDepth.Dec()
+			p.process(po)
+		case <-p.ctx.Done():
+			// Producers stop sending once the context is done, process what they already sent
+			p.producers.Wait()
+			p.drain()
+			return
+		}
+	}
+}
+
+// drain processes all pod events left in the queue
+func (p *PodWatcher) drain() {
+	for {
+		select {
+		case po := <-p.podQueue:
+			queueDepth.Dec()
+			p.process(po)
+		default:
+			return
+		}
+	}
+}
+
+func (p *PodWatcher) process(po *corev1.Pod) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	pod := kubernetes.GetPodMeta(po)
+	if pod.Metadata.DeletionTimestamp != "" {
+		podEventsDeleted.Inc()
+		p.onPodDelete(pod)
+		p.pods.DeletePodSpec(pod.Metadata.UID)
+		if p.events != nil {
+			p.events.Forget(pod.Metadata.UID)
+		}
+	} else {
+		p.pods.AddPodSpec(pod.Metadata.UID, po.GetSpec())
+		existing := p.GetPod(pod.Metadata.UID)
+		if existing != nil {
+			podEventsUpdated.Inc()
+			p.onPodUpdate(pod)
 		} else {
-			existing := p.GetPod(pod.Metadata.UID)
-			if existing != nil {
-				p.onPodUpdate(pod)
-			} else {
-				p.onPodAdd(pod)
-			}
+			podEventsAdded.Inc()
+			p.onPodAdd(pod)
 		}
 	}
+}
 
+// reload moves the configs of all known pods to new builders and appenders. Before the
+// watcher is started there is nothing to move.
+func (p *PodWatcher) reload(builders []builder.Builder, names map[builder.Builder]string, appenders []appender.Appender) {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	if p.builders == nil {
+		return
+	}
+
+	for build, name := range names {
+		p.builders.SetName(build, name)
+	}
+	p.builders.Reload(builders, appenders, p.objs())
+}
+
+// refreshAppenders periodically restarts the configs of known pods that appenders now append
+// differently, such as configs with rotated credentials
+func (p *PodWatcher) refreshAppenders() {
+	for p.wait(p.refreshPeriod); p.ctx.Err() == nil; p.wait(p.refreshPeriod) {
+		p.refresh()
+	}
+}
+
+func (p *PodWatcher) refresh() {
+	p.processing.Lock()
+	defer p.processing.Unlock()
+
+	if p.builders == nil {
+		return
+	}
+
+	p.builders.Refresh(p.objs())
+}
+
+// objs returns the known pods as objects for the builders
+func (p *PodWatcher) objs() []interface{} {
+	pods := p.pods.Pods()
+	objs := make([]interface{}, 0, len(pods))
+	for _, pod := range pods {
+		objs = append(objs, pod)
+	}
+	return objs
 }
 
 func (p *PodWatcher) GetPod(uid string) *kubernetes.Pod {
@@ -226,9 +594,20 @@ func (p *PodWatcher) GetPod(uid string) *kubernetes.Pod {
 	return po
 }
 
+// GetPodSpec returns the full spec of a known pod
+func (p *PodWatcher) GetPodSpec(uid string) *corev1.PodSpec {
+	return p.pods.GetPodSpec(uid)
+}
+
+// Stop stops syncing and watching and returns once all queued pod events are processed
 func (p *PodWatcher) Stop() {
-	p.stop()
-	close(p.podQueue)
+	p.stopOnce.Do(func() {
+		p.runLock.Lock()
+		p.stop()
+		p.runLock.Unlock()
+
+		p.workers.Wait()
+	})
 }
 
 func (p *PodWatcher) GetMetaData(arg string) common.MapStr {
@@ -0,0 +1,300 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ebay/collectbeat/discoverer"
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/builder"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/factory/dryrun"
+	"github.com/ebay/collectbeat/discoverer/kubernetes/kubetest"
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/api/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+	kubernetes "github.com/elastic/beats/libbeat/processors/add_kubernetes_metadata"
+)
+
+func TestPodWatcherDrainsOnStop(t *testing.T) {
+	watcher, recorder := newTestPodWatcher()
+
+	// Queue events before the worker runs so that they are in flight when stopping
+	for i := 0; i < 5; i++ {
+		assert.True(t, watcher.enqueue(newTestPod(i)))
+	}
+
+	assert.True(t, watcher.spawn(&watcher.workers, watcher.worker))
+	watcher.Stop()
+
+	assert.Equal(t, 5, recorder.Len())
+
+	// Producers are not blocked and nothing is sent to the queue once stopped
+	assert.False(t, watcher.enqueue(newTestPod(6)))
+	assert.False(t, watcher.spawn(&watcher.producers, func() {}))
+
+	// Stop can be called again
+	watcher.Stop()
+}
+
+func TestPodWatcherContextCancel(t *testing.T) {
+	watcher, recorder := newTestPodWatcher()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Run returns without syncing once the context is cancelled
+	assert.False(t, watcher.Run(ctx))
+
+	done := make(chan struct{})
+	go func() {
+		watcher.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after the context was cancelled")
+	}
+	assert.Equal(t, 0, recorder.Len())
+}
+
+func TestPodWatcherMetrics(t *testing.T) {
+	watcher, _ := newTestPodWatcher()
+	watched := podsWatched.Get()
+	added, updates, deleted := podEventsAdded.Get(), podEventsUpdated.Get(), podEventsDeleted.Get()
+
+	assert.True(t, watcher.enqueue(newTestPod(1)))
+	assert.True(t, watcher.enqueue(newTestPod(2)))
+	assert.Equal(t, int64(2), queueDepth.Get())
+
+	updated := newTestPod(1)
+	updated.Metadata.ResourceVersion = k8s.String("2")
+	assert.True(t, watcher.enqueue(updated))
+	assert.True(t, watcher.enqueue(deletedPod(kubernetes.GetPodMeta(newTestPod(2)))))
+
+	assert.True(t, watcher.spawn(&watcher.workers, watcher.worker))
+	watcher.Stop()
+
+	assert.Equal(t, int64(0), queueDepth.Get())
+	assert.Equal(t, int64(1), podsWatched.Get()-watched)
+	assert.Equal(t, int64(2), podEventsAdded.Get()-added)
+	assert.Equal(t, int64(1), podEventsUpdated.Get()-updates)
+	assert.Equal(t, int64(1), podEventsDeleted.Get()-deleted)
+}
+
+func TestPodWatcherLifecycle(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	server.AddPod(kubetest.NewPod("default", "web", "node-1"))
+	server.AddPod(kubetest.NewPod("default", "db", "node-2"))
+
+	watcher, recorder := newAPIPodWatcher(server, "node-1")
+	defer watcher.Stop()
+
+	// Only pods of the node are synced
+	assert.True(t, watcher.Run(context.Background()))
+	waitFor(t, func() bool { return len(podNames(recorder)) == 1 })
+	assert.Equal(t, []string{"web"}, podNames(recorder))
+	assert.True(t, server.WaitForWatches(kubetest.Pods, 1, 5*time.Second))
+
+	server.AddPod(kubetest.NewPod("default", "cache", "node-1"))
+	waitFor(t, func() bool { return len(podNames(recorder)) == 2 })
+
+	// The full spec is kept for builders
+	if spec := watcher.GetPodSpec("default-cache"); assert.NotNil(t, spec) {
+		assert.Equal(t, "node-1", spec.GetNodeName())
+	}
+
+	// Updated pods are restarted with their new state
+	web := server.Pod("default", "web")
+	web.Status.PodIP = k8s.String("10.0.0.42")
+	server.UpdatePod(web)
+	waitFor(t, func() bool {
+		pod := watcher.GetPod(web.GetMetadata().GetUid())
+		return pod != nil && pod.Status.PodIP == "10.0.0.42"
+	})
+	assert.Equal(t, []string{"cache", "web"}, podNames(recorder))
+
+	server.DeletePod("default", "cache")
+	waitFor(t, func() bool { return len(podNames(recorder)) == 1 })
+	assert.Equal(t, []string{"web"}, podNames(recorder))
+	waitFor(t, func() bool { return watcher.GetPodSpec("default-cache") == nil })
+}
+
+func TestPodWatcherReconnects(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	watcher, recorder := newAPIPodWatcher(server, "node-1")
+	defer watcher.Stop()
+
+	assert.True(t, watcher.Run(context.Background()))
+	assert.True(t, server.WaitForWatches(kubetest.Pods, 1, 5*time.Second))
+	reconnects, errors := watchReconnects.Get(), watchErrors.Get()
+
+	// Pods added while the watch is down are seen once it is back
+	server.DropWatches()
+	server.AddPod(kubetest.NewPod("default", "web", "node-1"))
+	waitFor(t, func() bool { return len(podNames(recorder)) == 1 })
+	assert.Equal(t, int64(1), watchReconnects.Get()-reconnects)
+	assert.Equal(t, int64(1), watchErrors.Get()-errors)
+
+	// Failing watch requests are retried
+	server.Fail(kubetest.Pods, http.StatusInternalServerError)
+	waitFor(t, func() bool { return watchErrors.Get()-errors >= 3 })
+	server.Fail(kubetest.Pods, 0)
+	assert.True(t, server.WaitForWatches(kubetest.Pods, 1, 5*time.Second))
+
+	server.AddPod(kubetest.NewPod("default", "db", "node-1"))
+	waitFor(t, func() bool { return len(podNames(recorder)) == 2 })
+}
+
+func TestPodWatcherResync(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	server.AddPod(kubetest.NewPod("default", "web", "node-1"))
+	server.AddPod(kubetest.NewPod("default", "db", "node-1"))
+
+	watcher, recorder := newAPIPodWatcher(server, "node-1")
+	defer watcher.Stop()
+
+	assert.True(t, watcher.Run(context.Background()))
+	waitFor(t, func() bool { return len(podNames(recorder)) == 2 })
+
+	// A missed delete is only noticed by a resync
+	server.ForgetPod("default", "db")
+	assert.Nil(t, watcher.Resync())
+	waitFor(t, func() bool { return len(podNames(recorder)) == 1 })
+	assert.Equal(t, []string{"web"}, podNames(recorder))
+
+	server.Fail(kubetest.Pods, http.StatusForbidden)
+	assert.NotNil(t, watcher.Resync())
+}
+
+func TestPodWatcherCreatesEvents(t *testing.T) {
+	server := kubetest.NewServer()
+	defer server.Close()
+
+	watcher, _ := newAPIPodWatcher(server, "node-1")
+	watcher.builders.AddBuilder(&invalidBuilder{})
+	watcher.events = newEventReporter(server.Client(), eventsConfig{
+		Enabled:          true,
+		Interval:         time.Minute,
+		StatusAnnotation: "io.collectbeat/status",
+	}, "node-1")
+	counter := &countingFactory{Factory: dryrun.New()}
+	watcher.builders.SetFactory(counter)
+
+	watcher.process(server.AddPod(kubetest.NewPod("default", "web", "node-1")))
+
+	events := server.Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "collectbeat: always invalid", events[0].GetMessage())
+		assert.Equal(t, "web", events[0].GetInvolvedObject().GetName())
+	}
+	assert.Equal(t, "collectbeat: always invalid",
+		server.Pod("default", "web").GetMetadata().GetAnnotations()["io.collectbeat/status"])
+
+	// Setting the status does not restart the configs of the pod
+	watcher.process(server.Pod("default", "web"))
+	assert.Equal(t, 0, counter.stops)
+
+	// Other changes do
+	updated := server.Pod("default", "web")
+	updated.Metadata.Labels = map[string]string{"app": "web"}
+	watcher.process(server.UpdatePod(updated))
+	assert.Equal(t, 1, counter.stops)
+	assert.Len(t, watcher.builders.Holders(), 1)
+}
+
+// countingFactory counts the calls to Stop
+type countingFactory struct {
+	factory.Factory
+	stops int
+}
+
+func (c *countingFactory) Stop(holders []*dcommon.ConfigHolder) error {
+	c.stops++
+	return c.Factory.Stop(holders)
+}
+
+// newAPIPodWatcher returns a watcher of the pods of a node on a fake API server
+func newAPIPodWatcher(server *kubetest.Server, node string) (*PodWatcher, *dryrun.DryRunFactory) {
+	genMeta := kubernetes.NewGenDefaultMeta(nil, nil, nil)
+	watcher := NewPodWatcher(server.Client(), kubernetes.NewIndexers(nil, genMeta), time.Second, node)
+
+	recorder := dryrun.New()
+	watcher.builders = discoverer.NewBuilder([]builder.Builder{&podBuilder{}}, nil)
+	watcher.builders.SetFactory(recorder)
+
+	return watcher, recorder
+}
+
+// podNames returns the sorted names of the pods configs were started for
+func podNames(recorder *dryrun.DryRunFactory) []string {
+	names := []string{}
+	for _, holder := range recorder.Holders() {
+		names = append(names, holder.Config["pod"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestPodWatcher() (*PodWatcher, *dryrun.DryRunFactory) {
+	genMeta := kubernetes.NewGenDefaultMeta(nil, nil, nil)
+	watcher := NewPodWatcher(&k8s.Client{}, kubernetes.NewIndexers(nil, genMeta), time.Second, "localhost")
+
+	recorder := dryrun.New()
+	watcher.builders = discoverer.NewBuilder([]builder.Builder{&podBuilder{}}, nil)
+	watcher.builders.SetFactory(recorder)
+
+	return watcher, recorder
+}
+
+func newTestPod(i int) *corev1.Pod {
+	name := fmt.Sprintf("pod-%d", i)
+	return &corev1.Pod{
+		Metadata: &metav1.ObjectMeta{
+			Name:            k8s.String(name),
+			Namespace:       k8s.String("default"),
+			Uid:             k8s.String(name),
+			ResourceVersion: k8s.String("1"),
+		},
+	}
+}
+
+// podBuilder generates one config per pod
+type podBuilder struct{}
+
+func (b *podBuilder) Name() string { return "pod_builder" }
+
+func (b *podBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
+	pod := obj.(*kubernetes.Pod)
+	return []*dcommon.ConfigHolder{
+		{
+			Config: common.MapStr{"pod": pod.Metadata.Name},
+			Meta:   dcommon.Meta{dcommon.MetaPodName: pod.Metadata.Name},
+		},
+	}
+}
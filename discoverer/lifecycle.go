@@ -0,0 +1,194 @@
+package discoverer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// Lifecycle starts a set of discoverers against a factory and shuts them down in order:
+// discovery is cancelled, in-flight events are drained and the factory is flushed.
+type Lifecycle struct {
+	sync.Mutex
+	discoverers []*DiscovererPlugin
+	factory     factory.Factory
+	builders    *Builders
+	status      StatusConfig
+	server      *statusServer
+	watcher     *configWatcher
+	reloaded    map[string]bool
+	reloading   sync.WaitGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+	starting    sync.WaitGroup
+	started     chan struct{}
+	running     bool
+	stopped     bool
+	stopOnce    sync.Once
+}
+
+// NewLifecycle creates a lifecycle for the given discoverers
+func NewLifecycle(discoverers []*DiscovererPlugin) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{
+		discoverers: discoverers,
+		ctx:         ctx,
+		cancel:      cancel,
+		started:     make(chan struct{}),
+		status:      defaultStatusConfig(),
+		reloaded:    map[string]bool{},
+	}
+}
+
+// ConfigureReload sets up the discoverer config files that are applied on start and, if
+// reloading is enabled, whenever they change. A nil config or an empty path disables them.
+func (l *Lifecycle) ConfigureReload(cfg *common.Config) error {
+	if cfg == nil {
+		return nil
+	}
+
+	watcher, err := newConfigWatcher(cfg)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.watcher = watcher
+	return nil
+}
+
+// ConfigureStatus reads the settings of the status endpoint which is served while the
+// lifecycle is running. A nil config leaves the endpoint disabled.
+func (l *Lifecycle) ConfigureStatus(cfg *common.Config) error {
+	if cfg == nil {
+		return nil
+	}
+
+	config := defaultStatusConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return fmt.Errorf("Unable to unpack discovery status config due to error: %v", err)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.status = config
+	return nil
+}
+
+// Start runs all discoverers with builders that hand their configs to f. Start does nothing
+// if the lifecycle was already started or stopped.
+func (l *Lifecycle) Start(f factory.Factory) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.running || l.stopped {
+		return
+	}
+	l.running = true
+	l.factory = f
+
+	builders := &Builders{}
+	builders.SetFactory(f)
+	l.builders = builders
+
+	if l.status.Enabled {
+		server, err := newStatusServer(l.status, l)
+		if err != nil {
+			logp.Err("Discovery status endpoint is not available: %v", err)
+		} else {
+			l.server = server
+			server.start()
+		}
+	}
+
+	// Configs from watched files take effect before discovery starts
+	if l.watcher != nil {
+		l.reload()
+	}
+
+	for _, disc := range l.discoverers {
+		d := disc
+		l.starting.Add(1)
+		go func() {
+			defer l.starting.Done()
+			d.Discoverer.Start(l.ctx, builders)
+		}()
+	}
+
+	go func() {
+		l.starting.Wait()
+		close(l.started)
+	}()
+
+	if l.watcher != nil && l.watcher.config.Reload.Enabled {
+		l.reloading.Add(1)
+		go l.watch()
+	}
+}
+
+// watch reloads discoverers whenever the watched config files change until discovery stops
+func (l *Lifecycle) watch() {
+	defer l.reloading.Done()
+
+	select {
+	case <-l.started:
+	case <-l.ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(l.watcher.config.Reload.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.reload()
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// Started is closed once every discoverer has finished starting up
+func (l *Lifecycle) Started() <-chan struct{} {
+	return l.started
+}
+
+// Stop cancels discovery, waits for all discoverers to process their in-flight events and
+// flushes the factory. Stop can be called multiple times and returns once shutdown is done.
+func (l *Lifecycle) Stop() {
+	l.stopOnce.Do(func() {
+		l.Lock()
+		l.stopped = true
+		running := l.running
+		l.Unlock()
+
+		l.cancel()
+		if l.server != nil {
+			l.server.stop()
+		}
+		if !running {
+			return
+		}
+		l.reloading.Wait()
+
+		for _, disc := range l.discoverers {
+			logp.Info("Stopping %s discoverer", disc.Name)
+			disc.Discoverer.Stop()
+		}
+		l.starting.Wait()
+
+		if flusher, ok := l.factory.(factory.Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				logp.Err("Unable to flush factory due to error: %v", err)
+			}
+		}
+		logp.Info("Discovery stopped")
+	})
+}
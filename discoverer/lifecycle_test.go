@@ -0,0 +1,156 @@
+package discoverer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestLifecycle(t *testing.T) {
+	events := &eventLog{}
+	disc := &fakeDiscoverer{events: events}
+	f := &flushingFactory{events: events}
+
+	l := NewLifecycle([]*DiscovererPlugin{{Name: "fake", Discoverer: disc}})
+	l.Start(f)
+	<-l.Started()
+
+	l.Stop()
+	assert.Equal(t, []string{"start", "cancelled", "stop", "flush"}, events.get())
+
+	// Stopping again and starting after stop do nothing
+	l.Stop()
+	l.Start(f)
+	assert.Equal(t, []string{"start", "cancelled", "stop", "flush"}, events.get())
+}
+
+func TestLifecycleStopBeforeStart(t *testing.T) {
+	events := &eventLog{}
+	disc := &fakeDiscoverer{events: events}
+
+	l := NewLifecycle([]*DiscovererPlugin{{Name: "fake", Discoverer: disc}})
+	l.Stop()
+	l.Start(&flushingFactory{events: events})
+
+	assert.Equal(t, []string(nil), events.get())
+}
+
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (e *eventLog) add(event string) {
+	e.Lock()
+	defer e.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *eventLog) get() []string {
+	e.Lock()
+	defer e.Unlock()
+	return e.events
+}
+
+type fakeDiscoverer struct {
+	events *eventLog
+	ctx    context.Context
+}
+
+func (f *fakeDiscoverer) Start(ctx context.Context, builders *Builders) {
+	f.ctx = ctx
+	f.events.add("start")
+}
+
+func (f *fakeDiscoverer) Stop() {
+	// The context is cancelled before discoverers are stopped
+	<-f.ctx.Done()
+	f.events.add("cancelled")
+	f.events.add("stop")
+}
+
+func (f *fakeDiscoverer) String() string { return "fake" }
+
+type flushingFactory struct {
+	events *eventLog
+}
+
+func (f *flushingFactory) Start(holders []*dcommon.ConfigHolder) error { return nil }
+
+func (f *flushingFactory) Stop(holders []*dcommon.ConfigHolder) error { return nil }
+
+func (f *flushingFactory) Restart(old, new *dcommon.ConfigHolder) error { return nil }
+
+func (f *flushingFactory) Flush() error {
+	f.events.add("flush")
+	return nil
+}
+
+func TestLifecycleReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "discovery.yml")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("fake:\n  builders: [a]\n"), 0600))
+
+	original, _ := common.NewConfigFrom(map[string]interface{}{"builders": []string{"original"}})
+	disc := &reloadingDiscoverer{fakeDiscoverer: fakeDiscoverer{events: &eventLog{}}}
+	l := NewLifecycle([]*DiscovererPlugin{{Name: "fake", Config: original, Discoverer: disc}})
+
+	cfg, _ := common.NewConfigFrom(map[string]interface{}{
+		"path":           filepath.Join(dir, "*.yml"),
+		"reload.enabled": true,
+		"reload.period":  "10ms",
+	})
+	assert.Nil(t, l.ConfigureReload(cfg))
+
+	// The files are applied before the discoverer starts
+	l.Start(&flushingFactory{events: &eventLog{}})
+	<-l.Started()
+	assert.Equal(t, []string{"reload [a]", "start"}, disc.events.get())
+
+	// Removing the files goes back to the original config
+	assert.Nil(t, os.Remove(file))
+	waitFor(t, func() bool { return len(disc.events.get()) == 3 })
+	assert.Equal(t, "reload [original]", disc.events.get()[2])
+
+	l.Stop()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type reloadingDiscoverer struct {
+	fakeDiscoverer
+}
+
+func (r *reloadingDiscoverer) Reload(cfg *common.Config) error {
+	config := struct {
+		Builders []string `config:"builders"`
+	}{}
+	if err := cfg.Unpack(&config); err != nil {
+		return err
+	}
+	r.events.add(fmt.Sprintf("reload %v", config.Builders))
+	return nil
+}
@@ -0,0 +1,82 @@
+package discoverer
+
+import (
+	"sort"
+
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// PluginInfo describes a plugin that is compiled in
+type PluginInfo struct {
+	Name string `json:"name"`
+	// EnabledByDefault is set for plugins that run without being configured
+	EnabledByDefault bool `json:"enabled_by_default"`
+	// Defaults is the config a plugin that is enabled by default runs with
+	Defaults common.MapStr `json:"defaults,omitempty"`
+	Schema   schema.Schema `json:"schema,omitempty"`
+}
+
+// Catalog lists all compiled in plugins by kind
+type Catalog struct {
+	Discoverers []PluginInfo `json:"discoverers"`
+	Builders    []PluginInfo `json:"builders"`
+	Appenders   []PluginInfo `json:"appenders"`
+	Factories   []PluginInfo `json:"factories"`
+}
+
+// Plugins describes all registered discoverers, builders, appenders and factories. Builders
+// and appenders are only enabled by default once the beat registered its default configs.
+func Plugins() *Catalog {
+	catalog := &Catalog{}
+
+	names := make([]string, 0, len(discovererPlugins))
+	for name := range discovererPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		catalog.Discoverers = append(catalog.Discoverers, PluginInfo{
+			Name:   name,
+			Schema: discovererSchemas[name],
+		})
+	}
+
+	defaultBuilders := registry.BuilderRegistry.GetDefaultBuilderConfigs()
+	for _, name := range registry.BuilderRegistry.BuilderNames() {
+		catalog.Builders = append(catalog.Builders,
+			pluginInfo(name, defaultBuilders, registry.BuilderRegistry.GetBuilderSchema(name)))
+	}
+
+	defaultAppenders := registry.BuilderRegistry.GetDefaultAppenderConfigs()
+	for _, name := range registry.BuilderRegistry.AppenderNames() {
+		s := append(schema.Schema{}, registry.BuilderRegistry.GetAppenderSchema(name)...)
+		catalog.Appenders = append(catalog.Appenders,
+			pluginInfo(name, defaultAppenders, append(s, appender.OptionsSchema...)))
+	}
+
+	for _, name := range factory.Plugins() {
+		catalog.Factories = append(catalog.Factories, PluginInfo{
+			Name:             name,
+			EnabledByDefault: name == factory.DefaultFactory,
+			Schema:           factory.Schema(name),
+		})
+	}
+
+	return catalog
+}
+
+func pluginInfo(name string, defaults map[string]common.Config, s schema.Schema) PluginInfo {
+	info := PluginInfo{Name: name, Schema: s}
+
+	if cfg, ok := defaults[name]; ok {
+		info.EnabledByDefault = true
+		info.Defaults = common.MapStr{}
+		cfg.Unpack(&info.Defaults)
+	}
+	return info
+}
@@ -0,0 +1,63 @@
+package discoverer
+
+import (
+	"testing"
+
+	"github.com/ebay/collectbeat/discoverer/common/appender"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+	"github.com/ebay/collectbeat/discoverer/common/registry"
+	"github.com/ebay/collectbeat/discoverer/common/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestPlugins(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"prefix": "io.collectbeat.logs"})
+	assert.Nil(t, err)
+	registry.BuilderRegistry.AddDefaultBuilderConfig("log_annotations", *cfg)
+
+	RegisterDiscovererPlugin("fake", func(*common.Config) (Discoverer, error) { return nil, nil })
+	RegisterDiscovererSchema("fake", schema.Schema{{Name: "host", Type: schema.String}})
+
+	catalog := Plugins()
+
+	fake := findPlugin(catalog.Discoverers, "fake")
+	if assert.NotNil(t, fake) {
+		assert.False(t, fake.EnabledByDefault)
+		assert.Len(t, fake.Schema, 1)
+	}
+
+	logs := findPlugin(catalog.Builders, "log_annotations")
+	if assert.NotNil(t, logs) {
+		assert.True(t, logs.EnabledByDefault)
+		assert.Equal(t, common.MapStr{"prefix": "io.collectbeat.logs"}, logs.Defaults)
+		assert.NotEmpty(t, logs.Schema)
+	}
+
+	secrets := findPlugin(catalog.Builders, "metrics_secret")
+	if assert.NotNil(t, secrets) {
+		assert.False(t, secrets.EnabledByDefault)
+		assert.Nil(t, secrets.Defaults)
+	}
+
+	auth := findPlugin(catalog.Appenders, "auth")
+	if assert.NotNil(t, auth) {
+		assert.True(t, auth.EnabledByDefault)
+		assert.Contains(t, auth.Schema, appender.OptionsSchema[0])
+	}
+
+	for _, plugin := range catalog.Factories {
+		assert.Equal(t, plugin.Name == factory.DefaultFactory, plugin.EnabledByDefault, plugin.Name)
+	}
+	assert.NotNil(t, findPlugin(catalog.Factories, "multi"))
+}
+
+func findPlugin(plugins []PluginInfo, name string) *PluginInfo {
+	for i := range plugins {
+		if plugins[i].Name == name {
+			return &plugins[i]
+		}
+	}
+	return nil
+}
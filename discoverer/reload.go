@@ -0,0 +1,120 @@
+package discoverer
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ebay/collectbeat/discoverer/common/metrics"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/libbeat/paths"
+)
+
+var (
+	configReloads  = monitoring.NewInt(metrics.Registry, "config.reloads")
+	configFailures = monitoring.NewInt(metrics.Registry, "config.failures")
+)
+
+// configWatcher reads discoverer configs from the files matching a glob and reports changes.
+// The files hold the same settings as the discovery section, keyed by discoverer name.
+type configWatcher struct {
+	config  cfgfile.DynamicConfig
+	watcher *cfgfile.GlobWatcher
+}
+
+func newConfigWatcher(cfg *common.Config) (*configWatcher, error) {
+	config := cfgfile.DefaultDynamicConfig
+	if err := cfg.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("Unable to unpack discovery config reload settings due to error: %v", err)
+	}
+
+	if config.Path == "" {
+		return nil, nil
+	}
+
+	path := config.Path
+	if !filepath.IsAbs(path) {
+		path = paths.Resolve(paths.Config, path)
+	}
+
+	return &configWatcher{config: config, watcher: cfgfile.NewGlobWatcher(path)}, nil
+}
+
+// scan returns the discoverer configs if the watched files changed since the last scan
+func (c *configWatcher) scan() (map[string]*common.Config, bool, error) {
+	files, changed, err := c.watcher.Scan()
+	if err != nil || !changed {
+		return nil, false, err
+	}
+
+	configs := map[string]*common.Config{}
+	if len(files) == 0 {
+		return configs, true, nil
+	}
+
+	cfg, err := common.LoadFiles(files...)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to load discovery config files due to error: %v", err)
+	}
+
+	if err = cfg.Unpack(&configs); err != nil {
+		return nil, false, fmt.Errorf("Unable to unpack discovery config files due to error: %v", err)
+	}
+	return configs, true, nil
+}
+
+// reload scans the watched files and hands changed configs to the discoverers
+func (l *Lifecycle) reload() {
+	configs, changed, err := l.watcher.scan()
+	if err != nil {
+		configFailures.Inc()
+		logp.Err("Discovery config reload failed: %v", err)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	configReloads.Inc()
+	for name, cfg := range configs {
+		if !l.reloadDiscoverer(name, cfg) {
+			logp.Warn("Discoverer %s can not be reloaded, it must be configured and support reloading", name)
+		}
+	}
+
+	// Discoverers that were removed from the files go back to their original config
+	for _, disc := range l.discoverers {
+		if _, ok := configs[disc.Name]; !ok && l.reloaded[disc.Name] {
+			l.reloadDiscoverer(disc.Name, disc.Config)
+			delete(l.reloaded, disc.Name)
+		}
+	}
+	for name := range configs {
+		l.reloaded[name] = true
+	}
+}
+
+func (l *Lifecycle) reloadDiscoverer(name string, cfg *common.Config) bool {
+	for _, disc := range l.discoverers {
+		if disc.Name != name {
+			continue
+		}
+
+		reloader, ok := disc.Discoverer.(Reloader)
+		if !ok {
+			return false
+		}
+
+		if err := reloader.Reload(cfg); err != nil {
+			configFailures.Inc()
+			logp.Err("Unable to reload %s discoverer due to error: %v", name, err)
+		} else {
+			logp.Info("Reloaded %s discoverer", name)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package discoverer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/ebay/collectbeat/discoverer/common/factory"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// StatusConfig configures the HTTP endpoint that reports what discovery found
+type StatusConfig struct {
+	Enabled bool   `config:"enabled"`
+	Host    string `config:"host"`
+	Port    int    `config:"port"`
+}
+
+func defaultStatusConfig() StatusConfig {
+	return StatusConfig{
+		Host: "localhost",
+		Port: 5067,
+	}
+}
+
+// statusServer serves the state of a lifecycle's discoverers, builders and factory as JSON
+type statusServer struct {
+	lifecycle *Lifecycle
+	listener  net.Listener
+	server    *http.Server
+}
+
+func newStatusServer(config StatusConfig, l *Lifecycle) (*statusServer, error) {
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen on %s due to error: %v", address, err)
+	}
+
+	s := &statusServer{lifecycle: l, listener: listener}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/discovery", s.summaryHandler)
+	mux.HandleFunc("/discovery/discoverers", s.discoverersHandler)
+	mux.HandleFunc("/discovery/configs", s.configsHandler)
+	mux.HandleFunc("/discovery/factory", s.factoryHandler)
+	mux.HandleFunc("/discovery/resync", s.resyncHandler)
+	s.server = &http.Server{Handler: mux}
+
+	return s, nil
+}
+
+func (s *statusServer) start() {
+	logp.Info("Discovery status endpoint listening on: %s", s.listener.Addr())
+	go func() {
+		err := s.server.Serve(s.listener)
+		if err != nil && err != http.ErrServerClosed {
+			logp.Err("Discovery status endpoint failed with error: %v", err)
+		}
+	}()
+}
+
+func (s *statusServer) stop() {
+	s.server.Close()
+}
+
+func (s *statusServer) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	discoverers := []string{}
+	for _, disc := range s.lifecycle.discoverers {
+		discoverers = append(discoverers, disc.Name)
+	}
+
+	writeJSON(w, r, common.MapStr{
+		"discoverers": discoverers,
+		"configs":     len(s.lifecycle.builders.Holders()),
+	})
+}
+
+func (s *statusServer) discoverersHandler(w http.ResponseWriter, r *http.Request) {
+	data := common.MapStr{}
+	for _, disc := range s.lifecycle.discoverers {
+		if reporter, ok := disc.Discoverer.(StatusReporter); ok {
+			data[disc.Name] = reporter.Status()
+		} else {
+			data[disc.Name] = nil
+		}
+	}
+	writeJSON(w, r, data)
+}
+
+// configGroup lists the configs one builder generated for one pod
+type configGroup struct {
+	Namespace string          `json:"namespace,omitempty"`
+	Pod       string          `json:"pod,omitempty"`
+	Builder   string          `json:"builder,omitempty"`
+	Configs   []common.MapStr `json:"configs"`
+	Meta      []dcommon.Meta  `json:"meta"`
+}
+
+// redactedKeys are the config settings that hold credentials. Their values are replaced before
+// configs are served.
+var redactedKeys = []string{"headers.Authorization", "password", "ssl.key", "ssl.key_passphrase"}
+
+const redacted = "xxxxx"
+
+// configsHandler lists the current configs per pod and builder. The namespace, pod and
+// builder query parameters filter the result. Credentials are redacted, but the configs and
+// meta still expose pod metadata such as labels and annotations.
+func (s *statusServer) configsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	groups := []*configGroup{}
+	var last *configGroup
+	for _, holder := range s.lifecycle.builders.Holders() {
+		meta := holder.Meta
+		namespace := meta.GetString(dcommon.MetaNamespace)
+		pod := meta.GetString(dcommon.MetaPodName)
+		builder := meta.GetString(dcommon.MetaBuilder)
+
+		if !matches(query.Get("namespace"), namespace) || !matches(query.Get("pod"), pod) ||
+			!matches(query.Get("builder"), builder) {
+			continue
+		}
+
+		if last == nil || last.Namespace != namespace || last.Pod != pod || last.Builder != builder {
+			last = &configGroup{Namespace: namespace, Pod: pod, Builder: builder}
+			groups = append(groups, last)
+		}
+		last.Configs = append(last.Configs, redact(holder.Config, "").(common.MapStr))
+		last.Meta = append(last.Meta, meta)
+	}
+	writeJSON(w, r, groups)
+}
+
+func (s *statusServer) factoryHandler(w http.ResponseWriter, r *http.Request) {
+	if reporter, ok := s.lifecycle.factory.(factory.StatusReporter); ok {
+		writeJSON(w, r, reporter.Status())
+		return
+	}
+	writeJSON(w, r, nil)
+}
+
+// resyncHandler forces all discoverers that support it to resync
+func (s *statusServer) resyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "resync requires a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := common.MapStr{}
+	for _, disc := range s.lifecycle.discoverers {
+		resyncer, ok := disc.Discoverer.(Resyncer)
+		if !ok {
+			continue
+		}
+
+		if err := resyncer.Resync(); err != nil {
+			logp.Err("Resync of %s discoverer failed due to error: %v", disc.Name, err)
+			data[disc.Name] = err.Error()
+		} else {
+			data[disc.Name] = "ok"
+		}
+	}
+	writeJSON(w, r, data)
+}
+
+// redact returns a copy of value with the settings in redactedKeys replaced. path is the dotted
+// key of value in the config.
+func redact(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case common.MapStr:
+		return redactMap(v, path)
+	case map[string]interface{}:
+		return redactMap(common.MapStr(v), path)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = redact(item, path)
+		}
+		return list
+	case []common.MapStr:
+		list := make([]common.MapStr, len(v))
+		for i, item := range v {
+			list[i] = redactMap(item, path)
+		}
+		return list
+	}
+	return value
+}
+
+func redactMap(m common.MapStr, path string) common.MapStr {
+	out := common.MapStr{}
+	for key, value := range m {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		if isRedacted(keyPath) {
+			out[key] = redacted
+		} else {
+			out[key] = redact(value, keyPath)
+		}
+	}
+	return out
+}
+
+func isRedacted(path string) bool {
+	for _, key := range redactedKeys {
+		if path == key || strings.HasSuffix(path, "."+key) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(filter, value string) bool {
+	return filter == "" || filter == value
+}
+
+// writeJSON writes data as JSON, indented if the pretty query parameter is set
+func writeJSON(w http.ResponseWriter, r *http.Request, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	encoder := json.NewEncoder(w)
+	if _, ok := r.URL.Query()["pretty"]; ok {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(data); err != nil {
+		logp.Err("Unable to encode discovery status due to error: %v", err)
+	}
+}
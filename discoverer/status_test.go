@@ -0,0 +1,183 @@
+package discoverer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestStatusServer(t *testing.T) {
+	disc := &statusDiscoverer{}
+	l := NewLifecycle([]*DiscovererPlugin{
+		{Name: "status", Discoverer: disc},
+		{Name: "fake", Discoverer: &fakeDiscoverer{events: &eventLog{}}},
+	})
+	l.Start(&flushingFactory{events: &eventLog{}})
+	<-l.Started()
+	defer l.Stop()
+
+	s := &statusServer{lifecycle: l}
+
+	data := map[string]interface{}{}
+	get(t, s.summaryHandler, "/discovery", &data)
+	assert.Equal(t, float64(2), data["configs"])
+	assert.Equal(t, []interface{}{"status", "fake"}, data["discoverers"])
+
+	data = map[string]interface{}{}
+	get(t, s.discoverersHandler, "/discovery/discoverers", &data)
+	assert.Equal(t, map[string]interface{}{"pods": float64(2)}, data["status"])
+	assert.Nil(t, data["fake"])
+
+	groups := []configGroup{}
+	get(t, s.configsHandler, "/discovery/configs?pod=web", &groups)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "web", groups[0].Pod)
+		assert.Equal(t, "poller", groups[0].Builder)
+		assert.Equal(t, []common.MapStr{{"module": "web"}}, groups[0].Configs)
+	}
+
+	// The factory does not report a status
+	var factoryStatus interface{}
+	get(t, s.factoryHandler, "/discovery/factory", &factoryStatus)
+	assert.Nil(t, factoryStatus)
+}
+
+func TestRedact(t *testing.T) {
+	config := common.MapStr{
+		"module":   "http",
+		"password": "secret",
+		"headers":  common.MapStr{"Authorization": "Bearer token", "Accept": "*/*"},
+		"ssl.key":  "/etc/key.pem",
+		"ssl": map[string]interface{}{
+			"key_passphrase": "phrase",
+			"certificate":    "/etc/cert.pem",
+		},
+		"processors": []interface{}{
+			map[string]interface{}{"output": map[string]interface{}{"password": "nested"}},
+		},
+	}
+
+	assert.Equal(t, common.MapStr{
+		"module":   "http",
+		"password": redacted,
+		"headers":  common.MapStr{"Authorization": redacted, "Accept": "*/*"},
+		"ssl.key":  redacted,
+		"ssl": common.MapStr{
+			"key_passphrase": redacted,
+			"certificate":    "/etc/cert.pem",
+		},
+		"processors": []interface{}{
+			common.MapStr{"output": common.MapStr{"password": redacted}},
+		},
+	}, redact(config, ""))
+
+	// The original config is left untouched
+	assert.Equal(t, "secret", config["password"])
+	assert.Equal(t, "Bearer token", config["headers"].(common.MapStr)["Authorization"])
+}
+
+func TestStatusServerResync(t *testing.T) {
+	disc := &statusDiscoverer{}
+	l := NewLifecycle([]*DiscovererPlugin{{Name: "status", Discoverer: disc}})
+	s := &statusServer{lifecycle: l}
+
+	recorder := httptest.NewRecorder()
+	s.resyncHandler(recorder, httptest.NewRequest("GET", "/discovery/resync", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, 0, disc.resyncs)
+
+	recorder = httptest.NewRecorder()
+	s.resyncHandler(recorder, httptest.NewRequest("POST", "/discovery/resync", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 1, disc.resyncs)
+
+	disc.err = errors.New("not running")
+	data := map[string]interface{}{}
+	recorder = httptest.NewRecorder()
+	s.resyncHandler(recorder, httptest.NewRequest("POST", "/discovery/resync", nil))
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &data))
+	assert.Equal(t, "not running", data["status"])
+}
+
+func TestLifecycleConfigureStatus(t *testing.T) {
+	l := NewLifecycle(nil)
+	assert.Nil(t, l.ConfigureStatus(nil))
+	assert.False(t, l.status.Enabled)
+
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"enabled": true, "port": 0})
+	assert.Nil(t, err)
+	assert.Nil(t, l.ConfigureStatus(cfg))
+	assert.Equal(t, StatusConfig{Enabled: true, Host: "localhost", Port: 0}, l.status)
+
+	// The endpoint is served while the lifecycle is running
+	l.Start(&flushingFactory{events: &eventLog{}})
+	if assert.NotNil(t, l.server) {
+		resp, err := http.Get("http://" + l.server.listener.Addr().String() + "/discovery")
+		if assert.Nil(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+	l.Stop()
+}
+
+func get(t *testing.T, handler http.HandlerFunc, target string, out interface{}) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", target, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), out))
+}
+
+// statusDiscoverer starts two pods and reports a status
+type statusDiscoverer struct {
+	resyncs int
+	err     error
+}
+
+func (s *statusDiscoverer) Start(ctx context.Context, builders *Builders) {
+	builders.AddBuilder(pollerBuilder{})
+	builders.StartModuleRunners("web")
+	builders.StartModuleRunners("db")
+}
+
+func (s *statusDiscoverer) Stop() {}
+
+func (s *statusDiscoverer) String() string { return "status" }
+
+func (s *statusDiscoverer) Status() interface{} {
+	return common.MapStr{"pods": 2}
+}
+
+func (s *statusDiscoverer) Resync() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.resyncs++
+	return nil
+}
+
+type pollerBuilder struct{}
+
+func (pollerBuilder) Name() string { return "poller" }
+
+func (pollerBuilder) BuildModuleConfigs(obj interface{}) []*dcommon.ConfigHolder {
+	pod := obj.(string)
+	return []*dcommon.ConfigHolder{
+		{
+			Config: common.MapStr{"module": pod},
+			Meta: dcommon.Meta{
+				dcommon.MetaNamespace: "default",
+				dcommon.MetaPodName:   pod,
+				dcommon.MetaBuilder:   "poller",
+			},
+		},
+	}
+}
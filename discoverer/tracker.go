@@ -0,0 +1,23 @@
+package discoverer
+
+import (
+	"fmt"
+
+	dcommon "github.com/ebay/collectbeat/discoverer/common"
+	"github.com/mitchellh/hashstructure"
+)
+
+// newTracker creates the set of holders the factory accepted and that were not stopped since
+func newTracker() *dcommon.HolderSet {
+	return dcommon.NewHolderSet(holderKey)
+}
+
+// holderKey identifies a holder by its config and the pod and builder it was generated for,
+// so that identical configs of different pods or builders are kept apart
+func holderKey(holder *dcommon.ConfigHolder) (string, error) {
+	hash, err := hashstructure.Hash(holder.Config, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%d", dcommon.GroupKey(holder), holder.Builder, hash), nil
+}
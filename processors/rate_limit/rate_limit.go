@@ -0,0 +1,143 @@
+// Package rate_limit provides the rate_limit processor, which drops events over a rate.
+// Processors with the same key and limit share their budget, which lets collectbeat limit all
+// prospectors of a pod or namespace together.
+package rate_limit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/processors"
+)
+
+const Name = "rate_limit"
+
+// idleTimeout is the time after which buckets that are not used are removed. Buckets are full
+// again long before that, so removing them does not change the limit.
+const idleTimeout = 10 * time.Minute
+
+var buckets = newRegistry()
+
+type rateLimit struct {
+	// dropped is updated atomically as harvesters share processors
+	dropped         uint64
+	key             string
+	eventsPerSecond float64
+	burst           int
+	// bucket is the key of the shared bucket. It includes the limit so that processors with
+	// the same key but different limits do not refill one bucket at different rates.
+	bucket string
+}
+
+type config struct {
+	Key             string  `config:"key"`
+	EventsPerSecond float64 `config:"events_per_second" validate:"required"`
+	Burst           int     `config:"burst" validate:"min=0"`
+}
+
+func init() {
+	processors.RegisterPlugin(Name, newRateLimit)
+}
+
+func newRateLimit(c *common.Config) (processors.Processor, error) {
+	config := config{}
+	err := c.Unpack(&config)
+	if err != nil {
+		return nil, fmt.Errorf("fail to unpack the rate_limit configuration: %s", err)
+	}
+	if config.EventsPerSecond <= 0 {
+		return nil, fmt.Errorf("events_per_second of rate_limit must be positive")
+	}
+
+	// Without a burst one second worth of events can be sent at once
+	if config.Burst == 0 {
+		config.Burst = int(config.EventsPerSecond)
+		if config.Burst < 1 {
+			config.Burst = 1
+		}
+	}
+
+	return &rateLimit{
+		key:             config.Key,
+		eventsPerSecond: config.EventsPerSecond,
+		burst:           config.Burst,
+		bucket:          fmt.Sprintf("%s/%v/%d", config.Key, config.EventsPerSecond, config.Burst),
+	}, nil
+}
+
+func (r *rateLimit) Run(event *beat.Event) (*beat.Event, error) {
+	if buckets.take(r.bucket, r.eventsPerSecond, r.burst, time.Now()) {
+		return event, nil
+	}
+
+	dropped := atomic.AddUint64(&r.dropped, 1)
+	if dropped == 1 || dropped%1000 == 0 {
+		logp.Warn("Dropped %d events of %s as they are over %v events per second", dropped, r.key, r.eventsPerSecond)
+	}
+	return nil, nil
+}
+
+func (r *rateLimit) String() string {
+	return fmt.Sprintf("%s=[key=%s, events_per_second=%v, burst=%d]", Name, r.key, r.eventsPerSecond, r.burst)
+}
+
+// bucket is a token bucket that is refilled at a rate up to a size
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type registry struct {
+	sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newRegistry() *registry {
+	return &registry{buckets: map[string]*bucket{}}
+}
+
+// take removes a token from the bucket of a key and returns false when there is none
+func (r *registry) take(key string, rate float64, size int, now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	r.sweep(now)
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(size), updated: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > float64(size) {
+		b.tokens = float64(size)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep removes the buckets of keys that were not used for a while
+func (r *registry) sweep(now time.Time) {
+	if now.Sub(r.swept) < idleTimeout {
+		return
+	}
+	r.swept = now
+
+	for key, b := range r.buckets {
+		if now.Sub(b.updated) > idleTimeout {
+			delete(r.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package rate_limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+	now := time.Now()
+
+	// A full bucket lets a burst through
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.take("pod", 1, 3, now))
+	}
+	assert.False(t, r.take("pod", 1, 3, now))
+
+	// Keys have their own buckets
+	assert.True(t, r.take("other", 1, 3, now))
+
+	// Tokens come back at the rate, up to the size of the bucket
+	assert.True(t, r.take("pod", 1, 3, now.Add(time.Second)))
+	assert.False(t, r.take("pod", 1, 3, now.Add(time.Second)))
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.take("pod", 1, 3, later))
+	}
+	assert.False(t, r.take("pod", 1, 3, later))
+
+	// Idle buckets are removed
+	assert.Len(t, r.buckets, 1)
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"key":               "namespace/TestRateLimit",
+		"events_per_second": 0.001,
+		"burst":             2,
+	})
+	assert.Nil(t, err)
+
+	first, err := newRateLimit(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	second, err := newRateLimit(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	// Processors with the same key share the bucket
+	event := &beat.Event{Fields: common.MapStr{"message": "line"}}
+	out, _ := first.Run(event)
+	assert.Equal(t, event, out)
+	out, _ = second.Run(event)
+	assert.Equal(t, event, out)
+	out, _ = first.Run(event)
+	assert.Nil(t, out)
+	out, _ = second.Run(event)
+	assert.Nil(t, out)
+
+	// Processors with the same key but another limit have their own bucket
+	assert.Nil(t, cfg.SetInt("burst", -1, 1))
+	other, err := newRateLimit(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	out, _ = other.Run(event)
+	assert.Equal(t, event, out)
+	out, _ = other.Run(event)
+	assert.Nil(t, out)
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{},
+		{"events_per_second": 0},
+		{"events_per_second": -1},
+		{"events_per_second": 1, "burst": -1},
+	} {
+		cfg, err := common.NewConfigFrom(raw)
+		assert.Nil(t, err)
+		_, err = newRateLimit(cfg)
+		assert.NotNil(t, err, "%v", raw)
+	}
+
+	cfg, err := common.NewConfigFrom(map[string]interface{}{"events_per_second": 0.5})
+	assert.Nil(t, err)
+	p, err := newRateLimit(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, p.(*rateLimit).burst)
+}
@@ -0,0 +1,85 @@
+// Package rename provides the rename processor, which moves fields of an event to new keys.
+// Collectbeat uses it to hand the log line of Docker JSON logs to filebeat module pipelines
+// as message.
+package rename
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/processors"
+)
+
+const Name = "rename"
+
+type rename struct {
+	fields        []fromTo
+	ignoreMissing bool
+}
+
+type fromTo struct {
+	From string `config:"from" validate:"required"`
+	To   string `config:"to" validate:"required"`
+}
+
+func init() {
+	processors.RegisterPlugin(Name, newRename)
+}
+
+func newRename(c *common.Config) (processors.Processor, error) {
+	config := struct {
+		Fields        []fromTo `config:"fields" validate:"required"`
+		IgnoreMissing bool     `config:"ignore_missing"`
+	}{
+		IgnoreMissing: true,
+	}
+	err := c.Unpack(&config)
+	if err != nil {
+		return nil, fmt.Errorf("fail to unpack the rename configuration: %s", err)
+	}
+
+	for _, field := range config.Fields {
+		for _, readOnly := range processors.MandatoryExportedFields {
+			if field.From == readOnly || field.To == readOnly {
+				return nil, fmt.Errorf("%s is a read only field, cannot rename", readOnly)
+			}
+		}
+	}
+
+	return &rename{fields: config.Fields, ignoreMissing: config.IgnoreMissing}, nil
+}
+
+func (r *rename) Run(event *beat.Event) (*beat.Event, error) {
+	var errs []string
+
+	for _, field := range r.fields {
+		value, err := event.GetValue(field.From)
+		if err != nil {
+			if !r.ignoreMissing {
+				errs = append(errs, fmt.Sprintf("field %s not found", field.From))
+			}
+			continue
+		}
+
+		if err = event.Delete(field.From); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		event.PutValue(field.To, value)
+	}
+
+	if len(errs) > 0 {
+		return event, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return event, nil
+}
+
+func (r *rename) String() string {
+	fields := make([]string, 0, len(r.fields))
+	for _, field := range r.fields {
+		fields = append(fields, field.From+"->"+field.To)
+	}
+	return Name + "=" + strings.Join(fields, ", ")
+}
@@ -0,0 +1,57 @@
+package rename
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestRename(t *testing.T) {
+	cfg, err := common.NewConfigFrom(map[string]interface{}{
+		"fields": []map[string]string{
+			{"from": "log", "to": "message"},
+			{"from": "docker.stream", "to": "stream"},
+		},
+	})
+	assert.Nil(t, err)
+
+	p, err := newRename(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	event := &beat.Event{Fields: common.MapStr{
+		"log":    "GET / 200",
+		"docker": common.MapStr{"stream": "stdout"},
+	}}
+	event, err = p.Run(event)
+	assert.Nil(t, err)
+	assert.Equal(t, common.MapStr{"message": "GET / 200", "docker": common.MapStr{}, "stream": "stdout"}, event.Fields)
+
+	// Missing fields are ignored unless configured otherwise
+	event, err = p.Run(&beat.Event{Fields: common.MapStr{"message": "plain"}})
+	assert.Nil(t, err)
+	assert.Equal(t, common.MapStr{"message": "plain"}, event.Fields)
+
+	cfg.SetBool("ignore_missing", -1, false)
+	p, err = newRename(cfg)
+	assert.Nil(t, err)
+	_, err = p.Run(&beat.Event{Fields: common.MapStr{"log": "line"}})
+	assert.NotNil(t, err)
+}
+
+func TestRenameConfig(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{},
+		{"fields": []map[string]string{{"from": "log"}}},
+		{"fields": []map[string]string{{"from": "log", "to": "type"}}},
+	} {
+		cfg, err := common.NewConfigFrom(raw)
+		assert.Nil(t, err)
+		_, err = newRename(cfg)
+		assert.NotNil(t, err, "%v", raw)
+	}
+}